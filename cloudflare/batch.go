@@ -0,0 +1,37 @@
+package cloudflare
+
+import (
+	"encoding/json"
+)
+
+// maxBatchSize is the number of changes Cloudflare accepts in a single batch
+// request on the free plan.
+const maxBatchSize = 200
+
+// DnsRecordPatch holds the fields changed on an existing record. Any field left
+// out of the patch keeps its current value in Cloudflare.
+type DnsRecordPatch struct {
+	ID string `json:"id"`
+	IP string `json:"content"`
+}
+
+type batchRequest struct {
+	Patches []DnsRecordPatch `json:"patches"`
+}
+
+type BatchResponse struct {
+	Success bool             `json:"success"`
+	Errors  []ResponseErrors `json:"errors"`
+	Result  struct {
+		Patches []DnsRecord `json:"patches"`
+	} `json:"result"`
+}
+
+func unmarshalBatchResponse(response []byte) (BatchResponse, error) {
+	var batchResp BatchResponse
+	if err := json.Unmarshal(response, &batchResp); err != nil {
+		return BatchResponse{}, err
+	}
+
+	return batchResp, nil
+}
@@ -13,6 +13,13 @@ import (
 	"time"
 )
 
+// The number of results asked for in each page of a list request: Cloudflare's
+// default for DNS records, and the most it returns for zones.
+const (
+	dnsRecordsPerPage = 100
+	zonesPerPage      = 50
+)
+
 type Client struct {
 	cfg    *config.Config
 	Client *http.Client
@@ -94,25 +101,9 @@ func (c *Client) request(ctx context.Context, method, endpoint string, data inte
 	return respBody, nil
 }
 
+// GetDnsRecords returns every record in the zone.
 func (c *Client) GetDnsRecords() ([]DnsRecord, []ResponseErrors, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	response, err := c.request(ctx, "GET", "/zones/"+c.cfg.ZoneID+"/dns_records", nil)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	dnsRecordsResp, err := unmarshalDnsRecordsResponse(response)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	if !dnsRecordsResp.Success {
-		return nil, dnsRecordsResp.Errors, errors.New("")
-	}
-
-	return dnsRecordsResp.Result, nil, nil
+	return c.listDnsRecords()
 }
 
 func (c *Client) UpdateDnsRecord(record DnsRecord) ([]ResponseErrors, error) {
@@ -136,25 +127,93 @@ func (c *Client) UpdateDnsRecord(record DnsRecord) ([]ResponseErrors, error) {
 	return nil, nil
 }
 
+// ListDnsRecords returns every record in the zone, for listing.
 func (c *Client) ListDnsRecords() ([]DnsRecord, []ResponseErrors, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	return c.listDnsRecords()
+}
 
-	response, err := c.request(ctx, "GET", fmt.Sprintf("/zones/%s/dns_records", c.cfg.ZoneID), nil)
-	if err != nil {
-		return nil, nil, err
+// listDnsRecords reads the records of the zone a page at a time.
+func (c *Client) listDnsRecords() ([]DnsRecord, []ResponseErrors, error) {
+	records := []DnsRecord{}
+	for page := 1; ; page++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		response, err := c.request(ctx, "GET", fmt.Sprintf("/zones/%s/dns_records?per_page=%d&page=%d", c.cfg.ZoneID, dnsRecordsPerPage, page), nil)
+		cancel()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dnsRecordsResp, err := unmarshalDnsRecordsResponse(response)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !dnsRecordsResp.Success {
+			return nil, dnsRecordsResp.Errors, errors.New("")
+		}
+
+		records = append(records, dnsRecordsResp.Result...)
+		if dnsRecordsResp.ResultInfo.lastPage(page) || len(dnsRecordsResp.Result) == 0 {
+			return records, nil, nil
+		}
 	}
+}
 
-	dnsRecordsResp, err := unmarshalDnsRecordsResponse(response)
-	if err != nil {
-		return nil, nil, err
+// ListZones returns every zone the API token has access to.
+func (c *Client) ListZones() ([]Zone, []ResponseErrors, error) {
+	var zones []Zone
+	for page := 1; ; page++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		response, err := c.request(ctx, "GET", fmt.Sprintf("/zones?per_page=%d&page=%d", zonesPerPage, page), nil)
+		cancel()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		zonesResp, err := unmarshalZonesResponse(response)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !zonesResp.Success {
+			return nil, zonesResp.Errors, errors.New("")
+		}
+
+		zones = append(zones, zonesResp.Result...)
+		if zonesResp.ResultInfo.lastPage(page) || len(zonesResp.Result) == 0 {
+			return zones, nil, nil
+		}
 	}
+}
 
-	if !dnsRecordsResp.Success {
-		return nil, dnsRecordsResp.Errors, errors.New("")
+// PatchDnsRecords applies the given patches through the batch endpoint. Large
+// change sets are split into several batches, and while Cloudflare applies each
+// batch atomically, the call as a whole is not: when a batch fails, the records
+// updated by the batches before it are returned along with the error.
+func (c *Client) PatchDnsRecords(patches []DnsRecordPatch) ([]DnsRecord, []ResponseErrors, error) {
+	var updated []DnsRecord
+	for start := 0; start < len(patches); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(patches))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		response, err := c.request(ctx, "POST", fmt.Sprintf("/zones/%s/dns_records/batch", c.cfg.ZoneID), batchRequest{Patches: patches[start:end]})
+		cancel()
+		if err != nil {
+			return updated, nil, err
+		}
+
+		batchResp, err := unmarshalBatchResponse(response)
+		if err != nil {
+			return updated, nil, err
+		}
+
+		if !batchResp.Success {
+			return updated, batchResp.Errors, errors.New("")
+		}
+		updated = append(updated, batchResp.Result.Patches...)
 	}
 
-	return dnsRecordsResp.Result, nil, nil
+	return updated, nil, nil
 }
 
 func unmarshalDnsRecordsResponse(response []byte) (DnsRecordsResponse, error) {
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -123,7 +125,7 @@ func compareDnsRecords(a, b []DnsRecord) bool {
 		return false
 	}
 	for i := range a {
-		if a[i] != b[i] {
+		if !reflect.DeepEqual(a[i], b[i]) {
 			return false
 		}
 	}
@@ -581,3 +583,201 @@ func TestClient_ListDnsRecords(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_PatchDnsRecords(t *testing.T) {
+	tests := []struct {
+		name             string
+		patches          int
+		mockResponse     string
+		expectedRequests int
+		expectedError    bool
+		expectedApiError []ResponseErrors
+	}{
+		{
+			name:             "singleBatch",
+			patches:          3,
+			mockResponse:     `{"success": true, "errors": [], "result": {"patches": [{"id": "r", "name": "a.example.com", "type": "AAAA", "content": "2001:db8::1"}]}}`,
+			expectedRequests: 1,
+		},
+		{
+			name:             "splitBatches",
+			patches:          maxBatchSize + 1,
+			mockResponse:     `{"success": true, "errors": [], "result": {"patches": []}}`,
+			expectedRequests: 2,
+		},
+		{
+			name:             "apiErrorResponse",
+			patches:          1,
+			mockResponse:     `{"success": false, "errors": [{"code": 1004, "message": "DNS Validation Error"}], "result": null}`,
+			expectedRequests: 1,
+			expectedError:    true,
+			expectedApiError: []ResponseErrors{{Code: 1004, Message: "DNS Validation Error"}},
+		},
+		{
+			name:             "invalidJsonResponse",
+			patches:          1,
+			mockResponse:     `invalid-json`,
+			expectedRequests: 1,
+			expectedError:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			mockClient := &http.Client{
+				Transport: RoundTripFunc(func(req *http.Request) *http.Response {
+					requests++
+					if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/zones/mockZoneID/dns_records/batch") {
+						t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewBufferString(tt.mockResponse)),
+						Header:     make(http.Header),
+					}
+				}),
+			}
+
+			client := &Client{
+				cfg: &config.Config{
+					APIToken:  "mockToken",
+					BaseURL:   "https://mockserver.com",
+					UserAgent: "mockUserAgent",
+					ZoneID:    "mockZoneID",
+				},
+				Client: mockClient,
+			}
+
+			patches := make([]DnsRecordPatch, tt.patches)
+			_, apiErrors, err := client.PatchDnsRecords(patches)
+
+			if (err != nil) != tt.expectedError {
+				t.Errorf("expected error %v, got %v", tt.expectedError, err)
+			}
+			if requests != tt.expectedRequests {
+				t.Errorf("expected %d requests, got %d", tt.expectedRequests, requests)
+			}
+			if !compareApiErrors(apiErrors, tt.expectedApiError) {
+				t.Errorf("expected API errors %v, but got %v", tt.expectedApiError, apiErrors)
+			}
+		})
+	}
+}
+
+func TestClient_ListZones(t *testing.T) {
+	tests := []struct {
+		name             string
+		mockResponse     string
+		expectedZones    []Zone
+		expectedError    bool
+		expectedApiError []ResponseErrors
+	}{
+		{
+			name:          "success",
+			mockResponse:  `{"success": true, "errors": [], "result": [{"id": "z1", "name": "example.com", "status": "active"}]}`,
+			expectedZones: []Zone{{ID: "z1", Name: "example.com", Status: "active"}},
+		},
+		{
+			name:             "apiErrorResponse",
+			mockResponse:     `{"success": false, "errors": [{"code": 9109, "message": "Invalid access token"}], "result": null}`,
+			expectedError:    true,
+			expectedApiError: []ResponseErrors{{Code: 9109, Message: "Invalid access token"}},
+		},
+		{
+			name:          "invalidJsonResponse",
+			mockResponse:  `invalid-json`,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &http.Client{
+				Transport: RoundTripFunc(func(req *http.Request) *http.Response {
+					if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/zones") {
+						t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewBufferString(tt.mockResponse)),
+						Header:     make(http.Header),
+					}
+				}),
+			}
+
+			client := &Client{
+				cfg: &config.Config{
+					APIToken:  "mockToken",
+					BaseURL:   "https://mockserver.com",
+					UserAgent: "mockUserAgent",
+				},
+				Client: mockClient,
+			}
+
+			zones, apiErrors, err := client.ListZones()
+
+			if (err != nil) != tt.expectedError {
+				t.Errorf("expected error %v, got %v", tt.expectedError, err)
+			}
+			if !reflect.DeepEqual(zones, tt.expectedZones) {
+				t.Errorf("expected zones %v, got %v", tt.expectedZones, zones)
+			}
+			if !compareApiErrors(apiErrors, tt.expectedApiError) {
+				t.Errorf("expected API errors %v, but got %v", tt.expectedApiError, apiErrors)
+			}
+		})
+	}
+}
+
+func TestClient_ListPages(t *testing.T) {
+	var requests []string
+	mockClient := &http.Client{
+		Transport: RoundTripFunc(func(req *http.Request) *http.Response {
+			requests = append(requests, strings.TrimLeft(req.URL.Path, "/")+"?"+req.URL.RawQuery)
+			page := req.URL.Query().Get("page")
+			var body string
+			if strings.HasSuffix(req.URL.Path, "/zones") {
+				body = `{"success": true, "result": [{"id": "z` + page + `"}], "result_info": {"page": ` + page + `, "total_pages": 2}}`
+			} else {
+				body = `{"success": true, "result": [{"id": "r` + page + `"}], "result_info": {"page": ` + page + `, "total_pages": 3}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+	client := &Client{
+		cfg:    &config.Config{APIToken: "mockToken", BaseURL: "https://mockserver.com", ZoneID: "mockZoneID"},
+		Client: mockClient,
+	}
+
+	records, _, err := client.ListDnsRecords()
+	if err != nil {
+		t.Fatalf("ListDnsRecords() error = %v", err)
+	}
+	if len(records) != 3 || records[0].ID != "r1" || records[2].ID != "r3" {
+		t.Errorf("expected the records of every page, got %+v", records)
+	}
+
+	zones, _, err := client.ListZones()
+	if err != nil {
+		t.Fatalf("ListZones() error = %v", err)
+	}
+	if len(zones) != 2 || zones[1].ID != "z2" {
+		t.Errorf("expected the zones of every page, got %+v", zones)
+	}
+
+	expected := []string{
+		"zones/mockZoneID/dns_records?per_page=100&page=1",
+		"zones/mockZoneID/dns_records?per_page=100&page=2",
+		"zones/mockZoneID/dns_records?per_page=100&page=3",
+		"zones?per_page=50&page=1",
+		"zones?per_page=50&page=2",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+}
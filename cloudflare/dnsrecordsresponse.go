@@ -6,10 +6,11 @@ import (
 )
 
 type DnsRecordsResponse struct {
-	Success  bool             `json:"success"`
-	Errors   []ResponseErrors `json:"errors"`
-	Result   DnsRecords       `json:"result"`
-	Messages []string         `json:"messages"`
+	Success    bool             `json:"success"`
+	Errors     []ResponseErrors `json:"errors"`
+	Result     DnsRecords       `json:"result"`
+	ResultInfo ResultInfo       `json:"result_info"`
+	Messages   []string         `json:"messages"`
 }
 
 type DnsRecords []DnsRecord
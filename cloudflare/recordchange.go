@@ -0,0 +1,8 @@
+package cloudflare
+
+// RecordChange describes a planned change of the address of an existing record.
+type RecordChange struct {
+	Record DnsRecord
+	OldIP  string
+	NewIP  string
+}
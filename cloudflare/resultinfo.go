@@ -0,0 +1,16 @@
+package cloudflare
+
+// ResultInfo describes the page of results in the response to a list request.
+type ResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+}
+
+// lastPage reports whether page is the last page of results. A response without
+// result_info has a single page.
+func (i ResultInfo) lastPage(page int) bool {
+	return page >= i.TotalPages
+}
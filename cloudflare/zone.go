@@ -0,0 +1,28 @@
+package cloudflare
+
+import (
+	"encoding/json"
+)
+
+// Zone is a DNS zone the API token has access to.
+type Zone struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type ZonesResponse struct {
+	Success    bool             `json:"success"`
+	Errors     []ResponseErrors `json:"errors"`
+	Result     []Zone           `json:"result"`
+	ResultInfo ResultInfo       `json:"result_info"`
+}
+
+func unmarshalZonesResponse(response []byte) (ZonesResponse, error) {
+	var zonesResp ZonesResponse
+	if err := json.Unmarshal(response, &zonesResp); err != nil {
+		return ZonesResponse{}, err
+	}
+
+	return zonesResp, nil
+}
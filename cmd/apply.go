@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/lock"
+	"cloudflare-dyndns/updater"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply <plan>",
+	Short: "Apply a plan written by update --plan-out",
+	Long: `Apply a plan written by update --plan-out.
+
+Each record in the plan is first checked to still be in the zone as it was when the plan was made. When
+any has changed or is gone, nothing is written and the exit status is 1, so that a plan that was reviewed
+is never applied over changes made since. Run update --dry-run again to make a new plan.
+
+Applying is not atomic: the records set in the config file are written one at a time, and those moved by
+follow_ip or migrate_prefix in a batch, as during an update. When a write fails, the records already written
+keep their new values, and the failed ones are reported.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		plan, err := updater.ReadPlan(args[0])
+		FatalError(err)
+
+		result, err := applyPlan(cmd, plan)
+		var driftErr *updater.DriftError
+		if errors.As(err, &driftErr) {
+			err = fmt.Errorf("%w. Make a new plan with: cloudflare-dyndns update --dry-run --plan-out %s", err, args[0])
+		}
+		if structuredOutput() {
+			output := newUpdateOutput("", result, err)
+			FatalError(writeOutput(os.Stdout, output, updateOutputHeader, output.rows()))
+		} else if result != nil {
+			printResult(result)
+		}
+		FatalError(err)
+	},
+}
+
+// applyPlan writes the plan to the zone while holding the lock for the config
+// file. The result is nil when the plan could not be started.
+func applyPlan(cmd *cobra.Command, plan *updater.Plan) (*updater.Result, error) {
+	runLock, err := acquireLock(context.Background(), cmd, &cfg)
+	if errors.Is(err, lock.ErrLocked) {
+		return nil, fmt.Errorf("another run using this config file is in progress: %w", err)
+	} else if err != nil {
+		return nil, err
+	}
+	defer runLock.Release()
+
+	return updater.New(&cfg, logger).Apply(context.Background(), plan)
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+
+	addLockFlags(applyCmd)
+	applyCmd.Flags().BoolP("help", "h", false, "Show help for the apply command.")
+}
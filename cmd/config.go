@@ -0,0 +1,535 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/mqtt"
+	"cloudflare-dyndns/notify"
+	"cloudflare-dyndns/prefix"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TwiN/go-color"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The kinds of value a config key can hold.
+const (
+	kindString   = "string"
+	kindDuration = "duration"
+	kindBool     = "bool"
+	kindInt      = "int"
+	kindStrings  = "strings"
+	kindMap      = "map"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Create and check config files.",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file and report every problem found in it.",
+	Long: `Check the config file and report every problem found in it, such as unknown keys, values of the
+wrong type, missing required values and notifiers that cannot be set up. Each problem is reported with
+its key and the line it is on. The exit status is 1 when there are problems.
+
+Secrets set with a _command key are not read, as checking a file should not run the commands in it. Pass
+--resolve-secrets to also run them and report the ones that fail.`,
+	Annotations: map[string]string{skipConfigAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configFile
+		if path == "" {
+			found, err := findConfigFile()
+			FatalError(err)
+			path = found
+		}
+
+		resolveSecrets, err := cmd.Flags().GetBool("resolve-secrets")
+		FatalError(err)
+		problems, err := validateConfig(path, resolveSecrets)
+		FatalError(err)
+
+		for _, problem := range problems {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), problem.format())
+		}
+		if len(problems) > 0 {
+			FatalError(fmt.Sprintf("%d problem(s) found in %s", len(problems), path))
+		}
+		_, _ = fmt.Fprint(cmd.OutOrStdout(), color.With(color.Green, fmt.Sprintf("%s is valid.\n", path)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+
+	configCmd.Flags().BoolP("help", "h", false, "Show help for the config command.")
+	configValidateCmd.Flags().Bool("resolve-secrets", false, "Also run the commands of _command keys, to check the secrets they print.")
+	configValidateCmd.Flags().BoolP("help", "h", false, "Show help for the config validate command.")
+}
+
+// configProblem is something wrong with a config file. Line is 0 when the
+// problem is not on a line of the file, such as a missing required key.
+type configProblem struct {
+	Path    string
+	Key     string
+	Line    int
+	Message string
+}
+
+func (p configProblem) format() string {
+	location := p.Path
+	if p.Line > 0 {
+		location = fmt.Sprintf("%s:%d", p.Path, p.Line)
+	}
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s", location, p.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, p.Key, p.Message)
+}
+
+// configKeys returns the kind of value held by every config key. The keys of
+// arrays of tables are written with [], as in notify.webhooks[].url.
+func configKeys() map[string]string {
+	keys := map[string]string{}
+
+	defaults := viper.New()
+	setConfigDefaults(defaults)
+	for _, key := range defaults.AllKeys() {
+		switch value := defaults.Get(key).(type) {
+		case bool:
+			keys[key] = kindBool
+		case int:
+			keys[key] = kindInt
+		case []string:
+			keys[key] = kindStrings
+		case string:
+			keys[key] = kindString
+			if _, err := time.ParseDuration(value); err == nil {
+				keys[key] = kindDuration
+			}
+		}
+	}
+
+	for block, target := range configBlocks(&config.Config{}) {
+		t := reflect.TypeOf(target).Elem()
+		if t.Kind() == reflect.Slice {
+			block += "[]"
+			t = t.Elem()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := field.Tag.Get("mapstructure")
+			if name == "" {
+				continue
+			}
+			keys[block+"."+name] = fieldKind(field.Type)
+		}
+	}
+	for _, key := range secretKeys {
+		// The values of a table of secrets are already any keys of the table.
+		if strings.HasSuffix(key, ".*") {
+			continue
+		}
+		keys[key+"_file"] = kindString
+		keys[key+"_command"] = kindString
+	}
+
+	return keys
+}
+
+// fieldKind returns the kind of value decoded into a field of the given type.
+func fieldKind(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		// Optional values, such as records[].proxied.
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return kindDuration
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return kindBool
+	case reflect.Int:
+		return kindInt
+	case reflect.Slice:
+		return kindStrings
+	case reflect.Map:
+		return kindMap
+	default:
+		return kindString
+	}
+}
+
+// checkKind returns an error when a value read from the file is not of the kind.
+func checkKind(kind string, value interface{}) error {
+	switch kind {
+	case kindDuration:
+		if s, ok := value.(string); ok {
+			if _, err := time.ParseDuration(s); err == nil {
+				return nil
+			}
+		}
+		return errors.New(`must be a duration such as "5m"`)
+	case kindBool:
+		if _, ok := value.(bool); !ok {
+			return errors.New("must be true or false")
+		}
+	case kindInt:
+		switch n := value.(type) {
+		case int, int64, uint64:
+		case float64:
+			// JSON numbers.
+			if n != float64(int64(n)) {
+				return errors.New("must be a whole number")
+			}
+		default:
+			return errors.New("must be a whole number")
+		}
+	case kindStrings:
+		list, ok := value.([]interface{})
+		for _, item := range list {
+			if _, isString := item.(string); !isString {
+				ok = false
+			}
+		}
+		if !ok {
+			return errors.New(`must be a list of strings such as ["a", "b"]`)
+		}
+	case kindMap:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return errors.New("must be a table")
+		}
+	default:
+		if _, ok := value.(string); !ok {
+			return errors.New("must be a string")
+		}
+	}
+
+	return nil
+}
+
+var arrayIndex = regexp.MustCompile(`\[\d+\]`)
+
+// lookupValue returns the value at a key path such as notify.webhooks[0].url.
+func lookupValue(raw map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = raw
+	for _, part := range strings.Split(path, ".") {
+		name, index, isIndexed := strings.Cut(part, "[")
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = table[name]; !ok {
+			return nil, false
+		}
+		if isIndexed {
+			i, err := strconv.Atoi(strings.TrimSuffix(index, "]"))
+			list, ok := value.([]interface{})
+			if err != nil || !ok || i >= len(list) {
+				return nil, false
+			}
+			value = list[i]
+		}
+	}
+
+	return value, true
+}
+
+// configChecker collects the problems found in a config file and the files in
+// its conf.d directory.
+type configChecker struct {
+	path string
+	// runCommands runs the commands of secrets set with <key>_command.
+	runCommands bool
+	// order holds the files in the order they are merged.
+	order    []string
+	lines    map[string]int
+	files    map[string]string
+	problems []configProblem
+}
+
+// add records a problem with the given key, on the line of the key or of the
+// closest table containing it. Only the first problem with each key is kept.
+func (c *configChecker) add(key string, err error) {
+	for _, problem := range c.problems {
+		if problem.Key == key {
+			return
+		}
+	}
+
+	path, line := c.path, 0
+	if found := c.locate(key); found != "" {
+		path, line = c.files[found], c.lines[found]
+	}
+
+	c.problems = append(c.problems, configProblem{Path: path, Key: key, Line: line, Message: err.Error()})
+}
+
+// locate returns key, or the closest table containing it, that is in the files.
+func (c *configChecker) locate(key string) string {
+	for path := key; path != ""; {
+		if _, ok := c.lines[path]; ok {
+			return path
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+
+	return ""
+}
+
+// profileKey returns the key a problem found while checking a profile is
+// reported with: the key within the profile's table, unless the value is
+// inherited from the shared settings.
+func (c *configChecker) profileKey(profile, key string) string {
+	if profile == "" {
+		return key
+	}
+	prefix := "profiles." + profile + "."
+	shared := len(c.locate(key))
+	inProfile := len(c.locate(prefix+key)) - len(prefix)
+	if shared > inProfile {
+		return key
+	}
+
+	return prefix + key
+}
+
+// addErrors records every config.KeyError in err, and err itself when it holds
+// none, for the given profile.
+func (c *configChecker) addErrors(profile string, err error) {
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+
+	for _, err := range errs {
+		var keyErr *config.KeyError
+		if _, ok := err.(interface{ Unwrap() []error }); ok {
+			c.addErrors(profile, err)
+		} else if errors.As(err, &keyErr) {
+			c.add(c.profileKey(profile, keyErr.Key), keyErr.Err)
+		} else {
+			if profile != "" {
+				err = fmt.Errorf("profile %s: %w", profile, err)
+			}
+			c.problems = append(c.problems, configProblem{Path: c.path, Message: err.Error()})
+		}
+	}
+}
+
+// validateConfig checks the config file at path and the files in its conf.d
+// directory, and returns every problem found in them. The commands of secrets
+// are only run when runCommands is true. An error is only returned when a file
+// cannot be read.
+func validateConfig(path string, runCommands bool) ([]configProblem, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &configChecker{path: path, runCommands: runCommands, order: files, lines: map[string]int{}, files: map[string]string{}}
+	known := configKeys()
+	contents := map[string][]byte{}
+	parsed := true
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		contents[file] = data
+		ok, err := c.checkFile(file, data, known)
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsed && ok
+	}
+	// The settings cannot be checked while a file cannot be parsed.
+	if !parsed {
+		return c.sorted(), nil
+	}
+
+	// Each profile is checked with the shared settings it inherits.
+	settings := func(profile string) (*viper.Viper, error) {
+		v := viper.New()
+		setConfigDefaults(v)
+		for _, file := range files {
+			raw, err := config.Decode(contents[file], config.FormatOf(file))
+			if err != nil {
+				return nil, err
+			}
+			if err := v.MergeConfigMap(raw); err != nil {
+				return nil, err
+			}
+		}
+		if profile != "" {
+			if err := applyProfile(v, profile); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+	shared, err := settings("")
+	if err != nil {
+		return nil, err
+	}
+	profiles := configProfiles(shared)
+	if len(profiles) == 0 {
+		profiles = []string{""}
+	}
+	for _, profile := range profiles {
+		v, err := settings(profile)
+		if err != nil {
+			c.addErrors(profile, err)
+			continue
+		}
+		c.checkSettings(v, profile)
+	}
+
+	return c.sorted(), nil
+}
+
+// checkFile checks every key in a file is known and holds the right kind of
+// value. It returns false when the file cannot be parsed, which is reported as
+// a problem without a key.
+func (c *configChecker) checkFile(file string, data []byte, known map[string]string) (bool, error) {
+	format := config.FormatOf(file)
+	raw, err := config.Decode(data, format)
+	var syntaxErr *config.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		c.problems = append(c.problems, configProblem{Path: file, Line: syntaxErr.Line, Message: syntaxErr.Error()})
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	keys, err := config.Keys(data, format)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range keys {
+		path := strings.ToLower(key.Path)
+		c.lines[path] = key.Line
+		c.files[path] = file
+	}
+
+	for _, key := range keys {
+		path := strings.ToLower(key.Path)
+		generic := arrayIndex.ReplaceAllString(path, "[]")
+		// The keys of a profile are those of the shared settings.
+		if parts := strings.SplitN(generic, ".", 3); parts[0] == "profiles" {
+			if len(parts) < 3 {
+				continue
+			}
+			generic = parts[2]
+		}
+		if kind, ok := known[generic]; ok {
+			if value, ok := lookupValue(raw, path); ok {
+				if err := checkKind(kind, value); err != nil {
+					c.add(path, err)
+				}
+			}
+			continue
+		}
+		if knownKeyPrefix(known, generic) {
+			continue
+		}
+		c.add(path, errors.New("unknown key"))
+	}
+
+	return true, nil
+}
+
+// checkSettings checks the settings of a profile, or of the whole config file
+// when profile is empty, can be loaded and used.
+func (c *configChecker) checkSettings(v *viper.Viper, profile string) {
+	// Blocks that cannot be decoded are reported here, and everything else is
+	// checked below so that all of it is reported at once.
+	loaded, err := loadConfig(v, profile, c.runCommands)
+	var keyErr *config.KeyError
+	if errors.As(err, &keyErr) {
+		c.addErrors(profile, err)
+	}
+
+	add := func(key string, err error) {
+		c.add(c.profileKey(profile, key), err)
+	}
+	if loaded.APIToken == "" {
+		add("cloudflare.api_token", errors.New("must be set"))
+	}
+	if loaded.ZoneID == "" {
+		add("cloudflare.zone_id", errors.New("must be set"))
+	}
+	if len(loaded.RecordNames()) == 0 {
+		add("cloudflare.update_records", errors.New("must list at least one record, unless [[records]] are set"))
+	}
+	if loaded.Interval <= 0 {
+		add("daemon.interval", errors.New("must be a positive duration"))
+	}
+	if loaded.PrefixLength < prefix.MinLength || loaded.PrefixLength > prefix.MaxLength {
+		add("ipv6.prefix_length", fmt.Errorf("must be between %d and %d", prefix.MinLength, prefix.MaxLength))
+	}
+	for i, hook := range loaded.RecordHooks {
+		if hook.Name == "" {
+			add(fmt.Sprintf("hooks.records[%d].name", i), errors.New("must be set"))
+		}
+	}
+	if _, err := notify.New(&loaded, zerolog.Nop()); err != nil {
+		c.addErrors(profile, err)
+	}
+	if loaded.MQTT.Broker != "" {
+		if _, err := mqtt.New(loaded.MQTT, Version, zerolog.Nop()); err != nil {
+			c.addErrors(profile, err)
+		}
+	}
+}
+
+// knownKeyPrefix reports whether path is a table containing known keys, or a
+// key within a table of any keys such as webhook headers.
+func knownKeyPrefix(known map[string]string, path string) bool {
+	for key, kind := range known {
+		if strings.HasPrefix(key, path+".") || strings.HasPrefix(key, path+"[].") {
+			return true
+		}
+		if kind == kindMap && strings.HasPrefix(path, key+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sorted returns the problems in the order of the files and lines they are on,
+// with problems not on a line last.
+func (c *configChecker) sorted() []configProblem {
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i], c.problems[j]
+		if a.Line == 0 || b.Line == 0 {
+			return a.Line != 0 && b.Line == 0
+		}
+		if a.Path != b.Path {
+			return slices.Index(c.order, a.Path) < slices.Index(c.order, b.Path)
+		}
+		return a.Line < b.Line
+	})
+
+	return c.problems
+}
@@ -0,0 +1,450 @@
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []configProblem
+	}{
+		{
+			name: "valid",
+			content: `
+[cloudflare]
+api_token = "token"
+zone_id = "zone"
+update_records = ["home.example.com"]
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/dyndns"
+headers = { Authorization = "Bearer secret" }
+`,
+		},
+		{
+			name: "syntax_error",
+			content: `
+[cloudflare
+api_token = "token"
+`,
+			expected: []configProblem{{Line: 2}},
+		},
+		{
+			name: "every_problem",
+			content: `
+[main]
+home_gatway = "192.168.1.1"
+reconcile_interval = "often"
+
+[cloudflare]
+zone_id = "zone"
+update_records = "home.example.com"
+
+[ipv6]
+prefix_length = 80
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/dyndns"
+
+[[notify.webhooks]]
+events = ["ip_changed", "reboot"]
+
+[mqtt]
+broker = "tcp://localhost:1883"
+qos = 3
+`,
+			expected: []configProblem{
+				{Key: "main.home_gatway", Line: 3, Message: "unknown key"},
+				{Key: "main.reconcile_interval", Line: 4, Message: `must be a duration such as "5m"`},
+				{Key: "cloudflare.api_token", Line: 6, Message: "must be set"},
+				{Key: "cloudflare.update_records", Line: 8, Message: `must be a list of strings such as ["a", "b"]`},
+				{Key: "ipv6.prefix_length", Line: 11, Message: "must be between 48 and 64"},
+				{Key: "notify.webhooks[1]", Line: 16, Message: "url is required"},
+				{Key: "mqtt.qos", Line: 21, Message: "must be 0, 1 or 2"},
+			},
+		},
+		{
+			name: "missing_values",
+			content: `
+[cloudflare]
+zone_id = "zone"
+update_records = []
+
+[ipv6]
+prefix_length = 80
+
+[[hooks.records]]
+post_update = "true"
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/dyndns"
+
+[[notify.webhooks]]
+events = ["ip_changed", "reboot"]
+
+[mqtt]
+broker = "tcp://localhost:1883"
+qos = 3
+`,
+			expected: []configProblem{
+				{Key: "cloudflare.api_token", Line: 2, Message: "must be set"},
+				{Key: "cloudflare.update_records", Line: 4, Message: "must list at least one record"},
+				{Key: "ipv6.prefix_length", Line: 7, Message: "must be between 48 and 64"},
+				{Key: "hooks.records[0].name", Line: 9, Message: "must be set"},
+				{Key: "notify.webhooks[1]", Line: 15, Message: "url is required"},
+				{Key: "mqtt.qos", Line: 20, Message: "must be 0, 1 or 2"},
+			},
+		},
+		{
+			name: "records",
+			content: `
+[cloudflare]
+api_token = "token"
+zone_id = "zone"
+
+[[records]]
+name = "home.example.com"
+types = ["A", "MX"]
+ttl = 10
+proxied = true
+
+[[records]]
+ip_source = "ftp://example.com"
+comment = "{{.IP"
+`,
+			expected: []configProblem{
+				{Key: "records[0].types", Line: 8, Message: `"MX" is not "A" or "AAAA"`},
+				{Key: "records[0].ttl", Line: 9, Message: "must be 1 for automatic"},
+				{Key: "records[1].name", Line: 12, Message: "must be set"},
+				{Key: "records[1].ip_source", Line: 13, Message: `"ftp://example.com" is not`},
+				{Key: "records[1].comment", Line: 14, Message: "unclosed action"},
+			},
+		},
+		{
+			name: "network",
+			content: `
+[main]
+home_gateway = "router"
+
+[cloudflare]
+api_token = "token"
+zone_id = "zone"
+update_records = ["home.example.com"]
+
+[network]
+match = "either"
+gateways = ["192.168.1.1", "fd00::1"]
+gateway_macs = ["aa:bb:cc"]
+ipv6_gateways = ["fe80::1%eth0", "2001:db8::/64"]
+forbid_interfaces = ["wg0", ""]
+`,
+			expected: []configProblem{
+				{Key: "main.home_gateway", Line: 3, Message: `"router" is not an IPv4 address`},
+				{Key: "network.match", Line: 11, Message: `"either" is not "all" or "any"`},
+				{Key: "network.gateways", Line: 12, Message: `"fd00::1" is not an IPv4 address`},
+				{Key: "network.gateway_macs", Line: 13, Message: `"aa:bb:cc" is not a MAC address`},
+				{Key: "network.forbid_interfaces", Line: 15, Message: "must not be empty"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := validateConfig(writeTestConfig(t, tt.content), false)
+			if err != nil {
+				t.Fatalf("validateConfig() error = %v", err)
+			}
+			if len(problems) != len(tt.expected) {
+				t.Fatalf("expected %d problems, got %+v", len(tt.expected), problems)
+			}
+			for i, expected := range tt.expected {
+				problem := problems[i]
+				if problem.Key != expected.Key || problem.Line != expected.Line || !strings.Contains(problem.Message, expected.Message) {
+					t.Errorf("problem %d = %+v, expected %+v", i, problem, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateConfigProfiles(t *testing.T) {
+	path := writeTestConfig(t, `
+[cloudflare]
+api_token = "token"
+
+[daemon]
+interval = "-5m"
+
+[profiles.home.cloudflare]
+zone_id = "home-zone"
+update_records = ["home.example.com"]
+
+[profiles.office.cloudflare]
+update_records = ["office.example.com"]
+zone_idd = "office-zone"
+`)
+	if err := os.Mkdir(confDir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	lab := filepath.Join(confDir(path), "lab.toml")
+	if err := os.WriteFile(lab, []byte(`
+[profiles.lab.cloudflare]
+zone_id = "lab-zone"
+update_records = ["lab.example.com"]
+api_token = 5
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := validateConfig(path, false)
+	if err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	expected := []configProblem{
+		{Path: path, Key: "daemon.interval", Line: 6, Message: "must be a positive duration"},
+		{Path: path, Key: "profiles.office.cloudflare.zone_id", Line: 12, Message: "must be set"},
+		{Path: path, Key: "profiles.office.cloudflare.zone_idd", Line: 14, Message: "unknown key"},
+		{Path: lab, Key: "profiles.lab.cloudflare.api_token", Line: 5, Message: "must be a string"},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %+v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if problem != expected[i] {
+			t.Errorf("problem %d = %+v, expected %+v", i, problem, expected[i])
+		}
+	}
+}
+
+func TestValidateConfigSecretCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("secret command tests use a POSIX shell")
+	}
+
+	marker := filepath.Join(t.TempDir(), "ran")
+	path := writeTestConfig(t, `
+[cloudflare]
+api_token_command = "touch `+marker+`; echo token"
+zone_id = "zone"
+update_records = ["home.example.com"]
+`)
+
+	problems, err := validateConfig(path, false)
+	if err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems without running the commands, got %+v", problems)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("a secret command was run")
+	}
+
+	problems, err = validateConfig(path, true)
+	if err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems, got %+v", problems)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected the secret command to run: %v", err)
+	}
+}
+
+func TestConfigWizard(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 9109, "message": "Invalid access token"}]}`))
+			return
+		}
+		switch "/" + strings.TrimLeft(r.URL.Path, "/") {
+		case "/zones":
+			_, _ = w.Write([]byte(`{"success": true, "result": [
+				{"id": "zone1", "name": "example.com"},
+				{"id": "zone2", "name": "example.org"}
+			]}`))
+		case "/zones/zone2/dns_records":
+			_, _ = w.Write([]byte(`{"success": true, "result": [
+				{"id": "1", "name": "home.example.org", "type": "A", "content": "1.1.1.1"},
+				{"id": "2", "name": "example.org", "type": "MX", "content": "mail.example.org"},
+				{"id": "3", "name": "home.example.org", "type": "AAAA", "content": "2001:db8::1"},
+				{"id": "4", "name": "vpn.example.org", "type": "A", "content": "1.1.1.1"}
+			]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	previous := discoverGateway
+	discoverGateway = func() (net.IP, error) {
+		return net.ParseIP("192.168.1.1"), nil
+	}
+	t.Cleanup(func() {
+		discoverGateway = previous
+	})
+
+	var out strings.Builder
+	w := newConfigWizard(strings.NewReader("token\n3\n2\n1,x\n2, 1\n\n"), &out, server.URL)
+	answers, err := w.run()
+	if err != nil {
+		t.Fatalf("run() error = %v\n%s", err, out.String())
+	}
+	if answers.ZoneID != "zone2" || answers.HomeGateway != "192.168.1.1" || strings.Join(answers.Records, ",") != "vpn.example.org,home.example.org" {
+		t.Errorf("unexpected answers %+v", answers)
+	}
+	if !strings.Contains(out.String(), "home.example.org (A 1.1.1.1, AAAA 2001:db8::1)") || strings.Contains(out.String(), "MX") {
+		t.Errorf("unexpected records offered:\n%s", out.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "config", "cloudflare-dyndns.toml")
+	if err := writeConfigFile(path, answers); err != nil {
+		t.Fatalf("writeConfigFile() error = %v", err)
+	}
+	if stat, err := os.Stat(path); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("expected a config file readable by its owner only, got %v, %v", stat, err)
+	}
+	problems, err := validateConfig(path, false)
+	if err != nil || len(problems) > 0 {
+		t.Errorf("expected the written config file to be valid, got %+v, %v", problems, err)
+	}
+
+	w = newConfigWizard(strings.NewReader("wrong\n"), &out, server.URL)
+	if _, err := w.run(); err == nil || !strings.Contains(err.Error(), "Invalid access token") {
+		t.Errorf("expected the Cloudflare error to be returned, got %v", err)
+	}
+
+	w = newConfigWizard(strings.NewReader("token\n"), &out, server.URL)
+	if _, err := w.run(); err == nil {
+		t.Errorf("expected an error when input runs out")
+	}
+}
+
+func TestWriteConfigFile(t *testing.T) {
+	answers := configAnswers{
+		APIToken: "to\x00ken\a\"'",
+		BaseURL:  defaultBaseURL,
+		ZoneID:   "zone\\id",
+		ZoneName: "example.com",
+		Records:  []string{"home.example.com", "émoji-\U0001F600.example.com"},
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := writeConfigFile(path, answers); err != nil {
+		t.Fatalf("writeConfigFile() error = %v", err)
+	}
+
+	v, err := newConfigViper(path, "")
+	if err != nil {
+		t.Fatalf("the written config file cannot be read: %v", err)
+	}
+	if v.GetString("cloudflare.api_token") != answers.APIToken || v.GetString("cloudflare.zone_id") != answers.ZoneID ||
+		strings.Join(v.GetStringSlice("cloudflare.update_records"), ",") != strings.Join(answers.Records, ",") {
+		t.Errorf("the values were not written as given: %v", v.AllSettings())
+	}
+}
+
+func TestValidateConfigFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `cloudflare:
+  api_token: token
+  zone_id: zone
+  update_records:
+    - home.example.com
+daemon:
+  interval: 10m
+  jitter: 3
+notify:
+  webhooks:
+    - url: https://hooks.example.com/dyndns
+      headers:
+        Authorization: Bearer secret
+`,
+		},
+		{
+			name: "json",
+			file: "config.json",
+			content: `{
+  "cloudflare": {
+    "api_token": "token",
+    "zone_id": "zone",
+    "update_records": ["home.example.com"]
+  },
+  "daemon": {"interval": "10m",
+    "jitter": 3
+  },
+  "notify": {
+    "failure_threshold": 5,
+    "webhooks": [{"url": "https://hooks.example.com/dyndns"}]
+  }
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			problems, err := validateConfig(path, false)
+			if err != nil {
+				t.Fatalf("validateConfig() error = %v", err)
+			}
+			if len(problems) != 1 || problems[0].Key != "daemon.jitter" || problems[0].Line != 8 {
+				t.Errorf("expected only daemon.jitter on line 8 to be reported, got %+v", problems)
+			}
+		})
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := configSchema()
+	if schema.Type != "object" || schema.AdditionalProperties != false {
+		t.Fatalf("unexpected root schema %+v", schema)
+	}
+
+	cloudflare := schema.Properties["cloudflare"]
+	if cloudflare == nil || cloudflare.Properties["api_token"].Type != "string" || cloudflare.Properties["api_token_file"] == nil {
+		t.Errorf("unexpected cloudflare schema %+v", cloudflare)
+	}
+	if interval := schema.Properties["daemon"].Properties["interval"]; interval.Pattern == "" || interval.Default != "5m" {
+		t.Errorf("unexpected daemon.interval schema %+v", interval)
+	}
+	webhooks := schema.Properties["notify"].Properties["webhooks"]
+	if webhooks.Type != "array" || webhooks.Items.Properties["url"] == nil || webhooks.Items.Properties["headers"].Type != "object" {
+		t.Errorf("unexpected notify.webhooks schema %+v", webhooks)
+	}
+	profile, ok := schema.Properties["profiles"].AdditionalProperties.(*jsonSchema)
+	if !ok || profile.Properties["cloudflare"].Properties["zone_id"] == nil || profile.Properties["daemon"].Properties["interval"].Default != nil {
+		t.Errorf("unexpected profiles schema %+v", schema.Properties["profiles"])
+	}
+}
@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"bufio"
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/constants"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/TwiN/go-color"
+	"github.com/jackpal/gateway"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const defaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// discoverGateway finds the default gateway offered as the home gateway.
+var discoverGateway = gateway.DiscoverGateway
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a config file by answering a few questions.",
+	Long: `Create a config file by answering a few questions. The zone is picked from the zones your API token
+can access, and the records to update from the zone's A and AAAA records. The current default gateway is
+offered as the home gateway.
+
+The file is written to the path given with --config, or to ~/.cloudflare-dyndns.`,
+	Annotations: map[string]string{skipConfigAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configFile
+		if path == "" {
+			home, err := os.UserHomeDir()
+			FatalError(err)
+			path = filepath.Join(home, ".cloudflare-dyndns")
+		}
+		if config.FormatOf(path) != config.FormatTOML {
+			FatalError(fmt.Sprintf("config init writes TOML, use a path ending in .toml or without an extension instead of %s", path))
+		}
+		if force, _ := cmd.Flags().GetBool("force"); !force {
+			if _, err := os.Stat(path); err == nil {
+				FatalError(fmt.Sprintf("%s already exists, pass --force to overwrite it", path))
+			}
+		}
+
+		w := newConfigWizard(cmd.InOrStdin(), cmd.OutOrStdout(), cmd.Flag("base-url").Value.String())
+		answers, err := w.run()
+		FatalError(err)
+		FatalError(writeConfigFile(path, answers))
+
+		_, _ = fmt.Fprint(cmd.OutOrStdout(), color.With(color.Green, fmt.Sprintf("Wrote %s\n", path)))
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Check it with:\n  cloudflare-dyndns config validate --config %s\n", path)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configInitCmd)
+
+	configInitCmd.Flags().Bool("force", false, "Overwrite the config file if it already exists.")
+	configInitCmd.Flags().String("base-url", defaultBaseURL, "The Cloudflare API to use.")
+	configInitCmd.Flags().BoolP("help", "h", false, "Show help for the config init command.")
+	_ = configInitCmd.Flags().MarkHidden("base-url")
+}
+
+// configAnswers are the values chosen while running config init.
+type configAnswers struct {
+	APIToken    string
+	BaseURL     string
+	ZoneID      string
+	ZoneName    string
+	Records     []string
+	HomeGateway string
+}
+
+// configWizard asks the questions of config init.
+type configWizard struct {
+	input   io.Reader
+	in      *bufio.Reader
+	out     io.Writer
+	baseURL string
+}
+
+func newConfigWizard(in io.Reader, out io.Writer, baseURL string) *configWizard {
+	return &configWizard{input: in, in: bufio.NewReader(in), out: out, baseURL: baseURL}
+}
+
+// run asks every question and returns the answers.
+func (w *configWizard) run() (configAnswers, error) {
+	answers := configAnswers{BaseURL: w.baseURL}
+
+	token, err := w.secret("Cloudflare API token (needs Zone:Read and DNS:Edit): ")
+	if err != nil {
+		return answers, err
+	}
+	if token == "" {
+		return answers, errors.New("an API token is required")
+	}
+	answers.APIToken = token
+
+	client := cloudflare.New(&config.Config{APIToken: token, BaseURL: w.baseURL, UserAgent: constants.DefaultUserAgent})
+	zones, zoneErrors, err := client.ListZones()
+	if err != nil {
+		return answers, cloudflareError("listing the token's zones", err, zoneErrors)
+	}
+	if len(zones) == 0 {
+		return answers, errors.New("the API token cannot access any zones")
+	}
+	var zoneNames []string
+	for _, zone := range zones {
+		zoneNames = append(zoneNames, zone.Name)
+	}
+	zone := zones[0]
+	if len(zones) > 1 {
+		i, err := w.choose("Zones:", zoneNames)
+		if err != nil {
+			return answers, err
+		}
+		zone = zones[i]
+	}
+	_, _ = fmt.Fprintf(w.out, "Using zone %s\n", zone.Name)
+	answers.ZoneID, answers.ZoneName = zone.ID, zone.Name
+
+	client = cloudflare.New(&config.Config{APIToken: token, BaseURL: w.baseURL, ZoneID: zone.ID, UserAgent: constants.DefaultUserAgent})
+	dnsRecords, dnsErrors, err := client.ListDnsRecords()
+	if err != nil {
+		return answers, cloudflareError("listing the zone's records", err, dnsErrors)
+	}
+	var recordNames, recordOptions []string
+	for _, record := range dnsRecords {
+		if record.Type != "A" && record.Type != "AAAA" {
+			continue
+		}
+		if i := slices.Index(recordNames, record.Name); i >= 0 {
+			recordOptions[i] += fmt.Sprintf(", %s %s", record.Type, record.IP)
+			continue
+		}
+		recordNames = append(recordNames, record.Name)
+		recordOptions = append(recordOptions, fmt.Sprintf("%s (%s %s", record.Name, record.Type, record.IP))
+	}
+	if len(recordNames) == 0 {
+		return answers, fmt.Errorf("%s has no A or AAAA records, create the records to update first", zone.Name)
+	}
+	for i := range recordOptions {
+		recordOptions[i] += ")"
+	}
+	chosen, err := w.chooseMany("Records:", recordOptions)
+	if err != nil {
+		return answers, err
+	}
+	for _, i := range chosen {
+		answers.Records = append(answers.Records, recordNames[i])
+	}
+
+	if ip, err := discoverGateway(); err != nil {
+		_, _ = fmt.Fprintln(w.out, "No default gateway found, the records will be updated from any network.")
+	} else {
+		yes, err := w.confirm(fmt.Sprintf("Only update the records while connected through the gateway %s? [Y/n]: ", ip))
+		if err != nil {
+			return answers, err
+		}
+		if yes {
+			answers.HomeGateway = ip.String()
+		}
+	}
+
+	return answers, nil
+}
+
+// line prints the prompt and returns the answer, without surrounding space.
+func (w *configWizard) line(prompt string) (string, error) {
+	_, _ = fmt.Fprint(w.out, prompt)
+	answer, err := w.in.ReadString('\n')
+	if errors.Is(err, io.EOF) && answer == "" {
+		return "", errors.New("no answer given")
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return strings.TrimSpace(answer), nil
+}
+
+// secret is like line, but does not echo the answer when reading from a terminal.
+func (w *configWizard) secret(prompt string) (string, error) {
+	f, ok := w.input.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return w.line(prompt)
+	}
+
+	_, _ = fmt.Fprint(w.out, prompt)
+	answer, err := term.ReadPassword(int(f.Fd()))
+	_, _ = fmt.Fprintln(w.out)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(answer)), nil
+}
+
+// choose lists the options and returns the index of the one picked.
+func (w *configWizard) choose(title string, options []string) (int, error) {
+	w.list(title, options)
+	for {
+		answer, err := w.line(fmt.Sprintf("Choose 1-%d [1]: ", len(options)))
+		if err != nil {
+			return 0, err
+		}
+		if answer == "" {
+			return 0, nil
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		_, _ = fmt.Fprintf(w.out, "Please enter a number between 1 and %d.\n", len(options))
+	}
+}
+
+// chooseMany lists the options and returns the indexes of those picked.
+func (w *configWizard) chooseMany(title string, options []string) ([]int, error) {
+	w.list(title, options)
+	for {
+		answer, err := w.line("Choose one or more, separated by commas: ")
+		if err != nil {
+			return nil, err
+		}
+
+		var chosen []int
+		for _, field := range strings.Split(answer, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 || n > len(options) {
+				chosen = nil
+				break
+			}
+			if !slices.Contains(chosen, n-1) {
+				chosen = append(chosen, n-1)
+			}
+		}
+		if len(chosen) > 0 {
+			return chosen, nil
+		}
+		_, _ = fmt.Fprintf(w.out, "Please enter numbers between 1 and %d, such as 1,2.\n", len(options))
+	}
+}
+
+// confirm asks a yes or no question, where no answer means yes.
+func (w *configWizard) confirm(prompt string) (bool, error) {
+	answer, err := w.line(prompt)
+	if err != nil {
+		return false, err
+	}
+
+	return !strings.HasPrefix(strings.ToLower(answer), "n"), nil
+}
+
+func (w *configWizard) list(title string, options []string) {
+	_, _ = fmt.Fprintln(w.out, title)
+	for i, option := range options {
+		_, _ = fmt.Fprintf(w.out, "  %d) %s\n", i+1, option)
+	}
+}
+
+// cloudflareError combines a failed Cloudflare request with the errors returned
+// by the API.
+func cloudflareError(action string, err error, responseErrors []cloudflare.ResponseErrors) error {
+	var messages []string
+	if err.Error() != "" {
+		messages = append(messages, err.Error())
+	}
+	for _, responseError := range responseErrors {
+		messages = append(messages, fmt.Sprintf("%s (code: %d)", responseError.Message, responseError.Code))
+	}
+
+	return fmt.Errorf("%s failed: %s", action, strings.Join(messages, ", "))
+}
+
+// tomlValue formats a string or list of strings as a TOML value, escaped as TOML
+// requires rather than as Go does.
+func tomlValue(value interface{}) (string, error) {
+	data, err := toml.Marshal(value)
+
+	return string(data), err
+}
+
+var configFileTemplate = template.Must(template.New("config").Funcs(template.FuncMap{
+	"toml": tomlValue,
+}).Parse(`# cloudflare-dyndns config file, written by "cloudflare-dyndns config init".
+# See .cloudflare-dyndns.example for every setting.
+
+[main]
+# home_gateway:
+#   - Only update the records while connected through this gateway, so that they are
+#     not pointed at another network's address.
+#   - If left empty, updates will happen from any gateway.
+home_gateway = {{toml .HomeGateway}}
+
+[cloudflare]
+# api_token:
+#   - Your Cloudflare API token for authenticating with the Cloudflare API.
+api_token = {{toml .APIToken}}
+{{- if ne .BaseURL "` + defaultBaseURL + `"}}
+base_url = {{toml .BaseURL}}
+{{- end}}
+
+# zone_id:
+#   - The DNS zone identifier of {{.ZoneName}}.
+zone_id = {{toml .ZoneID}}
+
+# update_records:
+#   - The A and AAAA records to keep pointed at your public IP address.
+update_records = {{toml .Records}}
+`))
+
+// writeConfigFile writes the answers to a new config file, readable by the
+// current user only as it holds the API token.
+func writeConfigFile(path string, answers configAnswers) error {
+	var content strings.Builder
+	if err := configFileTemplate.Execute(&content, answers); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content.String()), 0600); err != nil {
+		return err
+	}
+
+	// WriteFile keeps the permissions of an existing file.
+	return os.Chmod(path, 0600)
+}
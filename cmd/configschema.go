@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// durationPattern matches the durations accepted by time.ParseDuration.
+const durationPattern = `^[-+]?(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+$|^0$`
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema for the config file.",
+	Long: `Print a JSON Schema describing every key of the config file, for editors and config management tools
+that check YAML, JSON or TOML files against a schema.`,
+	Annotations: map[string]string{skipConfigAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		schema, err := json.MarshalIndent(configSchema(), "", "  ")
+		FatalError(err)
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), string(schema))
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configSchemaCmd)
+
+	configSchemaCmd.Flags().BoolP("help", "h", false, "Show help for the config schema command.")
+}
+
+// jsonSchema is the part of JSON Schema used to describe the config file.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+}
+
+// configSchema returns a JSON Schema for every key of the config file.
+func configSchema() *jsonSchema {
+	defaults := viper.New()
+	setConfigDefaults(defaults)
+
+	root := newObjectSchema()
+	root.Schema = "https://json-schema.org/draft/2020-12/schema"
+	root.Title = "cloudflare-dyndns config file"
+	addConfigProperties(root, defaults)
+
+	// A profile holds any of the keys, and inherits the others.
+	profile := newObjectSchema()
+	addConfigProperties(profile, nil)
+	root.Properties["profiles"] = &jsonSchema{Type: "object", AdditionalProperties: profile}
+
+	return root
+}
+
+// addConfigProperties adds every key of the config file to the schema, with
+// the default values unless defaults is nil.
+func addConfigProperties(root *jsonSchema, defaults *viper.Viper) {
+	for key, kind := range configKeys() {
+		parts := strings.Split(key, ".")
+		parent := root
+		for _, part := range parts[:len(parts)-1] {
+			name, isArray := strings.CutSuffix(part, "[]")
+			child, ok := parent.Properties[name]
+			if !ok {
+				child = newObjectSchema()
+				if isArray {
+					child = &jsonSchema{Type: "array", Items: newObjectSchema()}
+				}
+				parent.Properties[name] = child
+			}
+			parent = child
+			if isArray {
+				parent = child.Items
+			}
+		}
+
+		property := kindSchema(kind)
+		if defaults != nil && !strings.Contains(key, "[]") && defaults.IsSet(key) {
+			property.Default = defaults.Get(key)
+		}
+		parent.Properties[parts[len(parts)-1]] = property
+	}
+}
+
+func newObjectSchema() *jsonSchema {
+	return &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, AdditionalProperties: false}
+}
+
+// kindSchema returns the schema of a value of the given kind.
+func kindSchema(kind string) *jsonSchema {
+	switch kind {
+	case kindDuration:
+		return &jsonSchema{Type: "string", Pattern: durationPattern}
+	case kindBool:
+		return &jsonSchema{Type: "boolean"}
+	case kindInt:
+		return &jsonSchema{Type: "integer"}
+	case kindStrings:
+		return &jsonSchema{Type: "array", Items: &jsonSchema{Type: "string"}}
+	case kindMap:
+		return &jsonSchema{Type: "object", AdditionalProperties: &jsonSchema{Type: "string"}}
+	default:
+		return &jsonSchema{Type: "string"}
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/config"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective value of every setting.",
+	Long: `Show the effective value of every setting, after the config file and environment variables are applied
+to the defaults. Secrets such as the API token are not shown.
+
+Every key can be set with an environment variable named after it, such as CLOUDFLARE_DYNDNS_DAEMON_INTERVAL
+for daemon.interval. The API token can also be set with CLOUDFLARE_API_TOKEN.
+
+With --profile, the settings of that profile are shown, merged over the shared settings. With --sources,
+each value is shown with the environment variable or file it was last set in, and the profile, when its
+settings set it.`,
+	Annotations: map[string]string{skipConfigAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configFile
+		if path == "" {
+			found, err := findConfigFile()
+			if err != nil && !errors.Is(err, errConfigNotFound) {
+				FatalError(err)
+			}
+			path = found
+		}
+
+		v, err := newConfigViper(path, strings.ToLower(profileName))
+		FatalError(err)
+
+		var sources map[string]string
+		if showSources, _ := cmd.Flags().GetBool("sources"); showSources {
+			sources, err = settingSources(path, strings.ToLower(profileName))
+			FatalError(err)
+		}
+		printSettings(cmd.OutOrStdout(), v, sources)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+
+	configShowCmd.Flags().Bool("sources", false, "Also show where each value came from.")
+	configShowCmd.Flags().BoolP("help", "h", false, "Show help for the config show command.")
+}
+
+// printSettings prints every setting as a table, with its source when sources
+// is not nil.
+func printSettings(out io.Writer, v *viper.Viper, sources map[string]string) {
+	// The profiles are not shown, as the chosen one is merged into the settings.
+	keys := slices.DeleteFunc(v.AllKeys(), func(key string) bool {
+		return strings.HasPrefix(key, "profiles.")
+	})
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	if sources != nil {
+		_, _ = fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	} else {
+		_, _ = fmt.Fprintln(w, "KEY\tVALUE")
+	}
+	for _, key := range keys {
+		value := formatSetting(key, v.Get(key))
+		for _, suffix := range []string{"_file", "_command"} {
+			if isSecretKey(key) && v.GetString(key+suffix) != "" {
+				value = "(read from " + key + suffix + ")"
+			}
+		}
+		if sources != nil {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", key, value, settingSource(sources, key))
+		} else {
+			_, _ = fmt.Fprintf(w, "%s\t%s\n", key, value)
+		}
+	}
+	_ = w.Flush()
+}
+
+// formatSetting formats a value as it would be written in the config file.
+func formatSetting(key string, value interface{}) string {
+	if isSecretKey(key) && value != "" {
+		return "(hidden)"
+	}
+
+	switch value := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", value)
+	case []string:
+		quoted := make([]string, len(value))
+		for i, item := range value {
+			quoted[i] = fmt.Sprintf("%q", item)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	case []interface{}:
+		// Arrays of tables, such as notify.webhooks.
+		tables := 0
+		for _, item := range value {
+			if _, ok := item.(map[string]interface{}); ok {
+				tables++
+			}
+		}
+		if tables > 0 && tables == len(value) {
+			return fmt.Sprintf("(%d blocks)", tables)
+		}
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = formatSetting("", item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// settingSource returns where the value of a key came from, given the sources
+// of the keys set in the config files.
+func settingSource(sources map[string]string, key string) string {
+	for _, name := range configEnvNames(key) {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			return "env " + name
+		}
+	}
+	if source, ok := sources[key]; ok {
+		return source
+	}
+
+	return "default"
+}
+
+// settingSources returns the file each key was last set in, among the config
+// file at path and the files in its conf.d directory, in the order they are
+// merged. The keys set by the profile, unless it is empty, are given the file
+// and profile they were set in.
+func settingSources(path, profile string) (map[string]string, error) {
+	sources := map[string]string{}
+	if path == "" {
+		return sources, nil
+	}
+	files, err := configFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	inProfile := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := config.Decode(data, config.FormatOf(file))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		forEachSetting(raw, "", func(key string) {
+			sources[key] = file
+			if key, ok := strings.CutPrefix(key, "profiles."+profile+"."); ok && profile != "" {
+				inProfile[key] = fmt.Sprintf("%s (profile %s)", file, profile)
+			}
+		})
+	}
+	maps.Copy(sources, inProfile)
+
+	return sources, nil
+}
+
+// forEachSetting calls fn with the key of every value in a decoded config file,
+// lower-cased and joined with dots as viper does. Arrays of tables are values.
+func forEachSetting(table map[string]interface{}, path string, fn func(key string)) {
+	for name, value := range table {
+		key := strings.ToLower(name)
+		if path != "" {
+			key = path + "." + key
+		}
+		if child, ok := value.(map[string]interface{}); ok {
+			forEachSetting(child, key, fn)
+			continue
+		}
+		fn(key)
+	}
+}
@@ -0,0 +1,383 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/lock"
+	"cloudflare-dyndns/metrics"
+	"cloudflare-dyndns/mqtt"
+	"cloudflare-dyndns/netwatch"
+	"cloudflare-dyndns/notify"
+	"cloudflare-dyndns/systemd"
+	"cloudflare-dyndns/updater"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TwiN/go-color"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keep your IP address in Cloudflare up to date until stopped.",
+	Long: `Keep your IP address in Cloudflare up to date until stopped. This runs the same checks as the update
+command on an interval, instead of from crontab.
+
+The interval and the random jitter added to it are set in the [daemon] section of the config file. Failed
+updates are retried sooner, backing off up to the interval.
+
+On Linux, an update is also started shortly after the machine's network addresses or default route change.
+Set watch_network to false in the [daemon] section to only rely on the interval.
+
+Set listen in the [metrics] section, or use --metrics-listen, to serve Prometheus metrics on /metrics along
+with /healthz and /readyz probes.
+
+The config file is reloaded when it changes, unless watch_config is false in the [daemon] section. An
+invalid file is reported and the running configuration is kept.
+
+With --all-profiles, the records of every profile in the config file are kept up to date, each on the
+interval of its own profile. The [daemon] and [metrics] settings other than the interval are read from
+the first profile. Profiles added or removed while running are started or stopped after a restart.
+
+Send SIGINT or SIGTERM to stop, and SIGHUP to reload the config file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		interval, err := cmd.Flags().GetDuration("interval")
+		FatalError(err)
+		if interval < 0 {
+			FatalError("the interval must be a positive duration")
+		} else if interval > 0 {
+			cfg.Interval = interval
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Keep the records of the chosen profile, or of every profile, up to date.
+		profiles := []string{profileName}
+		if allProfilesRequested(cmd) {
+			profiles, err = listProfiles()
+			FatalError(err)
+		}
+		var daemons []*profileDaemon
+		defer func() {
+			for _, d := range daemons {
+				d.close()
+			}
+		}()
+		for _, name := range profiles {
+			daemonCfg := cfg
+			if name != profileName {
+				daemonCfg, err = loadProfile(name)
+				FatalError(err)
+				if interval > 0 {
+					daemonCfg.Interval = interval
+				}
+			}
+			d, err := startProfileDaemon(ctx, cmd, name, daemonCfg)
+			FatalError(err)
+			daemons = append(daemons, d)
+		}
+		setDaemonSecrets(daemons)
+
+		// Reload the config file, keeping the current configuration of any profile
+		// that has become invalid.
+		var reloadMu sync.Mutex
+		reload := func(reason string) {
+			reloadMu.Lock()
+			defer reloadMu.Unlock()
+
+			notifySystemd("RELOADING=1")
+			for _, d := range daemons {
+				reloaded, err := reloadConfig(d.name, interval)
+				if err == nil {
+					err = d.reload(reloaded)
+				}
+				if err != nil {
+					logger.Error().Msg(fmt.Sprintf("%s, keeping the current configuration%s, reload failed: %v", reason, d.label(" of profile "), err))
+					fmt.Print(redactor.Redact(fmt.Sprintf("Error: %s, keeping the current configuration%s, reload failed: %v\n", reason, d.label(" of profile "), err)))
+					continue
+				}
+				logger.Info().Msg(fmt.Sprintf("%s, configuration%s reloaded", reason, d.label(" of profile ")))
+				fmt.Printf("Configuration%s reloaded (%s).\n", d.label(" of profile "), reason)
+			}
+			setDaemonSecrets(daemons)
+			if allProfilesRequested(cmd) {
+				if current, err := listProfiles(); err == nil && !slices.Equal(current, profiles) {
+					logger.Warn().Msg("the profiles have changed, restart the daemon to start or stop them")
+					fmt.Print(color.With(color.Yellow, "Warning: The profiles have changed, restart the daemon to start or stop them.\n"))
+				}
+			}
+			notifySystemd("READY=1")
+		}
+
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		defer signal.Stop(hangups)
+		go func() {
+			for range hangups {
+				reload("received SIGHUP")
+			}
+		}()
+
+		if cfg.WatchConfig && configFile != "" {
+			go func() {
+				err := config.Watch(ctx, configFile, time.Second, func() {
+					reload("config file changed")
+				})
+				if err != nil {
+					logger.Warn().Msg(fmt.Sprintf("unable to watch the config file for changes: %v", err))
+				}
+			}()
+			if stat, err := os.Stat(confDir(configFile)); err == nil && stat.IsDir() {
+				go func() {
+					err := config.WatchDir(ctx, confDir(configFile), time.Second, func() {
+						reload("conf.d changed")
+					})
+					if err != nil {
+						logger.Warn().Msg(fmt.Sprintf("unable to watch %s for changes: %v", confDir(configFile), err))
+					}
+				}()
+			}
+		}
+
+		// Expose metrics and health probes, if configured. With several profiles,
+		// every sample has a profile label, and the probes cover all of them.
+		if listen := cmd.Flag("metrics-listen").Value.String(); listen != "" {
+			cfg.MetricsListen = listen
+		}
+		if cfg.MetricsListen != "" {
+			var daemonMetrics metrics.Collector
+			if allProfilesRequested(cmd) {
+				set := metrics.NewSet()
+				for _, d := range daemons {
+					d.daemon.Observer = set.Profile(d.name)
+				}
+				daemonMetrics = set
+			} else {
+				single := metrics.New()
+				daemons[0].daemon.Observer = single
+				daemonMetrics = single
+			}
+			go func() {
+				logger.Info().Msg(fmt.Sprintf("serving metrics on %s", cfg.MetricsListen))
+				if err := metrics.Serve(ctx, cfg.MetricsListen, metrics.Handler(daemonMetrics, cfg.MetricsUnhealthyAfter)); err != nil {
+					logger.Error().Msg(fmt.Sprintf("unable to serve metrics: %v", err))
+					fmt.Printf("Error: unable to serve metrics: %v\n", err)
+				}
+			}()
+		}
+
+		// Start an update as soon as the network changes, where supported. The
+		// interval keeps running as a safety net.
+		if cfg.WatchNetwork {
+			go func() {
+				err := netwatch.Watch(ctx, cfg.Debounce, func() {
+					logger.Info().Msg("network change detected")
+					for _, d := range daemons {
+						d.daemon.Trigger()
+					}
+				})
+				if errors.Is(err, errors.ErrUnsupported) {
+					logger.Info().Msg("watching for network changes is not supported on this platform")
+				} else if err != nil {
+					logger.Warn().Msg(fmt.Sprintf("unable to watch for network changes: %v", err))
+				}
+			}()
+		}
+
+		// Ping the systemd watchdog, if enabled, at half the required interval.
+		if watchdog, ok := systemd.WatchdogInterval(); ok {
+			go func() {
+				ticker := time.NewTicker(watchdog / 2)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						notifySystemd("WATCHDOG=1")
+					}
+				}
+			}()
+		}
+
+		if len(daemons) == 1 {
+			logger.Info().Msg(fmt.Sprintf("starting the update loop every %s", cfg.Interval))
+			fmt.Printf("Updating every %s. Press Ctrl+C to stop.\n", cfg.Interval)
+		} else {
+			logger.Info().Msg(fmt.Sprintf("starting the update loops of profiles %s", strings.Join(profiles, ", ")))
+			fmt.Printf("Updating profiles %s. Press Ctrl+C to stop.\n", strings.Join(profiles, ", "))
+		}
+		notifySystemd("READY=1\nSTATUS=Starting the first update")
+		errs := make([]error, len(daemons))
+		var wg sync.WaitGroup
+		for i, d := range daemons {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = d.daemon.Run(ctx)
+			}()
+		}
+		wg.Wait()
+		notifySystemd("STOPPING=1")
+		FatalError(errors.Join(errs...))
+	},
+}
+
+// profileDaemon keeps the records of a profile up to date within the daemon, or
+// those of the whole config file when it has no profiles.
+type profileDaemon struct {
+	name      string
+	logger    zerolog.Logger
+	daemon    *updater.Daemon
+	lock      *lock.Lock
+	notifier  atomic.Pointer[notify.Dispatcher]
+	publisher atomic.Pointer[mqtt.Publisher]
+	secrets   []string
+	// allProfiles is set when the daemon runs every profile of the config file.
+	allProfiles bool
+}
+
+// startProfileDaemon takes the lock of the profile and sets up its notifiers and
+// MQTT connection. The update loop is started by running its daemon.
+func startProfileDaemon(ctx context.Context, cmd *cobra.Command, name string, daemonCfg config.Config) (*profileDaemon, error) {
+	profileLogger := logger
+	if name != "" {
+		profileLogger = logger.With().Str("profile", name).Logger()
+	}
+	d := &profileDaemon{name: name, logger: profileLogger, secrets: daemonCfg.Secrets, allProfiles: allProfilesRequested(cmd)}
+
+	// Only one daemon, or update from cron, may run for a config file at a time.
+	var err error
+	if d.lock, err = acquireLock(ctx, cmd, &daemonCfg); err != nil {
+		return nil, err
+	}
+
+	notifier, err := notify.New(&daemonCfg, profileLogger)
+	if err != nil {
+		d.close()
+		return nil, err
+	}
+	d.notifier.Store(notifier)
+
+	// Keep a connection to the MQTT broker, if configured.
+	if daemonCfg.MQTT.Broker != "" {
+		publisher, err := mqtt.New(d.mqttConfig(daemonCfg.MQTT), Version, profileLogger)
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+		if err := publisher.Connect(); err != nil {
+			profileLogger.Warn().Msg(fmt.Sprintf("unable to connect to MQTT, retrying after the next update: %v", err))
+		}
+		d.publisher.Store(publisher)
+	}
+
+	d.daemon = updater.NewDaemon(&daemonCfg, profileLogger)
+	d.daemon.OnResult = func(result *updater.Result, err error) {
+		fmt.Printf("%s%s\n", time.Now().Format(time.RFC3339), d.label(" profile "))
+		printResult(result)
+		if notifyErr := d.notifier.Load().Dispatch(ctx, result, err); notifyErr != nil {
+			fmt.Print(redactor.Redact(fmt.Sprintf("Unable to send notifications: %v\n", notifyErr)))
+		}
+		if p := d.publisher.Load(); p != nil {
+			if mqttErr := p.Publish(result, err); mqttErr != nil {
+				profileLogger.Error().Msg(fmt.Sprintf("unable to publish to MQTT: %v", mqttErr))
+				fmt.Print(redactor.Redact(fmt.Sprintf("Unable to publish to MQTT: %v\n", mqttErr)))
+			}
+		}
+		if err != nil {
+			fmt.Print(redactor.Redact(fmt.Sprintf("Error: %v\n", err)))
+			notifySystemd(redactor.Redact(fmt.Sprintf("STATUS=%sUpdate failed: %v", d.label("Profile ", ": "), err)))
+		} else {
+			notifySystemd(fmt.Sprintf("STATUS=%sPublished %s at %s", d.label("Profile ", ": "), result.IP, time.Now().Format(time.RFC3339)))
+		}
+	}
+
+	return d, nil
+}
+
+// reload replaces the configuration of the profile, unless its notifiers or MQTT
+// connection cannot be set up.
+func (d *profileDaemon) reload(reloaded config.Config) error {
+	notifier, err := notify.New(&reloaded, d.logger)
+	if err != nil {
+		return err
+	}
+	var publisher *mqtt.Publisher
+	if reloaded.MQTT.Broker != "" {
+		if publisher, err = mqtt.New(d.mqttConfig(reloaded.MQTT), Version, d.logger); err != nil {
+			return err
+		}
+	}
+
+	d.secrets = reloaded.Secrets
+	d.notifier.Store(notifier)
+	if previous := d.publisher.Swap(publisher); previous != nil {
+		previous.Close()
+	}
+	d.daemon.Reload(&reloaded)
+
+	return nil
+}
+
+// mqttConfig returns the MQTT settings of the profile, with node and client IDs
+// of its own when the daemon runs several profiles.
+func (d *profileDaemon) mqttConfig(broker config.MQTT) config.MQTT {
+	if d.allProfiles {
+		return mqtt.WithProfile(broker, d.name)
+	}
+
+	return broker
+}
+
+// label returns the name of the profile between prefix and suffix, or nothing
+// when the daemon runs without profiles.
+func (d *profileDaemon) label(prefix string, suffix ...string) string {
+	if d.name == "" {
+		return ""
+	}
+
+	return prefix + d.name + strings.Join(suffix, "")
+}
+
+func (d *profileDaemon) close() {
+	if p := d.publisher.Load(); p != nil {
+		p.Close()
+	}
+	_ = d.lock.Release()
+}
+
+// setDaemonSecrets hides the secrets of every profile from logs and errors.
+func setDaemonSecrets(daemons []*profileDaemon) {
+	var secrets []string
+	for _, d := range daemons {
+		secrets = append(secrets, d.secrets...)
+	}
+	redactor.SetSecrets(secrets)
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().Duration("interval", 0, "How often to check the IP address. If not specified, the interval will be read from the config file.")
+	daemonCmd.Flags().String("metrics-listen", "", "Serve metrics and health probes on this address, such as :9101. If not specified, the address will be read from the config file.")
+	addLockFlags(daemonCmd)
+	addProfileFlags(daemonCmd)
+	daemonCmd.Flags().BoolP("help", "h", false, "Show help for the daemon command.")
+}
+
+// notifySystemd sends a state change to systemd when running as a notify service.
+func notifySystemd(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		logger.Warn().Msg(fmt.Sprintf("unable to notify systemd: %v", err))
+	}
+}
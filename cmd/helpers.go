@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/updater"
 	"fmt"
 	"os"
+	"text/tabwriter"
 
 	"github.com/TwiN/go-color"
 )
@@ -10,9 +13,35 @@ import (
 // FatalError checks if the provided message is not nil, prints it as an error, and terminates the application if true.
 func FatalError(message interface{}) {
 	if message != nil {
-		logger.Error().Msg(fmt.Sprintf("%v", message))
-		errorMessage := color.With(color.Red, fmt.Sprintf("Error: %v\n", message))
+		text := redactor.Redact(fmt.Sprintf("%v", message))
+		logger.Error().Msg(text)
+		errorMessage := color.With(color.Red, fmt.Sprintf("Error: %s\n", text))
 		_, _ = fmt.Fprintf(os.Stderr, "%s", errorMessage)
 		os.Exit(1)
 	}
 }
+
+// printDnsErrors logs and prints a failed Cloudflare request along with any errors returned by the API.
+// The messages are printed with the other messages, so that they never mix with --output.
+func printDnsErrors(prefix string, err error, dnsErrors []cloudflare.ResponseErrors) {
+	lines := []string{fmt.Sprintf("%s: %s", prefix, err)}
+	for _, dnsError := range dnsErrors {
+		lines = append(lines, fmt.Sprintf("DNS record error: %s (code: %d)", dnsError.Message, dnsError.Code))
+	}
+
+	for _, line := range lines {
+		message := redactor.Redact(line)
+		logger.Error().Msg(message)
+		_, _ = fmt.Fprintln(messages(), message)
+	}
+}
+
+// printChanges prints the planned record changes as a table.
+func printChanges(changes []updater.Change) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tOLD\tNEW")
+	for _, change := range changes {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", change.Before.Name, change.Before.IP, change.After.IP)
+	}
+	_ = w.Flush()
+}
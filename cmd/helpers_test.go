@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"bytes"
+	"cloudflare-dyndns/cloudflare"
+	"errors"
 	"fmt"
 	"github.com/TwiN/go-color"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -74,6 +77,35 @@ func TestFatalError(t *testing.T) {
 	}
 }
 
+func TestPrintDnsErrors(t *testing.T) {
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStdout, previousStderr, previousFormat := os.Stdout, os.Stderr, outputFormat
+	os.Stdout, os.Stderr, outputFormat = stdout, stderr, outputJSON
+	redactor.SetSecrets([]string{"token"})
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr, outputFormat = previousStdout, previousStderr, previousFormat
+		redactor.SetSecrets(nil)
+	})
+
+	printDnsErrors("Failed to get DNS records", errors.New("bad token"), []cloudflare.ResponseErrors{{Code: 10000, Message: "Authentication error"}})
+
+	if data, _ := os.ReadFile(stdout.Name()); len(data) != 0 {
+		t.Errorf("expected nothing on stdout with --output json, got %q", data)
+	}
+	expected := "Failed to get DNS records: bad [REDACTED]\nDNS record error: Authentication error (code: 10000)\n"
+	if data, _ := os.ReadFile(stderr.Name()); string(data) != expected {
+		t.Errorf("expected %q on stderr, got %q", expected, data)
+	}
+}
+
 // TestHelperProcess is a helper to simulate process exit during FatalError
 // It is executed as a sub-process when fatal error termination is expected.
 func TestHelperProcess(t *testing.T) {
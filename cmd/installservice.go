@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/systemd"
+	"fmt"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service",
+	Short: "Generate systemd units that keep your IP address up to date.",
+	Long: `Generate hardened systemd units that keep your IP address up to date using the current config file.
+
+In daemon mode a single service runs the daemon command. In timer mode a oneshot service runs the update
+command and a timer starts it on an interval. The services run as a dynamic user, and read the config file
+as a systemd credential so that it can stay readable by root only.
+
+The services run with the profile chosen with --profile, or for every profile with --all-profiles. Only the
+config file itself is passed to the services: files in its conf.d directory are not, so merge them into the
+config file first.
+
+The units are only written; enable them with systemctl afterwards.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		mode := cmd.Flag("mode").Value.String()
+		dir := cmd.Flag("dir").Value.String()
+		name := cmd.Flag("name").Value.String()
+
+		binary := cmd.Flag("binary").Value.String()
+		if binary == "" {
+			executable, err := os.Executable()
+			FatalError(err)
+			binary, err = filepath.EvalSymlinks(executable)
+			FatalError(err)
+		}
+
+		if configFile == "" {
+			FatalError("the services read their settings from a config file, pass one with --config")
+		}
+		configPath, err := filepath.Abs(configFile)
+		FatalError(err)
+
+		if files, err := configFiles(configPath); err == nil && len(files) > 1 {
+			_, _ = fmt.Fprint(messages(), color.With(color.Yellow, fmt.Sprintf(
+				"Warning: The services only read %s, not the %d file(s) in %s. Merge them into the config file.\n",
+				configPath, len(files)-1, confDir(configPath))))
+		}
+
+		interval := cfg.Interval
+		if cmd.Flags().Changed("interval") {
+			interval, err = cmd.Flags().GetDuration("interval")
+			FatalError(err)
+		}
+
+		units, err := systemd.Units(systemd.UnitOptions{
+			Name:        name,
+			Mode:        mode,
+			Binary:      binary,
+			ConfigFile:  configPath,
+			Interval:    interval,
+			Profile:     profileName,
+			AllProfiles: allProfilesRequested(cmd),
+		})
+		FatalError(err)
+
+		paths, err := systemd.Install(dir, units)
+		FatalError(err)
+
+		for _, path := range paths {
+			logger.Info().Msg(fmt.Sprintf("wrote systemd unit %s", path))
+			fmt.Printf("Wrote %s\n", path)
+		}
+
+		enable := name + ".service"
+		if mode == systemd.ModeTimer {
+			enable = name + ".timer"
+		}
+		fmt.Printf("Enable it with:\n  systemctl daemon-reload\n  systemctl enable --now %s\n", enable)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(installServiceCmd)
+
+	installServiceCmd.Flags().String("mode", systemd.ModeDaemon, "Either \"daemon\" for a long-running service, or \"timer\" for a timer that starts the update command.")
+	installServiceCmd.Flags().String("dir", "/etc/systemd/system", "The directory to write the units to.")
+	installServiceCmd.Flags().String("name", "cloudflare-dyndns", "The name of the units, without a suffix.")
+	installServiceCmd.Flags().String("binary", "", "The path of the cloudflare-dyndns binary. If not specified, the running binary will be used.")
+	installServiceCmd.Flags().Duration("interval", 0, "How often the timer starts an update. If not specified, the interval will be read from the config file.")
+	addProfileFlags(installServiceCmd)
+	installServiceCmd.Flags().BoolP("help", "h", false, "Show help for the install-service command.")
+}
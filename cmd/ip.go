@@ -2,22 +2,93 @@ package cmd
 
 import (
 	"cloudflare-dyndns/ipify"
+	"cloudflare-dyndns/updater"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
 )
 
 // ipCmd represents the ip command
 var ipCmd = &cobra.Command{
 	Use:   "ip",
 	Short: "Print your public IP address.",
+	Long: `Print your public IP address.
+
+With --output json, yaml or csv, the IPv4 and IPv6 addresses are each looked up, along with the provider
+and URL used. A family that cannot be looked up has an error instead, and the exit status is 1 when
+neither can.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		ip, err := ipify.New(&cfg).GetPublicIP()
-		FatalError(err)
+		client := ipify.New(&cfg)
+		if !structuredOutput() {
+			ip, err := client.GetPublicIP()
+			FatalError(err)
+
+			fmt.Printf("%s\n", ip)
+			return
+		}
+
+		results := []ipResult{
+			{Family: "ipv4", network: "tcp4"},
+			{Family: "ipv6", network: "tcp6"},
+		}
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(result *ipResult) {
+				defer wg.Done()
+				result.lookup(client)
+			}(&results[i])
+		}
+		wg.Wait()
 
-		fmt.Printf("%s\n", ip)
+		var rows [][]string
+		var errs []error
+		for _, result := range results {
+			rows = append(rows, []string{result.Family, result.IP, result.Provider, result.URL, result.Error})
+			if result.Error != "" {
+				errs = append(errs, fmt.Errorf("%s: %s", result.Family, result.Error))
+			}
+		}
+		FatalError(writeOutput(os.Stdout, results, []string{"family", "ip", "provider", "url", "error"}, rows))
+		if len(errs) == len(results) {
+			FatalError(errors.Join(errs...))
+		}
 	},
 }
 
+// ipResult is the public address of one family, as printed with --output.
+type ipResult struct {
+	Family   string `json:"family"`
+	IP       string `json:"ip"`
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	Error    string `json:"error,omitempty"`
+
+	network string
+}
+
+// lookup asks the configured service for the address of the result's family.
+func (r *ipResult) lookup(client *ipify.Client) {
+	r.Provider = updater.ProviderIpify
+	r.URL = cfg.IpifyURL
+
+	ip, err := client.GetPublicIPOver(r.network)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil || addr.Unmap().Is4() != (r.Family == "ipv4") {
+		r.Error = fmt.Sprintf("%q is not an %s address", ip, strings.Replace(r.Family, "ip", "IP", 1))
+		return
+	}
+	r.IP = addr.Unmap().String()
+}
+
 func init() {
 	rootCmd.AddCommand(ipCmd)
 
@@ -4,8 +4,9 @@ import (
 	"cloudflare-dyndns/cloudflare"
 	"fmt"
 	"github.com/spf13/cobra"
-	"log"
 	"os"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 )
 
@@ -13,32 +14,46 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "Display a list of DNS records for your CloudFlare zone.",
 	Long: `Display a list of DNS records for your CloudFlare zone. This command only displays A and AAAA records
-so that you can easily find the record you want to update.`,
+so that you can easily find the record you want to update.
+
+With --output json, yaml or csv, every field of the records is printed, including their IDs, proxied
+status, TTL and tags.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		cloudflareClient := cloudflare.New(&cfg)
 		dnsRecords, dnsErrors, err := cloudflareClient.ListDnsRecords()
 		if err != nil {
-			log.Printf("ERROR: Failed to get DNS records: %s", err)
-			_, _ = fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-			for _, dnsError := range dnsErrors {
-				log.Printf("ERROR: DNS record error - %s (code: %d)", dnsError.Message, dnsError.Code)
-				_, _ = fmt.Fprintf(os.Stderr, "%s (code: %d)\n", dnsError.Message, dnsError.Code)
-			}
+			printDnsErrors("Failed to get DNS records", err, dnsErrors)
 			os.Exit(1)
 		}
 
+		addressRecords := cloudflare.DnsRecords{}
+		for _, dnsRecord := range dnsRecords {
+			if dnsRecord.Type == "A" || dnsRecord.Type == "AAAA" {
+				addressRecords = append(addressRecords, dnsRecord)
+			}
+		}
+
+		if structuredOutput() {
+			var rows [][]string
+			for _, dnsRecord := range addressRecords {
+				rows = append(rows, []string{dnsRecord.ID, dnsRecord.Name, dnsRecord.Type, dnsRecord.IP,
+					strconv.FormatBool(dnsRecord.Proxied), strconv.Itoa(dnsRecord.TTL), dnsRecord.Comment, strings.Join(dnsRecord.Tags, ";")})
+			}
+			FatalError(writeOutput(os.Stdout, addressRecords,
+				[]string{"id", "name", "type", "content", "proxied", "ttl", "comment", "tags"}, rows))
+			return
+		}
+
 		// Setup the tabwriter for aligned columns.
 		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
 		_, _ = fmt.Fprintln(w, "NAME\tIP\tCOMMENT")
 
-		for _, dnsRecord := range dnsRecords {
-			if dnsRecord.Type == "A" || dnsRecord.Type == "AAAA" {
-				comment := dnsRecord.Comment
-				if comment == "" {
-					comment = "-"
-				}
-				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", dnsRecord.Name, dnsRecord.IP, comment)
+		for _, dnsRecord := range addressRecords {
+			comment := dnsRecord.Comment
+			if comment == "" {
+				comment = "-"
 			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", dnsRecord.Name, dnsRecord.IP, comment)
 		}
 
 		_ = w.Flush()
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/lock"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+// addLockFlags adds the flags that choose whether a command waits for another
+// run of the same config file to finish.
+func addLockFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("wait", false, "Wait for another run using the same config file to finish. If not specified, main.lock_wait will be read from the config file.")
+	cmd.Flags().Bool("no-wait", false, "Exit straight away if another run using the same config file is in progress.")
+	cmd.MarkFlagsMutuallyExclusive("wait", "no-wait")
+}
+
+// acquireLock takes the lock for the config file, or for one of its profiles, so
+// that overlapping runs don't update the same records. A *lock.LockedError is
+// returned when another run holds the lock and the command should not wait for it.
+func acquireLock(ctx context.Context, cmd *cobra.Command, runCfg *config.Config) (*lock.Lock, error) {
+	if runCfg.LockFilePath == "" {
+		logger.Warn().Msg("no lock file path is available, overlapping runs will not be prevented")
+		return nil, nil
+	}
+
+	wait := runCfg.LockWait
+	if cmd.Flags().Changed("wait") {
+		wait, _ = cmd.Flags().GetBool("wait")
+	}
+	if noWait, _ := cmd.Flags().GetBool("no-wait"); noWait {
+		wait = false
+	}
+
+	var l *lock.Lock
+	var err error
+	if !wait {
+		l, err = lock.TryAcquire(runCfg.LockFilePath)
+		var lockedErr *lock.LockedError
+		if errors.As(err, &lockedErr) {
+			warnStaleLock(lockedErr, runCfg.StaleLockAfter)
+		}
+	} else {
+		l, err = lock.Acquire(ctx, runCfg.LockFilePath, func(lockedErr *lock.LockedError) {
+			logger.Info().Msg(fmt.Sprintf("waiting for the lock: %v", lockedErr))
+			_, _ = fmt.Fprintf(messages(), "Waiting for another run to finish (%v).\n", lockedErr)
+			warnStaleLock(lockedErr, runCfg.StaleLockAfter)
+		})
+	}
+	if errors.Is(err, errors.ErrUnsupported) {
+		logger.Warn().Msg("locking files is not supported on this platform, overlapping runs will not be prevented")
+		return nil, nil
+	}
+
+	return l, err
+}
+
+// warnStaleLock warns when the lock has been held for longer than any run should
+// take, which usually means the process holding it is hung.
+func warnStaleLock(lockedErr *lock.LockedError, staleAfter time.Duration) {
+	if staleAfter <= 0 || !lockedErr.Stale(staleAfter) {
+		return
+	}
+
+	message := fmt.Sprintf("The lock has been held by process %d for %s, it may be hung.",
+		lockedErr.Holder.PID, time.Since(lockedErr.Holder.Since).Round(time.Second))
+	logger.Warn().Msg(message)
+	_, _ = fmt.Fprint(messages(), color.With(color.Yellow, fmt.Sprintf("Warning: %s\n", message)))
+}
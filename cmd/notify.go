@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/notify"
+	"context"
+	"fmt"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/cobra"
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Work with the notifiers in the config file.",
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a sample notification to every configured notifier.",
+	Long: `Send a sample notification to every notifier in the [notify] section of the config file that is
+sent the chosen event, to check that they are set up correctly.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		kind, err := notify.ParseKind(cmd.Flag("event").Value.String())
+		FatalError(err)
+
+		notifier, err := notify.New(&cfg, logger)
+		FatalError(err)
+		if notifier.Empty() {
+			FatalError("no notifiers are configured in the [notify] section")
+		}
+
+		event := notify.Sample(kind, cfg.RecordNames())
+		fmt.Printf("Sending: %s\n", event.Message())
+		FatalError(notifier.Send(context.Background(), event))
+		fmt.Print(color.With(color.Green, "Sample notification sent.\n"))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyTestCmd)
+
+	notifyCmd.Flags().BoolP("help", "h", false, "Show help for the notify command.")
+	notifyTestCmd.Flags().String("event", string(notify.EventIPChanged), "The event to send, one of ip_changed, update_failed, gateway_mismatch or recovered.")
+	notifyTestCmd.Flags().BoolP("help", "h", false, "Show help for the notify test command.")
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The formats that --output accepts.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputCSV   = "csv"
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputYAML, outputCSV}
+
+// outputFormat is the format chosen with --output.
+var outputFormat = outputTable
+
+// checkOutputFormat returns an error unless --output names a known format.
+func checkOutputFormat() error {
+	if !slices.Contains(outputFormats, outputFormat) {
+		return fmt.Errorf("--output must be one of table, json, yaml or csv, not %q", outputFormat)
+	}
+
+	return nil
+}
+
+// structuredOutput reports whether --output asks for JSON, YAML or CSV, which
+// scripts read from stdout.
+func structuredOutput() bool {
+	return outputFormat != outputTable
+}
+
+// messages returns where messages meant for people are printed: stdout with
+// the table format, and stderr when stdout is kept for JSON, YAML or CSV.
+func messages() io.Writer {
+	if structuredOutput() {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+// writeOutput prints value as JSON or YAML, or the rows under the header as CSV,
+// depending on --output.
+func writeOutput(w io.Writer, value interface{}, header []string, rows [][]string) error {
+	switch outputFormat {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case outputYAML:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		return writeYAML(w, data)
+	case outputCSV:
+		writer := csv.NewWriter(w)
+		return writer.WriteAll(append([][]string{header}, rows...))
+	}
+
+	return fmt.Errorf("cannot print %s output", outputFormat)
+}
+
+// writeYAML prints a JSON document as block style YAML, so that the keys are
+// the same in both formats and keep their order.
+func writeYAML(w io.Writer, data []byte) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+
+	var clearStyle func(node *yaml.Node)
+	clearStyle = func(node *yaml.Node) {
+		node.Style = 0
+		for _, child := range node.Content {
+			clearStyle(child)
+		}
+	}
+	clearStyle(&document)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
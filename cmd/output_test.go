@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bytes"
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/updater"
+	"errors"
+	"testing"
+)
+
+func TestWriteOutput(t *testing.T) {
+	result := &updater.Result{
+		IP: "2.2.2.2",
+		Records: []updater.RecordResult{
+			{Name: "home.example.com", Type: "A", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Action: updater.ActionUpdated, Reason: updater.ReasonConfigured},
+			{Name: "vpn.example.com", Type: "A", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Action: updater.ActionFailed, Reason: updater.ReasonFollow, Error: errors.New("rejected")},
+		},
+		Missing: []string{"true"},
+	}
+	output := newUpdateOutput("home", result, errors.New("1 record failed"))
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: outputJSON,
+			expected: `{
+  "profile": "home",
+  "ip": "2.2.2.2",
+  "skipped": false,
+  "records": [
+    {
+      "name": "home.example.com",
+      "type": "A",
+      "old": "1.1.1.1",
+      "new": "2.2.2.2",
+      "action": "updated",
+      "reason": "configured"
+    },
+    {
+      "name": "vpn.example.com",
+      "type": "A",
+      "old": "1.1.1.1",
+      "new": "2.2.2.2",
+      "action": "failed",
+      "reason": "follow",
+      "error": "rejected"
+    },
+    {
+      "name": "true",
+      "action": "missing",
+      "error": "no DNS record with this name was found"
+    }
+  ],
+  "error": "1 record failed"
+}
+`,
+		},
+		{
+			format: outputYAML,
+			expected: `profile: home
+ip: 2.2.2.2
+skipped: false
+records:
+  - name: home.example.com
+    type: A
+    old: 1.1.1.1
+    new: 2.2.2.2
+    action: updated
+    reason: configured
+  - name: vpn.example.com
+    type: A
+    old: 1.1.1.1
+    new: 2.2.2.2
+    action: failed
+    reason: follow
+    error: rejected
+  - name: "true"
+    action: missing
+    error: no DNS record with this name was found
+error: 1 record failed
+`,
+		},
+		{
+			format: outputCSV,
+			expected: `profile,name,type,old,new,action,reason,error
+home,home.example.com,A,1.1.1.1,2.2.2.2,updated,configured,
+home,vpn.example.com,A,1.1.1.1,2.2.2.2,failed,follow,rejected
+home,true,,,,missing,,no DNS record with this name was found
+`,
+		},
+	}
+
+	t.Cleanup(func() {
+		outputFormat = outputTable
+	})
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			outputFormat = tt.format
+			var buf bytes.Buffer
+			if err := writeOutput(&buf, output, updateOutputHeader, output.rows()); err != nil {
+				t.Fatalf("writeOutput() error = %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("writeOutput() =\n%s\nwant\n%s", buf.String(), tt.expected)
+			}
+		})
+	}
+
+	// A dry run prints a row for every field planned to change.
+	plan := &updater.Plan{Changes: []updater.Change{{
+		Reason: updater.ReasonConfigured,
+		Before: cloudflare.DnsRecord{Name: "home.example.com", Type: "A", IP: "1.1.1.1", TTL: 1},
+		After:  cloudflare.DnsRecord{Name: "home.example.com", Type: "A", IP: "2.2.2.2", TTL: 300},
+	}}}
+	output = newUpdateOutput("home", &updater.Result{IP: "2.2.2.2", Plan: plan}, nil)
+	outputFormat = outputCSV
+	var buf bytes.Buffer
+	if err := writeOutput(&buf, output, planOutputHeader, output.rows()); err != nil {
+		t.Fatalf("writeOutput() error = %v", err)
+	}
+	expected := `profile,name,type,field,old,new,reason
+home,home.example.com,A,content,1.1.1.1,2.2.2.2,configured
+home,home.example.com,A,ttl,1,300,configured
+`
+	if buf.String() != expected {
+		t.Errorf("writeOutput() =\n%s\nwant\n%s", buf.String(), expected)
+	}
+
+	outputFormat = "xml"
+	if err := checkOutputFormat(); err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/config"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/TwiN/go-color"
+)
+
+// strictPermissions is set with --strict-permissions.
+var strictPermissions bool
+
+// permissionWarnings holds the files already warned about, so that a file
+// shared by several profiles, or read again on reload, is only warned about once.
+var permissionWarnings = struct {
+	sync.Mutex
+	files map[string]bool
+}{files: map[string]bool{}}
+
+// checkPermissions warns about the config files and the secret files of loaded
+// that other users can access. With --strict-permissions the problems are
+// returned as an error instead, so that the command refuses to run.
+func checkPermissions(loaded config.Config) error {
+	var files []string
+	if configFile != "" {
+		var err error
+		if files, err = configFiles(configFile); err != nil {
+			return err
+		}
+	}
+	files = append(files, loaded.SecretFiles...)
+
+	var errs []error
+	for _, file := range files {
+		// A file that cannot be read has already failed to load.
+		var permErr *config.PermissionError
+		if !errors.As(config.CheckPermissions(file), &permErr) {
+			continue
+		}
+		if strictPermissions {
+			errs = append(errs, fmt.Errorf("%w, fix it with: %s", permErr, permErr.Fix()))
+			continue
+		}
+		permissionWarnings.Lock()
+		warned := permissionWarnings.files[file]
+		permissionWarnings.files[file] = true
+		permissionWarnings.Unlock()
+		if !warned {
+			_, _ = fmt.Fprint(messages(), color.With(color.Yellow, fmt.Sprintf("Warning: %v. Fix it with: %s\n", permErr, permErr.Fix())))
+		}
+	}
+
+	return errors.Join(errs...)
+}
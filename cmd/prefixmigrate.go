@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/updater"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/cobra"
+)
+
+var prefixMigrateCmd = &cobra.Command{
+	Use:   "prefix-migrate",
+	Short: "Move every AAAA record under an old IPv6 prefix onto a new prefix.",
+	Long: `Move every AAAA record in your CloudFlare zone whose address falls within an old IPv6 prefix onto
+a new prefix, keeping the host bits of each address.
+
+The old prefix defaults to the prefix of the IPv6 address update last published, as kept in the state
+file, or when there is none, of the first AAAA record listed in update_records or [[records]]. The new
+prefix defaults to the prefix of your current public IPv6 address. The prefix length is read from
+ipv6.prefix_length in the config file and must be between /48 and /64.
+
+A plan of every change is printed before anything is written. Use --dry-run to only print the plan.
+The records are written as during an update that migrates the prefix: the hooks run around them, and
+the state file remembers the moved address, so that the next update moves on from the new prefix.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := updater.MigrateOptions{
+			From: cmd.Flag("from").Value.String(),
+			To:   cmd.Flag("to").Value.String(),
+		}
+		if cmd.Flags().Changed("prefix-length") {
+			var err error
+			opts.PrefixLength, err = cmd.Flags().GetInt("prefix-length")
+			FatalError(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		FatalError(err)
+
+		runLock, err := acquireLock(context.Background(), cmd, &cfg)
+		FatalError(err)
+		defer runLock.Release()
+
+		u := updater.New(&cfg, logger)
+		migration, err := u.PlanMigration(opts)
+		if errors.Is(err, updater.ErrNoOldPrefix) {
+			err = fmt.Errorf("%w, use --from to specify the old prefix", err)
+		}
+		FatalError(err)
+
+		changes := migration.Plan.Changes
+		if len(changes) == 0 {
+			message := fmt.Sprintf("No AAAA records found under %s.", migration.From)
+			logger.Info().Msg(message)
+			fmt.Println(message)
+			return
+		}
+
+		fmt.Printf("Moving %d record(s) from %s to %s:\n", len(changes), migration.From, migration.To)
+		printChanges(changes)
+
+		if dryRun {
+			return
+		}
+
+		result, err := u.Apply(context.Background(), migration.Plan)
+		printResult(result)
+		FatalError(err)
+		fmt.Print(color.With(color.Green, fmt.Sprintf("Updated %d record(s).\n", len(changes))))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(prefixMigrateCmd)
+
+	prefixMigrateCmd.Flags().String("from", "", "The old IPv6 prefix or an address within it.")
+	prefixMigrateCmd.Flags().String("to", "", "The new IPv6 prefix or an address within it. If not specified, the current public IP address will be used.")
+	prefixMigrateCmd.Flags().Int("prefix-length", 0, "The length of the prefix to migrate. If not specified, the length will be read from the config file.")
+	prefixMigrateCmd.Flags().Bool("dry-run", false, "Print the planned changes without applying them.")
+	addLockFlags(prefixMigrateCmd)
+	prefixMigrateCmd.Flags().BoolP("help", "h", false, "Show help for the prefix-migrate command.")
+}
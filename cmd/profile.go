@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// profileName is the profile chosen with --profile.
+var profileName string
+
+// confDir returns the directory of files merged onto the config file at path:
+// conf.d next to a config.toml, config.yaml or config.json, and path with .d
+// appended for any other file, such as ~/.cloudflare-dyndns.d.
+func confDir(path string) string {
+	if strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) == "config" {
+		return filepath.Join(filepath.Dir(path), "conf.d")
+	}
+
+	return path + ".d"
+}
+
+// configFiles returns the config file at path followed by the TOML, YAML and
+// JSON files in its conf.d directory, in the order they are merged.
+func configFiles(path string) ([]string, error) {
+	entries, err := os.ReadDir(confDir(path))
+	if os.IsNotExist(err) {
+		return []string{path}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".toml", ".yaml", ".yml", ".json":
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	files := []string{path}
+	for _, name := range names {
+		files = append(files, filepath.Join(confDir(path), name))
+	}
+
+	return files, nil
+}
+
+// readConfigFiles merges the config file at path and the files in its conf.d
+// directory into v, with later files overriding earlier ones.
+func readConfigFiles(v *viper.Viper, path string) error {
+	files, err := configFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		settings, err := config.Decode(data, config.FormatOf(file))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := v.MergeConfigMap(settings); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// configProfiles returns the names of the profiles defined in the config files
+// read by v, sorted.
+func configProfiles(v *viper.Viper) []string {
+	var names []string
+	for name := range v.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// applyProfile merges the settings of a profile over the shared settings read
+// by v. Environment variables still take precedence over both.
+func applyProfile(v *viper.Viper, name string) error {
+	profiles := configProfiles(v)
+	if !slices.Contains(profiles, name) {
+		if len(profiles) == 0 {
+			return fmt.Errorf("profile %q is not defined, the config file has no [profiles] tables", name)
+		}
+		return fmt.Errorf("profile %q is not defined, choose one of %s", name, strings.Join(profiles, ", "))
+	}
+
+	table, ok := v.Get("profiles." + name).(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("profiles.%s must be a table", name)
+	}
+
+	return v.MergeConfigMap(table)
+}
+
+// loadProfile reads the configuration of a profile, or of the whole config file
+// when name is empty.
+func loadProfile(name string) (config.Config, error) {
+	v, err := newConfigViper(configFile, name)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	loaded, err := loadConfig(v, name, true)
+	if err != nil {
+		return loaded, err
+	}
+
+	return loaded, checkPermissions(loaded)
+}
+
+// listProfiles returns the profiles defined in the config file, for
+// --all-profiles.
+func listProfiles() ([]string, error) {
+	v, err := newConfigViper(configFile, "")
+	if err != nil {
+		return nil, err
+	}
+	profiles := configProfiles(v)
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("--all-profiles needs [profiles] tables in the config file")
+	}
+
+	return profiles, nil
+}
+
+// allProfilesRequested reports whether --all-profiles was given to the command.
+func allProfilesRequested(cmd *cobra.Command) bool {
+	all, _ := cmd.Flags().GetBool("all-profiles")
+	return all
+}
+
+// addProfileFlags adds --all-profiles to a command that can run for every profile.
+func addProfileFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("all-profiles", false, "Run for every profile defined in the config file, instead of the one chosen with --profile.")
+}
@@ -2,6 +2,11 @@ package cmd
 
 import (
 	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/constants"
+	"cloudflare-dyndns/netmatch"
+	"cloudflare-dyndns/state"
+	"cloudflare-dyndns/updater"
+	"errors"
 	"fmt"
 	"github.com/TwiN/go-color"
 	"github.com/rs/zerolog"
@@ -9,8 +14,11 @@ import (
 	"github.com/spf13/viper"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 )
 
 var cfg config.Config
@@ -18,6 +26,39 @@ var configFile string
 var logger zerolog.Logger
 var Version = "0.0.0-dev"
 
+var errMissingRequired = errors.New("api_token, zone_id and update_records or [[records]] are required")
+var errConfigNotFound = errors.New("config file not found")
+
+// envPrefix starts the environment variables that override config keys, as in
+// CLOUDFLARE_DYNDNS_CLOUDFLARE_API_TOKEN for cloudflare.api_token.
+const envPrefix = "CLOUDFLARE_DYNDNS"
+
+// envAliases are the conventional environment variables that are also read for
+// a key, when the variable with envPrefix is not set.
+var envAliases = map[string][]string{
+	"cloudflare.api_token": {"CLOUDFLARE_API_TOKEN"},
+}
+
+// secretKeys are the keys holding secrets, which are never shown or logged. Each
+// can instead be read from a file with <key>_file, or from the output of a
+// command with <key>_command. A key ending in * stands for every value of a
+// table, such as the webhook headers.
+var secretKeys = []string{
+	"cloudflare.api_token",
+	"mqtt.password",
+	"notify.webhooks[].url",
+	"notify.webhooks[].headers.*",
+	"notify.slack[].webhook_url",
+	"notify.discord[].webhook_url",
+	"notify.ntfy[].token",
+	"notify.gotify[].token",
+	"notify.telegram[].bot_token",
+	"notify.email[].password",
+}
+
+// redactor hides the secrets of the loaded configuration from logs and errors.
+var redactor config.Redactor
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "cloudflare-dyndns",
@@ -54,7 +95,10 @@ func init() {
 	rootCmd.Version = Version
 	rootCmd.SetVersionTemplate("cloudflare-dyndns version {{.Version}}\n")
 
-	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file (default searches for ./.cloudflare-dyndns, ~/.cloudflare-dyndns, or ~/.config/cloudflare-dyndns/.cloudflare-dyndns)")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file in TOML, YAML or JSON (default searches for ./.cloudflare-dyndns, ~/.cloudflare-dyndns, then config.toml, config.yaml or config.json in $XDG_CONFIG_HOME/cloudflare-dyndns and /etc/cloudflare-dyndns)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "the profile of the config file to use, from its [profiles.<name>] table")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "the output format of the list, ip and update commands: table, json, yaml or csv")
+	rootCmd.PersistentFlags().BoolVar(&strictPermissions, "strict-permissions", false, "refuse to run when the config file or a secret file can be accessed by other users")
 
 	// Create a dedicated "version" subcommand if desired.
 	versionCmd := &cobra.Command{
@@ -67,88 +111,98 @@ func init() {
 	rootCmd.AddCommand(versionCmd)
 }
 
+// skipConfigAnnotation marks commands that find and read the config file
+// themselves, such as those that create or check it.
+const skipConfigAnnotation = "skip-config"
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
-	if configFile != "" {
-		// Use a config file from the flag.
-		viper.SetConfigFile(configFile)
-		viper.SetConfigType("toml")
-	} else {
-		// Find home directory.
-		home, err := os.UserHomeDir()
-		FatalError(err)
-
-		// Candidate paths in the specified order.
-		candidatePaths := []string{
-			"./.cloudflare-dyndns",
-			filepath.Join(home, ".cloudflare-dyndns"),
-			filepath.Join(home, ".config", "cloudflare-dyndns", ".cloudflare-dyndns"),
-		}
-
-		var foundConfigPath string
-		for _, path := range candidatePaths {
-			if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
-				foundConfigPath = path
-				break
-			}
-		}
+	if err := checkOutputFormat(); err != nil {
+		fmt.Printf("%s\n", color.With(color.Red, fmt.Sprintf("ERROR: %v", err)))
+		os.Exit(1)
+	}
 
-		if foundConfigPath == "" {
-			fmt.Printf("%s\n", color.With(color.Red, fmt.Sprintf("ERROR: Config file not found in paths: %v", candidatePaths)))
+	cmd, _, findErr := rootCmd.Find(os.Args[1:])
+	if findErr == nil && cmd.Annotations[skipConfigAnnotation] != "" {
+		return
+	}
+
+	if configFile == "" {
+		foundConfigPath, err := findConfigFile()
+		if err != nil && !errors.Is(err, errConfigNotFound) {
+			fmt.Printf("%s\n", color.With(color.Red, fmt.Sprintf("ERROR: %v", err)))
 			os.Exit(1)
 		}
-
-		viper.SetConfigFile(foundConfigPath)
-		viper.SetConfigType("toml")
 		configFile = foundConfigPath
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
-
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		// Check if "version" or "--version" was passed.
-		if len(os.Args) > 1 {
-			arg := strings.ToLower(os.Args[1])
-			if !strings.Contains(arg, "version") {
-				msg := color.With(color.Gray, fmt.Sprintf("Using config file: %s\n", viper.ConfigFileUsed()))
-				fmt.Printf("%s", msg)
-			}
+	// With --all-profiles, the first profile is loaded here and the command
+	// loads the others itself.
+	profileName = strings.ToLower(profileName)
+	profile := profileName
+	var profiles []string
+	v, err := newConfigViper(configFile, "")
+	if err == nil {
+		profiles = configProfiles(v)
+	}
+	if err == nil && findErr == nil && allProfilesRequested(cmd) {
+		if profileName != "" {
+			err = errors.New("--profile and --all-profiles cannot be used together")
+		} else if len(profiles) == 0 {
+			err = errors.New("--all-profiles needs [profiles] tables in the config file")
+		} else {
+			profile = profiles[0]
 		}
-	} else {
+	}
+	if err == nil && profile != "" {
+		err = applyProfile(v, profile)
+	}
+	if err != nil {
 		msg := color.With(color.Red, fmt.Sprintf("ERROR: Config file cannot be loaded: %v\n", err))
 		fmt.Printf("%s", msg)
 		os.Exit(1)
 	}
 
-	// Set the configuration defaults.
-	viper.SetDefault("main.user_agent", "cloudflare-dyndns/1.0.0")
-	viper.SetDefault("main.log_file_path", "")
-	viper.SetDefault("main.home_gateway", "")
-	viper.SetDefault("cloudflare.api_token", "")
-	viper.SetDefault("cloudflare.base_url", "https://api.cloudflare.com/client/v4")
-	viper.SetDefault("cloudflare.zone_id", "")
-	viper.SetDefault("cloudflare.update_records", []string{})
-	viper.SetDefault("ipify.url", "https://api64.ipify.org")
-
-	// Populate the config struct.
-	cfg = config.Config{
-		APIToken:      viper.GetString("cloudflare.api_token"),
-		BaseURL:       viper.GetString("cloudflare.base_url"),
-		ZoneID:        viper.GetString("cloudflare.zone_id"),
-		UpdateRecords: viper.GetStringSlice("cloudflare.update_records"),
-		UserAgent:     viper.GetString("main.user_agent"),
-		LogFilePath:   viper.GetString("main.log_file_path"),
-		HomeGateway:   viper.GetString("main.home_gateway"),
-		IpifyURL:      viper.GetString("ipify.url"),
+	// Check if "version" or "--version" was passed.
+	if len(os.Args) > 1 {
+		arg := strings.ToLower(os.Args[1])
+		if !strings.Contains(arg, "version") {
+			msg := color.With(color.Gray, fmt.Sprintf("Using config file: %s\n", configFile))
+			if configFile == "" {
+				msg = color.With(color.Gray, "Using settings from environment variables only\n")
+			} else if profile != "" && findErr == nil && !allProfilesRequested(cmd) {
+				msg = color.With(color.Gray, fmt.Sprintf("Using config file: %s (profile %s)\n", configFile, profile))
+			}
+			_, _ = fmt.Fprint(messages(), msg)
+		}
 	}
 
-	// Required config values.
-	if cfg.APIToken == "" || cfg.ZoneID == "" || len(cfg.UpdateRecords) == 0 {
-		msg := color.With(color.Red, "Please provide a valid config file at ~/.cloudflare-dyndns or use the --config flag to specify a config file.\n")
+	loadedCfg, err := loadConfig(v, profile, true)
+	if errors.Is(err, errMissingRequired) {
+		msg := color.With(color.Red, "Please provide a valid config file at ~/.cloudflare-dyndns or use the --config flag to specify a config file.\n"+
+			"Run \"cloudflare-dyndns config init\" to create one, or \"cloudflare-dyndns config validate\" to check it.\n")
+		if profile == "" && len(profiles) > 0 {
+			msg = color.With(color.Red, fmt.Sprintf("The config file defines the profiles %s. Choose one with --profile, or use --all-profiles\n"+
+				"with the update and daemon commands.\n", strings.Join(profiles, ", ")))
+		} else if configFile == "" {
+			msg = color.With(color.Red, "No config file was found, and api_token, zone_id and update_records are not all set by environment variables.\n"+
+				"Run \"cloudflare-dyndns config init\" to create a config file, or set CLOUDFLARE_API_TOKEN, CLOUDFLARE_DYNDNS_CLOUDFLARE_ZONE_ID\n"+
+				"and CLOUDFLARE_DYNDNS_CLOUDFLARE_UPDATE_RECORDS.\n")
+		}
+		fmt.Printf("%s", msg)
+		os.Exit(1)
+	} else if err != nil {
+		msg := color.With(color.Red, fmt.Sprintf("ERROR: Config file cannot be loaded: %v\n", err))
+		fmt.Printf("%s", msg)
+		os.Exit(1)
+	}
+	if err := checkPermissions(loadedCfg); err != nil {
+		msg := color.With(color.Red, fmt.Sprintf("ERROR: Refusing to run with --strict-permissions: %v\n", err))
 		fmt.Printf("%s", msg)
 		os.Exit(1)
 	}
+	cfg = loadedCfg
+	redactor.SetSecrets(cfg.Secrets)
 
 	// Set up the logger.
 	if cfg.LogFilePath != "" {
@@ -162,7 +216,7 @@ func initConfig() {
 
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 		logger = zerolog.New(zerolog.ConsoleWriter{
-			Out:        logFile,
+			Out:        redactor.Writer(logFile),
 			TimeFormat: time.RFC3339,
 			NoColor:    true,
 			FormatLevel: func(i interface{}) string {
@@ -175,5 +229,436 @@ func initConfig() {
 			//	return color.With(color.Green, i.(string))
 			//},
 		}).With().Timestamp().Str("configFile", configFile).Logger()
+		if profile != "" && !allProfilesRequested(cmd) {
+			logger = logger.With().Str("profile", profile).Logger()
+		}
+	}
+}
+
+// configSearchPaths returns the paths searched for a config file, in order.
+// Under each config directory, config.toml, config.yaml, config.yml and
+// config.json are looked for in the cloudflare-dyndns directory.
+func configSearchPaths() ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{
+		"./.cloudflare-dyndns",
+		filepath.Join(home, ".cloudflare-dyndns"),
+		filepath.Join(home, ".config", "cloudflare-dyndns", ".cloudflare-dyndns"),
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	configDirs := []string{configHome}
+	if dirs := os.Getenv("XDG_CONFIG_DIRS"); dirs != "" {
+		configDirs = append(configDirs, filepath.SplitList(dirs)...)
+	} else {
+		configDirs = append(configDirs, "/etc/xdg")
+	}
+	for _, dir := range configDirs {
+		paths = append(paths, configFileNames(filepath.Join(dir, "cloudflare-dyndns"))...)
+	}
+
+	return append(paths, configFileNames("/etc/cloudflare-dyndns")...), nil
+}
+
+// configFileNames returns the config files looked for in a directory.
+func configFileNames(dir string) []string {
+	var paths []string
+	for _, ext := range []string{".toml", ".yaml", ".yml", ".json"} {
+		paths = append(paths, filepath.Join(dir, "config"+ext))
+	}
+
+	return paths
+}
+
+// findConfigFile returns the first config file found in the search paths.
+func findConfigFile() (string, error) {
+	candidatePaths, err := configSearchPaths()
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range candidatePaths {
+		if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w in paths: %v", errConfigNotFound, candidatePaths)
+}
+
+// setConfigDefaults sets the default value of every configuration key.
+func setConfigDefaults(v *viper.Viper) {
+	v.SetDefault("main.user_agent", constants.DefaultUserAgent)
+	v.SetDefault("main.log_file_path", "")
+	v.SetDefault("main.home_gateway", "")
+	v.SetDefault("main.state_file_path", "")
+	v.SetDefault("main.reconcile_interval", "1h")
+	v.SetDefault("main.lock_file_path", "")
+	v.SetDefault("main.lock_wait", false)
+	v.SetDefault("main.stale_lock_after", "15m")
+	v.SetDefault("main.secret_command_timeout", "10s")
+	v.SetDefault("network.match", "all")
+	v.SetDefault("network.gateways", []string{})
+	v.SetDefault("network.gateway_macs", []string{})
+	v.SetDefault("network.ipv6_gateways", []string{})
+	v.SetDefault("network.require_interfaces", []string{})
+	v.SetDefault("network.forbid_interfaces", []string{})
+	v.SetDefault("cloudflare.api_token", "")
+	v.SetDefault("cloudflare.api_token_file", "")
+	v.SetDefault("cloudflare.api_token_command", "")
+	v.SetDefault("cloudflare.base_url", "https://api.cloudflare.com/client/v4")
+	v.SetDefault("cloudflare.zone_id", "")
+	v.SetDefault("cloudflare.update_records", []string{})
+	v.SetDefault("cloudflare.follow_ip", false)
+	v.SetDefault("cloudflare.follow_tags", []string{})
+	v.SetDefault("cloudflare.follow_comment", "")
+	v.SetDefault("ipify.url", "https://api64.ipify.org")
+	v.SetDefault("ipv6.prefix_length", 64)
+	v.SetDefault("ipv6.migrate_prefix", false)
+	v.SetDefault("daemon.interval", "5m")
+	v.SetDefault("daemon.jitter", "30s")
+	v.SetDefault("daemon.watch_network", true)
+	v.SetDefault("daemon.debounce", "2s")
+	v.SetDefault("daemon.watch_config", true)
+	v.SetDefault("metrics.listen", "")
+	v.SetDefault("metrics.unhealthy_after", "30m")
+	v.SetDefault("metrics.textfile", "")
+	v.SetDefault("hooks.pre_update", "")
+	v.SetDefault("hooks.post_update", "")
+	v.SetDefault("hooks.timeout", "30s")
+	v.SetDefault("hooks.veto", false)
+	v.SetDefault("mqtt.broker", "")
+	v.SetDefault("mqtt.username", "")
+	v.SetDefault("mqtt.password", "")
+	v.SetDefault("mqtt.password_file", "")
+	v.SetDefault("mqtt.password_command", "")
+	v.SetDefault("mqtt.client_id", "")
+	v.SetDefault("mqtt.ca_file", "")
+	v.SetDefault("mqtt.cert_file", "")
+	v.SetDefault("mqtt.key_file", "")
+	v.SetDefault("mqtt.qos", 1)
+	v.SetDefault("mqtt.retain", true)
+	v.SetDefault("mqtt.timeout", "10s")
+	v.SetDefault("mqtt.topic_prefix", "cloudflare-dyndns")
+	v.SetDefault("mqtt.discovery", true)
+	v.SetDefault("mqtt.discovery_prefix", "homeassistant")
+	v.SetDefault("mqtt.node_id", "")
+	v.SetDefault("mqtt.ipv4_topic", "")
+	v.SetDefault("mqtt.ipv6_topic", "")
+	v.SetDefault("mqtt.last_update_topic", "")
+	v.SetDefault("mqtt.status_topic", "")
+	v.SetDefault("notify.failure_threshold", 3)
+	v.SetDefault("notify.timeout", "10s")
+	v.SetDefault("notify.retries", 3)
+}
+
+// unresolvedSecret stands in for a secret printed by a command that was not run.
+const unresolvedSecret = "(printed by a command that was not run)"
+
+// loadConfig populates a config struct from the values read by viper and checks
+// that it is usable. Secret commands are only run when runCommands is true, and
+// their secrets are otherwise left as unresolvedSecret, so that the rest of the
+// configuration can be checked without running them.
+func loadConfig(v *viper.Viper, profile string, runCommands bool) (config.Config, error) {
+	loaded := config.Config{
+		APIToken:      v.GetString("cloudflare.api_token"),
+		BaseURL:       v.GetString("cloudflare.base_url"),
+		ZoneID:        v.GetString("cloudflare.zone_id"),
+		UpdateRecords: stringSlice(v, "cloudflare.update_records"),
+		UserAgent:     v.GetString("main.user_agent"),
+		LogFilePath:   v.GetString("main.log_file_path"),
+		HomeGateway:   v.GetString("main.home_gateway"),
+		Network: config.Network{
+			Match:             v.GetString("network.match"),
+			Gateways:          stringSlice(v, "network.gateways"),
+			GatewayMACs:       stringSlice(v, "network.gateway_macs"),
+			IPv6Gateways:      stringSlice(v, "network.ipv6_gateways"),
+			RequireInterfaces: stringSlice(v, "network.require_interfaces"),
+			ForbidInterfaces:  stringSlice(v, "network.forbid_interfaces"),
+		},
+		IpifyURL:              v.GetString("ipify.url"),
+		PrefixLength:          v.GetInt("ipv6.prefix_length"),
+		MigratePrefix:         v.GetBool("ipv6.migrate_prefix"),
+		StateFilePath:         v.GetString("main.state_file_path"),
+		LockFilePath:          v.GetString("main.lock_file_path"),
+		LockWait:              v.GetBool("main.lock_wait"),
+		StaleLockAfter:        v.GetDuration("main.stale_lock_after"),
+		FollowIP:              v.GetBool("cloudflare.follow_ip"),
+		FollowTags:            stringSlice(v, "cloudflare.follow_tags"),
+		FollowComment:         v.GetString("cloudflare.follow_comment"),
+		Interval:              v.GetDuration("daemon.interval"),
+		Jitter:                v.GetDuration("daemon.jitter"),
+		ReconcileInterval:     v.GetDuration("main.reconcile_interval"),
+		WatchNetwork:          v.GetBool("daemon.watch_network"),
+		Debounce:              v.GetDuration("daemon.debounce"),
+		WatchConfig:           v.GetBool("daemon.watch_config"),
+		MetricsListen:         v.GetString("metrics.listen"),
+		MetricsUnhealthyAfter: v.GetDuration("metrics.unhealthy_after"),
+		MetricsTextfile:       v.GetString("metrics.textfile"),
+
+		PreUpdateHook:  v.GetString("hooks.pre_update"),
+		PostUpdateHook: v.GetString("hooks.post_update"),
+		HookTimeout:    v.GetDuration("hooks.timeout"),
+		HookVeto:       v.GetBool("hooks.veto"),
+
+		NotifyFailureThreshold: v.GetInt("notify.failure_threshold"),
+		NotifyTimeout:          v.GetDuration("notify.timeout"),
+		NotifyRetries:          v.GetInt("notify.retries"),
+	}
+	// Read the secrets held in files or printed by commands.
+	all := v.AllSettings()
+	secrets, secretFiles, err := resolveSecrets(all, v.GetDuration("main.secret_command_timeout"), runCommands)
+	if err != nil {
+		return loaded, err
 	}
+	loaded.Secrets = secrets
+	loaded.SecretFiles = secretFiles
+	if cloudflareSettings, ok := all["cloudflare"].(map[string]interface{}); ok {
+		loaded.APIToken, _ = cloudflareSettings["api_token"].(string)
+	}
+
+	// Decode the blocks from the merged settings, as viper only looks up defaults
+	// and environment variables for the keys within a table one at a time.
+	settings := viper.New()
+	if err := settings.MergeConfigMap(all); err != nil {
+		return loaded, err
+	}
+	for key, target := range configBlocks(&loaded) {
+		if err := settings.UnmarshalKey(key, target); err != nil {
+			return loaded, &config.KeyError{Key: key, Err: err}
+		}
+	}
+	_, networkErr := netmatch.Parse(&loaded)
+	if err := errors.Join(updater.CheckRecords(loaded.Records), networkErr); err != nil {
+		return loaded, err
+	}
+
+	// Keep the state for each config file separately unless told otherwise, or
+	// for each zone without a config file.
+	if loaded.StateFilePath == "" {
+		statePath, err := state.ProfilePath(configFile, profile)
+		if configFile == "" {
+			statePath, err = state.ZonePath(loaded.ZoneID)
+		}
+		if err == nil {
+			loaded.StateFilePath = statePath
+		}
+	}
+	if loaded.LockFilePath == "" && loaded.StateFilePath != "" {
+		loaded.LockFilePath = strings.TrimSuffix(loaded.StateFilePath, filepath.Ext(loaded.StateFilePath)) + ".lock"
+	}
+
+	// Required config values.
+	if loaded.APIToken == "" || loaded.ZoneID == "" || len(loaded.RecordNames()) == 0 {
+		return loaded, errMissingRequired
+	}
+	if loaded.Interval <= 0 {
+		return loaded, errors.New("daemon.interval must be a positive duration such as \"5m\"")
+	}
+
+	return loaded, nil
+}
+
+// configBlocks returns the tables and arrays of tables in the config file, keyed
+// by their path, along with the field of c each one is decoded into.
+func configBlocks(c *config.Config) map[string]interface{} {
+	return map[string]interface{}{
+		"records":         &c.Records,
+		"hooks.records":   &c.RecordHooks,
+		"mqtt":            &c.MQTT,
+		"notify.webhooks": &c.Webhooks,
+		"notify.slack":    &c.Slack,
+		"notify.discord":  &c.Discord,
+		"notify.ntfy":     &c.Ntfy,
+		"notify.gotify":   &c.Gotify,
+		"notify.telegram": &c.Telegram,
+		"notify.email":    &c.Email,
+	}
+}
+
+// resolveSecrets reads every secret set with <key>_file or <key>_command into its
+// key in settings, and returns the values of all secrets along with the files
+// they were read from. Commands are only run when runCommands is true.
+func resolveSecrets(settings map[string]interface{}, timeout time.Duration, runCommands bool) ([]string, []string, error) {
+	var secrets, files []string
+	var errs []error
+	for _, key := range secretKeys {
+		i := strings.LastIndex(key, ".")
+		forEachTable(settings, strings.Split(key[:i], "."), "", func(table map[string]interface{}, path string) {
+			names := []string{key[i+1:]}
+			wildcard := names[0] == "*"
+			if wildcard {
+				names = secretNames(table)
+			}
+			for _, name := range names {
+				key := path + "." + name
+				value, _ := table[name].(string)
+				file, _ := table[name+"_file"].(string)
+				command, _ := table[name+"_command"].(string)
+				if wildcard {
+					// Every key of the table is a value, so these are not kept.
+					delete(table, name+"_file")
+					delete(table, name+"_command")
+				}
+
+				var err error
+				switch {
+				case (value != "" && file != "") || (value != "" && command != "") || (file != "" && command != ""):
+					errs = append(errs, &config.KeyError{Key: key, Err: fmt.Errorf("only one of %s, %s_file and %s_command can be set", name, name, name)})
+					continue
+				case file != "":
+					if value, err = config.ReadSecretFile(file); err != nil {
+						errs = append(errs, &config.KeyError{Key: key + "_file", Err: err})
+						continue
+					}
+					files = append(files, config.SecretFilePath(file))
+				case command != "" && !runCommands:
+					table[name] = unresolvedSecret
+					continue
+				case command != "":
+					if value, err = config.RunSecretCommand(command, timeout); err != nil {
+						errs = append(errs, &config.KeyError{Key: key + "_command", Err: err})
+						continue
+					}
+				}
+
+				table[name] = value
+				if value != "" {
+					secrets = append(secrets, value)
+				}
+			}
+		})
+	}
+
+	return secrets, files, errors.Join(errs...)
+}
+
+// isSecretKey reports whether a key, such as notify.webhooks[0].headers.authorization,
+// holds a secret.
+func isSecretKey(key string) bool {
+	generic := arrayIndex.ReplaceAllString(key, "[]")
+	for _, secret := range secretKeys {
+		if table, ok := strings.CutSuffix(secret, "*"); ok {
+			if name, ok := strings.CutPrefix(generic, table); ok && name != "" && !strings.Contains(name, ".") {
+				return true
+			}
+		} else if generic == secret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secretNames returns the names of the values in a table of secrets, with those
+// set with <name>_file or <name>_command named without the suffix.
+func secretNames(table map[string]interface{}) []string {
+	var names []string
+	for name := range table {
+		name = strings.TrimSuffix(strings.TrimSuffix(name, "_file"), "_command")
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// forEachTable calls fn with every table found at the given key parts, where a
+// part ending in [] is an array of tables, along with the table's path.
+func forEachTable(value interface{}, parts []string, path string, fn func(table map[string]interface{}, path string)) {
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if len(parts) == 0 {
+		fn(table, path)
+		return
+	}
+
+	part, isArray := strings.CutSuffix(parts[0], "[]")
+	child := part
+	if path != "" {
+		child = path + "." + part
+	}
+	if !isArray {
+		forEachTable(table[part], parts[1:], child, fn)
+		return
+	}
+	tables, _ := table[part].([]interface{})
+	for i, item := range tables {
+		forEachTable(item, parts[1:], fmt.Sprintf("%s[%d]", child, i), fn)
+	}
+}
+
+// reloadConfig reads the config file again and returns the new configuration of
+// a profile, for the daemon. The file is read into a new viper instance, so that
+// an invalid file leaves the running configuration untouched. An interval given
+// with --interval still overrides the one in the file.
+func reloadConfig(profile string, interval time.Duration) (config.Config, error) {
+	reloaded, err := loadProfile(profile)
+	if err == nil && interval > 0 {
+		reloaded.Interval = interval
+	}
+
+	return reloaded, err
+}
+
+// newConfigViper returns a viper instance with every default set and environment
+// variables bound, that has read the config file at path and its conf.d
+// directory unless path is empty, with the settings of the profile applied
+// unless profile is empty.
+func newConfigViper(path string, profile string) (*viper.Viper, error) {
+	v := viper.New()
+	setConfigDefaults(v)
+
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for key := range envAliases {
+		if err := v.BindEnv(append([]string{key}, configEnvNames(key)...)...); err != nil {
+			return nil, err
+		}
+	}
+
+	if path != "" {
+		if err := readConfigFiles(v, path); err != nil {
+			return nil, err
+		}
+	}
+	if profile != "" {
+		if err := applyProfile(v, profile); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// configEnvNames returns the environment variables that set a key, in order of
+// precedence.
+func configEnvNames(key string) []string {
+	name := envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+
+	return append([]string{name}, envAliases[key]...)
+}
+
+// stringSlice returns the list at key. A list set by an environment variable is
+// separated by commas or spaces.
+func stringSlice(v *viper.Viper, key string) []string {
+	if value, ok := v.Get(key).(string); ok {
+		return strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	}
+
+	return v.GetStringSlice(key)
 }
@@ -0,0 +1,497 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/config"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() {
+		configFile = previous
+	})
+
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+[cloudflare]
+api_token = "first"
+zone_id = "zone"
+update_records = ["home.example.com"]
+
+[daemon]
+interval = "10m"
+`)
+	loaded, err := reloadConfig("", 0)
+	if err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if loaded.APIToken != "first" || loaded.Interval != 10*time.Minute || loaded.BaseURL == "" {
+		t.Errorf("unexpected configuration %+v", loaded)
+	}
+	if loaded, err := reloadConfig("", time.Minute); err != nil || loaded.Interval != time.Minute {
+		t.Errorf("expected --interval to override the interval of the file, got %v, %v", loaded.Interval, err)
+	}
+
+	write(`[cloudflare`)
+	if _, err := reloadConfig("", 0); err == nil {
+		t.Errorf("expected an error for an unparsable config file")
+	}
+
+	write(`
+[cloudflare]
+api_token = "second"
+`)
+	if _, err := reloadConfig("", 0); !errors.Is(err, errMissingRequired) {
+		t.Errorf("expected missing required values to be reported, got %v", err)
+	}
+}
+
+func TestReloadConfigWebhooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() {
+		configFile = previous
+	})
+
+	content := `
+[cloudflare]
+api_token = "token"
+zone_id = "zone"
+update_records = ["home.example.com"]
+
+[notify]
+failure_threshold = 5
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/first"
+headers = { Authorization = "Bearer secret" }
+events = ["update_failed"]
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/second"
+body = '{"text": {{json .Message}}}'
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := reloadConfig("", 0)
+	if err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if loaded.NotifyFailureThreshold != 5 || loaded.NotifyRetries != 3 || loaded.NotifyTimeout != 10*time.Second {
+		t.Errorf("unexpected notify settings %+v", loaded)
+	}
+	if len(loaded.Webhooks) != 2 {
+		t.Fatalf("expected 2 webhooks, got %+v", loaded.Webhooks)
+	}
+	first, second := loaded.Webhooks[0], loaded.Webhooks[1]
+	if first.URL != "https://hooks.example.com/first" || first.Headers["authorization"] != "Bearer secret" || len(first.Events) != 1 {
+		t.Errorf("unexpected first webhook %+v", first)
+	}
+	if second.Body != `{"text": {{json .Message}}}` {
+		t.Errorf("unexpected second webhook %+v", second)
+	}
+}
+
+func TestReloadConfigFromEnvironment(t *testing.T) {
+	previous := configFile
+	configFile = ""
+	t.Cleanup(func() {
+		configFile = previous
+	})
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("CLOUDFLARE_API_TOKEN", "conventional")
+	t.Setenv("CLOUDFLARE_DYNDNS_CLOUDFLARE_ZONE_ID", "zone")
+	t.Setenv("CLOUDFLARE_DYNDNS_CLOUDFLARE_UPDATE_RECORDS", "home.example.com, vpn.example.com")
+	t.Setenv("CLOUDFLARE_DYNDNS_DAEMON_INTERVAL", "1m")
+	t.Setenv("CLOUDFLARE_DYNDNS_MQTT_USERNAME", "dyndns")
+
+	loaded, err := reloadConfig("", 0)
+	if err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if loaded.APIToken != "conventional" || loaded.ZoneID != "zone" || loaded.Interval != time.Minute {
+		t.Errorf("unexpected configuration %+v", loaded)
+	}
+	if len(loaded.UpdateRecords) != 2 || loaded.UpdateRecords[1] != "vpn.example.com" {
+		t.Errorf("unexpected records %q", loaded.UpdateRecords)
+	}
+	if loaded.MQTT.Username != "dyndns" || loaded.MQTT.QoS != 1 || !loaded.MQTT.Discovery {
+		t.Errorf("unexpected MQTT settings %+v", loaded.MQTT)
+	}
+	if filepath.Base(loaded.StateFilePath) != "zone-zone.json" {
+		t.Errorf("unexpected state file %s", loaded.StateFilePath)
+	}
+
+	t.Setenv("CLOUDFLARE_DYNDNS_CLOUDFLARE_API_TOKEN", "prefixed")
+	if loaded, err := reloadConfig("", 0); err != nil || loaded.APIToken != "prefixed" {
+		t.Errorf("expected the prefixed variable to take precedence, got %q, %v", loaded.APIToken, err)
+	}
+}
+
+func TestSettingSource(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	content := `
+[cloudflare]
+zone_id = "zone"
+
+[mqtt]
+broker = "tcp://localhost:1883"
+
+[profiles.office.cloudflare]
+zone_id = "office"
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fragment := filepath.Join(dir, "conf.d", "daemon.toml")
+	if err := os.MkdirAll(filepath.Dir(fragment), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fragment, []byte("[daemon]\ninterval = \"1m\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLOUDFLARE_API_TOKEN", "token")
+	t.Setenv("CLOUDFLARE_DYNDNS_CLOUDFLARE_ZONE_ID", "")
+
+	v, err := newConfigViper(path, "")
+	if err != nil {
+		t.Fatalf("newConfigViper() error = %v", err)
+	}
+	for profile, tests := range map[string]map[string]string{
+		"": {
+			"cloudflare.api_token": "env CLOUDFLARE_API_TOKEN",
+			"cloudflare.zone_id":   path,
+			"mqtt.broker":          path,
+			"mqtt.qos":             "default",
+			"daemon.interval":      fragment,
+		},
+		"office": {
+			"cloudflare.zone_id": path + " (profile office)",
+			"mqtt.broker":        path,
+		},
+	} {
+		sources, err := settingSources(path, profile)
+		if err != nil {
+			t.Fatalf("settingSources() error = %v", err)
+		}
+		for key, expected := range tests {
+			if source := settingSource(sources, key); source != expected {
+				t.Errorf("settingSource(%s) with profile %q = %q, expected %q", key, profile, source, expected)
+			}
+		}
+	}
+	if value := formatSetting("cloudflare.api_token", v.Get("cloudflare.api_token")); value != "(hidden)" {
+		t.Errorf("expected the API token to be hidden, got %s", value)
+	}
+}
+
+func TestReloadConfigSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("secret command tests use a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() {
+		configFile = previous
+	})
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	if err := os.WriteFile(filepath.Join(dir, "cloudflare-token"), []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+[cloudflare]
+api_token_file = "cloudflare-token"
+zone_id = "zone"
+update_records = ["home.example.com"]
+
+[[notify.email]]
+host = "smtp.example.com"
+password_command = "echo smtp-password"
+to = ["admin@example.com"]
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/dyndns"
+headers = { Authorization_command = "echo Bearer header-token", X-Source = "dyndns" }
+`)
+	loaded, err := reloadConfig("", 0)
+	if err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if loaded.APIToken != "file-token" || len(loaded.Email) != 1 || loaded.Email[0].Password != "smtp-password" {
+		t.Errorf("unexpected secrets in configuration %+v", loaded)
+	}
+	if headers := loaded.Webhooks[0].Headers; len(headers) != 2 || headers["authorization"] != "Bearer header-token" {
+		t.Errorf("unexpected webhook headers %v", headers)
+	}
+	if len(loaded.Secrets) != 5 || !slices.Contains(loaded.Secrets, "Bearer header-token") {
+		t.Errorf("expected every secret to be collected, got %d", len(loaded.Secrets))
+	}
+	if len(loaded.SecretFiles) != 1 || loaded.SecretFiles[0] != filepath.Join(dir, "cloudflare-token") {
+		t.Errorf("expected the credential to be collected, got %q", loaded.SecretFiles)
+	}
+
+	write(`
+[cloudflare]
+api_token = "token"
+api_token_command = "echo token"
+zone_id = "zone"
+update_records = ["home.example.com"]
+
+[[notify.ntfy]]
+topic = "dyndns"
+token_command = "exit 1"
+`)
+	_, err = reloadConfig("", 0)
+	var keyErr *config.KeyError
+	if !errors.As(err, &keyErr) || !strings.Contains(err.Error(), "cloudflare.api_token: only one of") || !strings.Contains(err.Error(), "notify.ntfy[0].token_command") {
+		t.Errorf("expected every secret error to be reported, got %v", err)
+	}
+}
+
+func TestReloadConfigYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() {
+		configFile = previous
+	})
+
+	content := `cloudflare:
+  api_token: token
+  zone_id: zone
+  update_records: [home.example.com]
+daemon:
+  interval: 10m
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := reloadConfig("", 0)
+	if err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if loaded.APIToken != "token" || loaded.Interval != 10*time.Minute || len(loaded.UpdateRecords) != 1 {
+		t.Errorf("unexpected configuration %+v", loaded)
+	}
+}
+
+func TestReloadConfigRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() {
+		configFile = previous
+	})
+
+	content := `
+[cloudflare]
+api_token = "token"
+zone_id = "zone"
+
+[[records]]
+name = "home.example.com"
+types = ["AAAA"]
+proxied = false
+ttl = 300
+tags = ["dyndns"]
+comment = "{{.OldIP}} -> {{.IP}}"
+
+[[records]]
+name = "old.example.com"
+enabled = false
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := reloadConfig("", 0)
+	if err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if len(loaded.Records) != 2 || loaded.Records[0].Proxied == nil || *loaded.Records[0].Proxied || loaded.Records[0].TTL != 300 {
+		t.Fatalf("unexpected records %+v", loaded.Records)
+	}
+	if names := loaded.RecordNames(); len(names) != 1 || names[0] != "home.example.com" {
+		t.Errorf("expected only the enabled record, got %q", names)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.toml")
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() {
+		configFile = previous
+	})
+
+	content := `
+[cloudflare]
+api_token = "shared-token"
+update_records = ["home.example.com"]
+
+[daemon]
+interval = "10m"
+
+[profiles.home.cloudflare]
+zone_id = "home-zone"
+
+[profiles.office.cloudflare]
+api_token = "office-token"
+zone_id = "office-zone"
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(confDir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	extra := `
+[profiles.lab.cloudflare]
+zone_id = "lab-zone"
+update_records = ["lab.example.com"]
+
+[profiles.home.daemon]
+interval = "1m"
+`
+	if err := os.WriteFile(filepath.Join(confDir(path), "lab.toml"), []byte(extra), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if profiles, err := listProfiles(); err != nil || strings.Join(profiles, ",") != "home,lab,office" {
+		t.Fatalf("listProfiles() = %q, %v", profiles, err)
+	}
+
+	home, err := loadProfile("home")
+	if err != nil {
+		t.Fatalf("loadProfile() error = %v", err)
+	}
+	if home.APIToken != "shared-token" || home.ZoneID != "home-zone" || home.Interval != time.Minute || home.UpdateRecords[0] != "home.example.com" {
+		t.Errorf("unexpected home profile %+v", home)
+	}
+	office, err := loadProfile("office")
+	if err != nil {
+		t.Fatalf("loadProfile() error = %v", err)
+	}
+	if office.APIToken != "office-token" || office.Interval != 10*time.Minute || office.StateFilePath == home.StateFilePath {
+		t.Errorf("unexpected office profile %+v", office)
+	}
+
+	// Environment variables take precedence over the profiles.
+	t.Setenv("CLOUDFLARE_API_TOKEN", "env-token")
+	if lab, err := loadProfile("lab"); err != nil || lab.APIToken != "env-token" || lab.UpdateRecords[0] != "lab.example.com" {
+		t.Errorf("unexpected lab profile %+v, %v", lab, err)
+	}
+
+	if _, err := loadProfile("missing"); err == nil || !strings.Contains(err.Error(), "choose one of home, lab, office") {
+		t.Errorf("expected an unknown profile to be reported, got %v", err)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are only checked on Unix")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() {
+		configFile = previous
+		strictPermissions = false
+	})
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("token\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf(`
+[cloudflare]
+api_token_file = %q
+zone_id = "zone"
+update_records = ["home.example.com"]
+`, tokenFile)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The problems are only warned about by default.
+	if _, err := reloadConfig("", 0); err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+
+	strictPermissions = true
+	_, err := reloadConfig("", 0)
+	var permErr *config.PermissionError
+	if !errors.As(err, &permErr) || permErr.Path != tokenFile || !strings.Contains(err.Error(), "chmod 600 "+tokenFile) {
+		t.Errorf("expected the token file to be refused, got %v", err)
+	}
+
+	if err := os.Chmod(tokenFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadConfig("", 0); err != nil {
+		t.Errorf("reloadConfig() error = %v", err)
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	home := t.TempDir()
+	configHome := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("XDG_CONFIG_DIRS", t.TempDir())
+
+	if _, err := findConfigFile(); !errors.Is(err, errConfigNotFound) {
+		t.Errorf("expected no config file to be found, got %v", err)
+	}
+
+	path := filepath.Join(configHome, "cloudflare-dyndns", "config.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := findConfigFile(); err != nil || found != path {
+		t.Errorf("findConfigFile() = %s, %v, expected %s", found, err, path)
+	}
+
+	legacy := filepath.Join(home, ".cloudflare-dyndns")
+	if err := os.WriteFile(legacy, []byte(""), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := findConfigFile(); err != nil || found != legacy {
+		t.Errorf("expected ~/.cloudflare-dyndns to be preferred, got %s, %v", found, err)
+	}
+}
@@ -1,125 +1,209 @@
 package cmd
 
 import (
-	"cloudflare-dyndns/cloudflare"
-	"cloudflare-dyndns/ipify"
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/lock"
+	"cloudflare-dyndns/metrics"
+	"cloudflare-dyndns/mqtt"
+	"cloudflare-dyndns/notify"
+	"cloudflare-dyndns/updater"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/TwiN/go-color"
-	"github.com/apex/log"
-	"github.com/jackpal/gateway"
+	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"os"
-	"slices"
 	"strings"
-	"time"
+	"text/tabwriter"
 )
 
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Update your IP address in Cloudflare",
+	Long: `Update your IP address in Cloudflare.
+
+With --all-profiles, the records of every profile in the config file are updated in turn. A profile that
+fails does not stop the others, and the exit status is 1 when any failed.
+
+With --dry-run, the IP address is detected and the zone is read as usual, and a plan of every field each
+record would change to is printed, without writing anything, running hooks or sending notifications.
+--plan-out also writes the plan to a file, which "cloudflare-dyndns apply" writes to the zone once it has
+been reviewed.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Get the gateway information, if configured.
-		if homeGateway := cfg.HomeGateway; len(homeGateway) > 0 {
-			cg, err := gateway.DiscoverGateway()
-			FatalError(err)
-			currentGateway := cg.String()
+		opts := updateOptions(cmd)
+		planOut := cmd.Flag("plan-out").Value.String()
+		header := updateOutputHeader
+		if opts.DryRun {
+			header = planOutputHeader
+		}
 
-			if homeGateway != currentGateway {
-				logger.Info().Msg(fmt.Sprintf("current gateway %s does not match home gateway %s", currentGateway, homeGateway))
-				fmt.Print(color.With(color.Yellow,
-					fmt.Sprintf("Warning: Your current gateway (%s) does not match your home gateway (%s). Exiting.\n", currentGateway, homeGateway)))
-				os.Exit(0)
+		if !allProfilesRequested(cmd) {
+			result, err := runUpdate(cmd, opts, &cfg, logger, nil)
+			if structuredOutput() {
+				output := newUpdateOutput("", result, err)
+				FatalError(writeOutput(os.Stdout, output, header, output.rows()))
 			}
+			FatalError(err)
+			if planOut != "" && result.Plan != nil {
+				FatalError(updater.WritePlan(planOut, result.Plan))
+				_, _ = fmt.Fprintf(messages(), "Plan written to %s. Apply it with: cloudflare-dyndns apply %s\n", planOut, planOut)
+			}
+			return
 		}
-
-		// Get the current IP address to use
-		var currentIp struct {
-			Addr   string
-			IsIPv4 bool
+		if planOut != "" {
+			FatalError("--plan-out cannot be used with --all-profiles, as a plan is for a single zone")
 		}
-		var err error
-		if cmd.Flag("ip").Value.String() == "" {
-			currentIp.Addr, err = ipify.New(&cfg).GetPublicIP()
+
+		profiles, err := listProfiles()
+		FatalError(err)
+		var secrets []string
+		// The profiles sharing a metrics textfile are written to it together, each
+		// with a profile label, once they have all run.
+		textfiles := map[string]*metrics.Set{}
+		var textfileOrder []string
+		outputs := []updateOutput{}
+		failed := 0
+		for _, name := range profiles {
+			if !structuredOutput() {
+				fmt.Printf("Profile %s:\n", name)
+			}
+			var result *updater.Result
+			profileCfg, err := loadProfile(name)
+			if err == nil {
+				secrets = append(secrets, profileCfg.Secrets...)
+				redactor.SetSecrets(secrets)
+				profileCfg.MQTT = mqtt.WithProfile(profileCfg.MQTT, name)
+				result, err = runUpdate(cmd, opts, &profileCfg, logger.With().Str("profile", name).Logger(), func(textfile string) *metrics.Metrics {
+					if textfiles[textfile] == nil {
+						textfiles[textfile] = metrics.NewSet()
+						textfileOrder = append(textfileOrder, textfile)
+					}
+					return textfiles[textfile].Profile(name)
+				})
+			}
+			outputs = append(outputs, newUpdateOutput(name, result, err))
 			if err != nil {
-				message := fmt.Sprintf("Failed to retrieve public IP: %s", err)
-				logger.Error().Msg(message)
-				fmt.Println(message)
+				_, _ = fmt.Fprint(messages(), color.With(color.Red, redactor.Redact(fmt.Sprintf("Error: %v\n", err))))
+				failed++
 			}
-		} else {
-			currentIp.Addr = cmd.Flag("ip").Value.String()
 		}
-
-		if strings.Contains(currentIp.Addr, ".") {
-			currentIp.IsIPv4 = true
-		} else {
-			currentIp.IsIPv4 = false
+		for _, textfile := range textfileOrder {
+			writeMetricsTextfile(textfile, textfiles[textfile], logger)
 		}
-
-		// Update CloudFlare.
-		cloudflareClient := cloudflare.New(&cfg)
-		dnsRecords, dnsErrors, err := cloudflareClient.GetDnsRecords()
-		if err != nil {
-			message := fmt.Sprintf("Failed to get DNS records: %s", err)
-			log.Error(message)
-			fmt.Println(message)
-
-			for _, dnsError := range dnsErrors {
-				message := fmt.Sprintf("DNS record error: %s (code: %d)", dnsError.Message, dnsError.Code)
-				logger.Error().Msg(message)
-				fmt.Println(message)
+		if structuredOutput() {
+			var rows [][]string
+			for _, output := range outputs {
+				rows = append(rows, output.rows()...)
 			}
-			os.Exit(1)
+			FatalError(writeOutput(os.Stdout, outputs, header, rows))
 		}
-
-		var didFindName = false
-		var names []string
-		if cmd.Flag("name").Value.String() != "" {
-			names = append(names, cmd.Flag("name").Value.String())
-		} else {
-			names = cfg.UpdateRecords
+		if failed > 0 {
+			FatalError(fmt.Sprintf("%d of %d profiles failed", failed, len(profiles)))
 		}
+	},
+}
 
-		for _, dnsRecord := range dnsRecords {
-			if slices.Contains(names, dnsRecord.Name) {
-				didFindName = true
-				if currentIp.Addr != dnsRecord.IP {
-					newComment := cmd.Flag("comment").Value.String()
-					fmt.Printf("Updating IP address from \"%s\" to \"%s\".\n", dnsRecord.IP, currentIp.Addr)
-					dnsRecord.IP = currentIp.Addr
-					dnsRecord.Comment = newComment
-					dnsRecord.Type = map[bool]string{true: "A", false: "AAAA"}[currentIp.IsIPv4]
-
-					dnsErrors, err = cloudflareClient.UpdateDnsRecord(dnsRecord)
-					if err != nil {
-						message := fmt.Sprintf("Failed to update DNS record: %s", err)
-						logger.Error().Msg(message)
-						fmt.Println(message)
-
-						for _, dnsError := range dnsErrors {
-							message := fmt.Sprintf("DNS update error - %s (code: %d)", dnsError.Message, dnsError.Code)
-							logger.Error().Msg(message)
-							fmt.Println(message)
-						}
-						os.Exit(1)
-					}
-					message := fmt.Sprintf("IP address for \"%s\" updated.", dnsRecord.Name)
-					logger.Info().Msg(message)
-					fmt.Println(message)
-				} else {
-					message := fmt.Sprintf("IP address for \"%s\" is already up to date.", dnsRecord.Name)
-					logger.Info().Msg(message)
-					fmt.Println(message)
-				}
-			}
-		}
+// updateOptions returns the options of an update run chosen with the flags of
+// the update command.
+func updateOptions(cmd *cobra.Command) updater.Options {
+	opts := updater.Options{IP: cmd.Flag("ip").Value.String()}
+	// Without --comment, the comment templates of [[records]] apply, and the
+	// updater falls back to the default comment for the other records.
+	if cmd.Flags().Changed("comment") {
+		opts.Comment = cmd.Flag("comment").Value.String()
+	}
+	opts.Force, _ = cmd.Flags().GetBool("force")
+	opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+	if name := cmd.Flag("name").Value.String(); name != "" {
+		opts.Names = []string{name}
+	}
+	if cmd.Flag("plan-out").Value.String() != "" {
+		opts.DryRun = true
+	}
+
+	return opts
+}
 
-		if !didFindName {
-			message := fmt.Sprintf("Could not find DNS record with name \"%s\".", strings.Join(names, "\", \""))
-			logger.Warn().Msg(message)
-			fmt.Println(message)
+// runUpdate runs a single update with the given configuration, and reports the
+// result through the configured notifiers, MQTT and metrics. The result is nil
+// when the update could not be started. The metrics textfile is written straight
+// away, unless observe is given to collect the metrics of the run for it.
+func runUpdate(cmd *cobra.Command, opts updater.Options, runCfg *config.Config, runLogger zerolog.Logger, observe func(textfile string) *metrics.Metrics) (*updater.Result, error) {
+	// A dry run only reads, so it neither waits for other runs nor reports.
+	if opts.DryRun {
+		result, err := updater.New(runCfg, runLogger).Run(context.Background(), opts)
+		if !structuredOutput() {
+			printPlan(result)
+		} else if result.Skipped {
+			_, _ = fmt.Fprint(messages(), color.With(color.Yellow,
+				fmt.Sprintf("Warning: Not on the home network, as %s. Exiting.\n", result.SkipReason)))
 		}
-	},
+		return result, err
+	}
+
+	// Let an overlapping run from cron finish its work instead of racing it.
+	runLock, err := acquireLock(context.Background(), cmd, runCfg)
+	if errors.Is(err, lock.ErrLocked) {
+		runLogger.Info().Msg(fmt.Sprintf("skipping the update: %v", err))
+		_, _ = fmt.Fprint(messages(), color.With(color.Yellow, "Warning: Another update using this config file is in progress. Exiting.\n"))
+		return &updater.Result{Skipped: true, SkipReason: "another update using this config file is in progress"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer runLock.Release()
+
+	notifier, err := notify.New(runCfg, runLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	u := updater.New(runCfg, runLogger)
+
+	// Record the run for the node_exporter textfile collector, if configured.
+	textfile := runCfg.MetricsTextfile
+	if cmd.Flags().Changed("metrics-textfile") {
+		textfile = cmd.Flag("metrics-textfile").Value.String()
+	}
+	var runMetrics *metrics.Metrics
+	if textfile != "" && observe != nil {
+		u.Observer = observe(textfile)
+	} else if textfile != "" {
+		runMetrics = metrics.New()
+		u.Observer = runMetrics
+	}
+
+	result, err := u.Run(context.Background(), opts)
+	if !structuredOutput() {
+		printResult(result)
+	} else if result.Skipped {
+		_, _ = fmt.Fprint(messages(), color.With(color.Yellow,
+			fmt.Sprintf("Warning: Not on the home network, as %s. Exiting.\n", result.SkipReason)))
+	}
+
+	if notifyErr := notifier.Dispatch(context.Background(), result, err); notifyErr != nil {
+		_, _ = fmt.Fprint(messages(), redactor.Redact(fmt.Sprintf("Unable to send notifications: %v\n", notifyErr)))
+	}
+
+	if runCfg.MQTT.Broker != "" {
+		publishMQTT(runCfg.MQTT, result, err)
+	}
+
+	if runMetrics != nil {
+		writeMetricsTextfile(textfile, runMetrics, runLogger)
+	}
+
+	return result, err
+}
+
+// writeMetricsTextfile writes the metrics for the node_exporter textfile
+// collector, reporting a failure without failing the update.
+func writeMetricsTextfile(textfile string, c metrics.Collector, runLogger zerolog.Logger) {
+	if err := metrics.WriteTextfile(textfile, c); err != nil {
+		runLogger.Error().Msg(fmt.Sprintf("unable to write metrics textfile %s: %v", textfile, err))
+		_, _ = fmt.Fprintf(messages(), "Unable to write metrics textfile %s: %v\n", textfile, err)
+	}
 }
 
 func init() {
@@ -127,10 +211,205 @@ func init() {
 
 	updateCmd.Flags().StringP("name", "n", "", "The name of the DNS record to update. If not specified, the name will be read from the config file.")
 	updateCmd.Flags().StringP("ip", "i", "", "Update the IP address of the DNS record to this value. If not specified, the current public IP address will be used.")
-	updateCmd.Flags().StringP("comment", "c", getDefaultComment(), "Update the comment of the DNS record.")
+	updateCmd.Flags().StringP("comment", "c", "", "Update the comment of the DNS record. If not specified, the comment of the record in the config file is used, or a comment with the time of the update.")
+	updateCmd.Flags().BoolP("force", "f", false, "Read and compare the DNS records even if the IP address has not changed since the last update.")
+	updateCmd.Flags().Bool("dry-run", false, "Print the changes that would be made to the records without making them.")
+	updateCmd.Flags().String("plan-out", "", "Write the changes that would be made to this file, for the apply command, without making them. Implies --dry-run.")
+	updateCmd.Flags().String("metrics-textfile", "", "Write the results of the run in the Prometheus text format to this file, for the node_exporter textfile collector.")
+	addLockFlags(updateCmd)
+	addProfileFlags(updateCmd)
 	updateCmd.Flags().BoolP("help", "h", false, "Show help for the update command.")
 }
 
-func getDefaultComment() string {
-	return "Updated " + time.Now().UTC().Format("2006-01-02T15:04:05")
+// printResult prints what happened during an update run.
+func printResult(result *updater.Result) {
+	if result.Skipped {
+		fmt.Print(color.With(color.Yellow,
+			fmt.Sprintf("Warning: Not on the home network, as %s. Exiting.\n", result.SkipReason)))
+		return
+	}
+
+	for _, record := range result.Records {
+		switch record.Action {
+		case updater.ActionUnchanged:
+			fmt.Printf("IP address for \"%s\" is already up to date.\n", record.Name)
+		case updater.ActionUpdated:
+			fmt.Printf("Updating IP address from \"%s\" to \"%s\".\n", record.OldIP, record.NewIP)
+			fmt.Printf("IP address for \"%s\" updated.\n", record.Name)
+		case updater.ActionFailed:
+			fmt.Print(redactor.Redact(fmt.Sprintf("Failed to update IP address for \"%s\": %s\n", record.Name, record.Error)))
+		}
+	}
+
+	if len(result.Missing) > 0 {
+		fmt.Printf("Could not find DNS record with name \"%s\".\n", strings.Join(result.Missing, "\", \""))
+	}
+
+	for _, hookErr := range result.HookErrors {
+		fmt.Print(color.With(color.Yellow, redactor.Redact(fmt.Sprintf("Warning: %v\n", hookErr))))
+	}
+}
+
+// printPlan prints the changes a dry run would make, one field per line.
+func printPlan(result *updater.Result) {
+	if result.Skipped || result.Plan == nil {
+		printResult(result)
+		return
+	}
+
+	if len(result.Plan.Changes) == 0 {
+		fmt.Println("No changes. The records are up to date.")
+	} else {
+		fmt.Printf("Plan: %d record(s) to update in zone %s.\n", len(result.Plan.Changes), result.Plan.ZoneID)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "RECORD\tTYPE\tFIELD\tCHANGE")
+		for _, change := range result.Plan.Changes {
+			for _, field := range change.Fields() {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s → %s\n", change.After.Name, change.After.Type, field.Field, orDash(field.Old), orDash(field.New))
+			}
+		}
+		_ = w.Flush()
+	}
+
+	if len(result.Missing) > 0 {
+		fmt.Printf("Could not find DNS record with name \"%s\".\n", strings.Join(result.Missing, "\", \""))
+	}
+}
+
+// orDash returns s, or "-" when it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// publishMQTT sends the result of a run to the MQTT broker.
+func publishMQTT(broker config.MQTT, result *updater.Result, err error) {
+	publisher, mqttErr := mqtt.New(broker, Version, logger)
+	if mqttErr == nil {
+		mqttErr = publisher.Publish(result, err)
+		publisher.Close()
+	}
+	if mqttErr != nil {
+		logger.Error().Msg(fmt.Sprintf("unable to publish to MQTT: %v", mqttErr))
+		_, _ = fmt.Fprint(messages(), redactor.Redact(fmt.Sprintf("Unable to publish to MQTT: %v\n", mqttErr)))
+	}
+}
+
+// updateOutput is the result of an update, as printed with --output.
+type updateOutput struct {
+	Profile    string         `json:"profile,omitempty"`
+	IP         string         `json:"ip,omitempty"`
+	Skipped    bool           `json:"skipped"`
+	SkipReason string         `json:"skip_reason,omitempty"`
+	Records    []recordOutput `json:"records"`
+	HookErrors []string       `json:"hook_errors,omitempty"`
+	// Plan holds the fields a dry run would change.
+	Plan  []fieldOutput `json:"plan,omitempty"`
+	Error string        `json:"error,omitempty"`
+
+	dryRun bool
+}
+
+// fieldOutput is a field of a record that a dry run would change, as printed
+// with --output.
+type fieldOutput struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Field  string `json:"field"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+	Reason string `json:"reason"`
+}
+
+// recordOutput is what happened to a record during an update, as printed with
+// --output. The action is one of updated, unchanged, failed, or missing when no
+// record with the name was found in the zone, or planned in a dry run.
+type recordOutput struct {
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// updateOutputHeader names the columns of the CSV output of update.
+var updateOutputHeader = []string{"profile", "name", "type", "old", "new", "action", "reason", "error"}
+
+// planOutputHeader names the columns of the CSV output of update --dry-run.
+var planOutputHeader = []string{"profile", "name", "type", "field", "old", "new", "reason"}
+
+// newUpdateOutput describes the result of an update for a profile, or for the
+// whole config file when profile is empty. Secrets are hidden from the errors.
+func newUpdateOutput(profile string, result *updater.Result, err error) updateOutput {
+	output := updateOutput{Profile: profile, Records: []recordOutput{}}
+	if err != nil {
+		output.Error = redactor.Redact(err.Error())
+	}
+	if result == nil {
+		return output
+	}
+
+	output.IP = result.IP
+	output.Skipped = result.Skipped
+	output.SkipReason = result.SkipReason
+	for _, record := range result.Records {
+		recordOut := recordOutput{
+			Name:   record.Name,
+			Type:   record.Type,
+			Old:    record.OldIP,
+			New:    record.NewIP,
+			Action: string(record.Action),
+			Reason: string(record.Reason),
+		}
+		if record.Error != nil {
+			recordOut.Error = redactor.Redact(record.Error.Error())
+		}
+		output.Records = append(output.Records, recordOut)
+	}
+	for _, name := range result.Missing {
+		output.Records = append(output.Records, recordOutput{Name: name, Action: "missing", Error: "no DNS record with this name was found"})
+	}
+	for _, hookErr := range result.HookErrors {
+		output.HookErrors = append(output.HookErrors, redactor.Redact(hookErr.Error()))
+	}
+	if result.Plan != nil {
+		output.dryRun = true
+		output.Plan = []fieldOutput{}
+		for _, change := range result.Plan.Changes {
+			for _, field := range change.Fields() {
+				output.Plan = append(output.Plan, fieldOutput{
+					Name:   change.After.Name,
+					Type:   change.After.Type,
+					Field:  field.Field,
+					Old:    field.Old,
+					New:    field.New,
+					Reason: string(change.Reason),
+				})
+			}
+		}
+	}
+
+	return output
+}
+
+// rows returns a CSV row for every record in the output, or for every field
+// planned to change in a dry run.
+func (o updateOutput) rows() [][]string {
+	var rows [][]string
+	if o.dryRun {
+		for _, field := range o.Plan {
+			rows = append(rows, []string{o.Profile, field.Name, field.Type, field.Field, field.Old, field.New, field.Reason})
+		}
+		return rows
+	}
+	for _, record := range o.Records {
+		rows = append(rows, []string{o.Profile, record.Name, record.Type, record.Old, record.New, record.Action, record.Reason, record.Error})
+	}
+
+	return rows
 }
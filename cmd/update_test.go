@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestUpdateCommentTemplate(t *testing.T) {
+	var mu sync.Mutex
+	var updated []cloudflare.DnsRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPut {
+			var record cloudflare.DnsRecord
+			_ = json.NewDecoder(r.Body).Decode(&record)
+			updated = append(updated, record)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": record})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": []cloudflare.DnsRecord{
+			{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"},
+		}})
+	}))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{name: "template", args: []string{"--ip", "2.2.2.2"}, expected: "1.1.1.1 -> 2.2.2.2"},
+		{name: "flag", args: []string{"--ip", "2.2.2.2", "--comment", "manual"}, expected: "manual"},
+		{name: "dry_run", args: []string{"--ip", "2.2.2.2", "--dry-run"}, expected: "1.1.1.1 -> 2.2.2.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetFlags(t, updateCmd)
+			if err := updateCmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			runCfg := &config.Config{
+				APIToken:      "token",
+				BaseURL:       server.URL,
+				ZoneID:        "zone",
+				Records:       []config.Record{{Name: "home.example.com", Comment: "{{.OldIP}} -> {{.IP}}"}},
+				StateFilePath: filepath.Join(dir, "state.json"),
+				LockFilePath:  filepath.Join(dir, "lock"),
+			}
+			mu.Lock()
+			updated = nil
+			mu.Unlock()
+
+			result, err := runUpdate(updateCmd, updateOptions(updateCmd), runCfg, zerolog.Nop(), nil)
+			if err != nil {
+				t.Fatalf("runUpdate() error = %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if result.Plan != nil {
+				if len(updated) != 0 || len(result.Plan.Changes) != 1 || result.Plan.Changes[0].After.Comment != tt.expected {
+					t.Errorf("expected a plan setting comment %q, got %+v", tt.expected, result.Plan)
+				}
+				return
+			}
+			if len(updated) != 1 || updated[0].Comment != tt.expected {
+				t.Errorf("expected the record to be updated with comment %q, got %+v", tt.expected, updated)
+			}
+		})
+	}
+}
+
+// resetFlags sets the flags of cmd back to their defaults, now and once the
+// test is done, as flags parsed by one test stay set for the next.
+func resetFlags(t *testing.T, cmd *cobra.Command) {
+	t.Helper()
+
+	reset := func() {
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+			_ = flag.Value.Set(flag.DefValue)
+			flag.Changed = false
+		})
+	}
+	reset()
+	t.Cleanup(reset)
+}
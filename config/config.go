@@ -1,12 +1,207 @@
 package config
 
+import (
+	"slices"
+	"time"
+)
+
 type Config struct {
 	APIToken      string
 	BaseURL       string
 	ZoneID        string
 	UpdateRecords []string
+	Records       []Record
 	UserAgent     string
 	LogFilePath   string
 	HomeGateway   string
+	Network       Network
 	IpifyURL      string
+	PrefixLength  int
+	MigratePrefix bool
+	StateFilePath string
+	LockFilePath  string
+	LockWait      bool
+	FollowIP      bool
+	FollowTags    []string
+	FollowComment string
+
+	Interval          time.Duration
+	Jitter            time.Duration
+	ReconcileInterval time.Duration
+	WatchNetwork      bool
+	Debounce          time.Duration
+	WatchConfig       bool
+	StaleLockAfter    time.Duration
+
+	MetricsListen         string
+	MetricsUnhealthyAfter time.Duration
+	MetricsTextfile       string
+
+	PreUpdateHook  string
+	PostUpdateHook string
+	HookTimeout    time.Duration
+	HookVeto       bool
+	RecordHooks    []RecordHook
+
+	MQTT MQTT
+
+	// Secrets holds the value of every secret in the configuration, so that
+	// they can be kept out of logs.
+	Secrets []string
+	// SecretFiles holds the files that secrets were read from, so that their
+	// permissions can be checked.
+	SecretFiles []string
+
+	NotifyFailureThreshold int
+	NotifyTimeout          time.Duration
+	NotifyRetries          int
+	Webhooks               []Webhook
+	Slack                  []Slack
+	Discord                []Discord
+	Ntfy                   []Ntfy
+	Gotify                 []Gotify
+	Telegram               []Telegram
+	Email                  []Email
+}
+
+// Network holds the rules that decide whether the machine is on the home
+// network, where records are updated from.
+type Network struct {
+	// Match is "all" when every rule must match, and "any" when one is enough.
+	Match             string
+	Gateways          []string
+	GatewayMACs       []string
+	IPv6Gateways      []string
+	RequireInterfaces []string
+	ForbidInterfaces  []string
+}
+
+// MQTT is a broker that the published addresses and the status of updates are
+// sent to, with Home Assistant discovery.
+type MQTT struct {
+	Broker          string        `mapstructure:"broker"`
+	Username        string        `mapstructure:"username"`
+	Password        string        `mapstructure:"password"`
+	ClientID        string        `mapstructure:"client_id"`
+	CAFile          string        `mapstructure:"ca_file"`
+	CertFile        string        `mapstructure:"cert_file"`
+	KeyFile         string        `mapstructure:"key_file"`
+	QoS             int           `mapstructure:"qos"`
+	Retain          bool          `mapstructure:"retain"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	TopicPrefix     string        `mapstructure:"topic_prefix"`
+	IPv4Topic       string        `mapstructure:"ipv4_topic"`
+	IPv6Topic       string        `mapstructure:"ipv6_topic"`
+	LastUpdateTopic string        `mapstructure:"last_update_topic"`
+	StatusTopic     string        `mapstructure:"status_topic"`
+	Discovery       bool          `mapstructure:"discovery"`
+	DiscoveryPrefix string        `mapstructure:"discovery_prefix"`
+	NodeID          string        `mapstructure:"node_id"`
+}
+
+// Record is a DNS record kept up to date with its own settings, as an
+// alternative to listing its name in update_records.
+type Record struct {
+	Name string `mapstructure:"name"`
+	// Types limits the record to A or AAAA. Both are updated when empty.
+	Types []string `mapstructure:"types"`
+	// IPSource is "ipify" or empty for the detected address, the URL of a
+	// service returning the address as plain text, or a fixed address.
+	IPSource string `mapstructure:"ip_source"`
+	// Proxied, TTL and Tags are left as they are in Cloudflare when unset.
+	Proxied *bool    `mapstructure:"proxied"`
+	TTL     int      `mapstructure:"ttl"`
+	Tags    []string `mapstructure:"tags"`
+	// Comment is a Go template for the comment set when the record is updated.
+	Comment string `mapstructure:"comment"`
+	Enabled *bool  `mapstructure:"enabled"`
+}
+
+// IsEnabled reports whether the record is updated, which it is unless enabled
+// is set to false.
+func (r Record) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// RecordNames returns the names of every record kept up to date, from
+// update_records and the enabled [[records]].
+func (c *Config) RecordNames() []string {
+	names := slices.Clone(c.UpdateRecords)
+	for _, record := range c.Records {
+		if record.IsEnabled() && !slices.Contains(names, record.Name) {
+			names = append(names, record.Name)
+		}
+	}
+
+	return names
+}
+
+// RecordHook holds the commands run before and after a single record is updated.
+type RecordHook struct {
+	Name       string `mapstructure:"name"`
+	PreUpdate  string `mapstructure:"pre_update"`
+	PostUpdate string `mapstructure:"post_update"`
+}
+
+// Webhook is a URL that notifications are sent to, with a body rendered from a
+// Go template.
+type Webhook struct {
+	URL         string            `mapstructure:"url"`
+	Method      string            `mapstructure:"method"`
+	Headers     map[string]string `mapstructure:"headers"`
+	ContentType string            `mapstructure:"content_type"`
+	Body        string            `mapstructure:"body"`
+	Events      []string          `mapstructure:"events"`
+}
+
+// Slack is a Slack incoming webhook that notifications are posted to.
+type Slack struct {
+	WebhookURL string   `mapstructure:"webhook_url"`
+	Events     []string `mapstructure:"events"`
+}
+
+// Discord is a Discord channel webhook that notifications are posted to.
+type Discord struct {
+	WebhookURL string   `mapstructure:"webhook_url"`
+	Username   string   `mapstructure:"username"`
+	Events     []string `mapstructure:"events"`
+}
+
+// Ntfy is an ntfy topic that notifications are published to.
+type Ntfy struct {
+	BaseURL  string   `mapstructure:"base_url"`
+	Topic    string   `mapstructure:"topic"`
+	Token    string   `mapstructure:"token"`
+	Priority int      `mapstructure:"priority"`
+	Tags     []string `mapstructure:"tags"`
+	Events   []string `mapstructure:"events"`
+}
+
+// Gotify is a Gotify server that notifications are sent to as messages.
+type Gotify struct {
+	BaseURL  string   `mapstructure:"base_url"`
+	Token    string   `mapstructure:"token"`
+	Priority int      `mapstructure:"priority"`
+	Events   []string `mapstructure:"events"`
+}
+
+// Telegram is a Telegram chat that a bot sends notifications to.
+type Telegram struct {
+	BaseURL  string   `mapstructure:"base_url"`
+	BotToken string   `mapstructure:"bot_token"`
+	ChatID   string   `mapstructure:"chat_id"`
+	Events   []string `mapstructure:"events"`
+}
+
+// Email is an SMTP server and the addresses notifications are mailed to.
+type Email struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	TLS      string   `mapstructure:"tls"`
+	Auth     string   `mapstructure:"auth"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+	Events   []string `mapstructure:"events"`
 }
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// The formats a config file can be written in.
+const (
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// KeyError is a problem with the value of a config key.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// Key is a key set in a config file, along with the line it is set on.
+type Key struct {
+	Path string
+	Line int
+}
+
+// FormatOf returns the format of a config file from its extension. Files
+// without a known extension, such as ~/.cloudflare-dyndns or the %d/config
+// credential of a systemd service, are TOML.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatTOML
+	}
+}
+
+// SyntaxError is a config file that cannot be parsed.
+type SyntaxError struct {
+	Line int
+	Err  error
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// Decode parses a config file in the given format. Errors in the syntax of the
+// file are returned as a *SyntaxError.
+func Decode(data []byte, format string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			line := 0
+			if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+				line, _ = strconv.Atoi(match[1])
+			}
+			return nil, &SyntaxError{Line: line, Err: err}
+		}
+	case FormatJSON:
+		if err := json.Unmarshal(data, &values); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, &SyntaxError{Line: lineAt(data, syntaxErr.Offset), Err: err}
+			}
+			return nil, &SyntaxError{Err: err}
+		}
+	default:
+		if err := toml.Unmarshal(data, &values); err != nil {
+			var decodeErr *toml.DecodeError
+			if errors.As(err, &decodeErr) {
+				line, _ := decodeErr.Position()
+				return nil, &SyntaxError{Line: line, Err: err}
+			}
+			return nil, &SyntaxError{Err: err}
+		}
+	}
+
+	return values, nil
+}
+
+// lineAt returns the line of the byte at offset.
+func lineAt(data []byte, offset int64) int {
+	offset = min(offset, int64(len(data)))
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Keys returns every table and key set in a config document, in order. Tables
+// in arrays of tables are numbered, as in notify.webhooks[0].url.
+func Keys(data []byte, format string) ([]Key, error) {
+	switch format {
+	case FormatYAML:
+		return yamlKeys(data)
+	case FormatJSON:
+		return jsonKeys(data)
+	default:
+		return tomlKeys(data)
+	}
+}
+
+// tomlKeys returns the keys of a TOML document. The keys of inline tables are
+// not included.
+func tomlKeys(data []byte) ([]Key, error) {
+	var keys []Key
+	var table string
+	arrayTables := map[string]int{}
+
+	p := unstable.Parser{}
+	p.Reset(data)
+	for p.NextExpression() {
+		expr := p.Expression()
+
+		var parts []string
+		var line int
+		it := expr.Key()
+		if expr.Kind != unstable.Table && expr.Kind != unstable.ArrayTable && expr.Kind != unstable.KeyValue {
+			continue
+		}
+		for it.Next() {
+			node := it.Node()
+			if line == 0 {
+				line = p.Shape(node.Raw).Start.Line
+			}
+			parts = append(parts, string(node.Data))
+		}
+		path := strings.Join(parts, ".")
+
+		switch expr.Kind {
+		case unstable.Table:
+			table = path
+		case unstable.ArrayTable:
+			table = fmt.Sprintf("%s[%d]", path, arrayTables[path])
+			arrayTables[path]++
+		case unstable.KeyValue:
+			if table != "" {
+				path = table + "." + path
+			}
+			keys = append(keys, Key{Path: path, Line: line})
+			continue
+		}
+		keys = append(keys, Key{Path: table, Line: line})
+	}
+
+	if err := p.Error(); err != nil {
+		var parserErr *unstable.ParserError
+		if errors.As(err, &parserErr) {
+			return nil, &SyntaxError{Line: p.Shape(p.Range(parserErr.Highlight)).Start.Line, Err: err}
+		}
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// yamlKeys returns the keys of a YAML document.
+func yamlKeys(data []byte) ([]Key, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				child := joinKey(path, key.Value)
+				keys = append(keys, Key{Path: child, Line: key.Line})
+				walk(value, child)
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				if item.Kind == yaml.MappingNode {
+					child := fmt.Sprintf("%s[%d]", path, i)
+					keys = append(keys, Key{Path: child, Line: item.Line})
+					walk(item, child)
+				}
+			}
+		}
+	}
+	walk(&document, "")
+
+	return keys, nil
+}
+
+// jsonKeys returns the keys of a JSON document.
+func jsonKeys(data []byte) ([]Key, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	var keys []Key
+	var walk func(path string, element bool) error
+	walk = func(path string, element bool) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'):
+			if element {
+				keys = append(keys, Key{Path: path, Line: lineAt(data, decoder.InputOffset())})
+			}
+			for decoder.More() {
+				token, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				child := joinKey(path, token.(string))
+				keys = append(keys, Key{Path: child, Line: lineAt(data, decoder.InputOffset())})
+				if err := walk(child, false); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i), true); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+
+		// The closing delimiter.
+		_, err = decoder.Token()
+		return err
+	}
+	if err := walk("", false); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	data := []byte(`# comment
+[main]
+home_gateway = "192.168.1.1"
+
+[cloudflare]
+update_records = [
+  "home.example.com",
+]
+follow.ip = true
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/first"
+headers = { Authorization = "Bearer secret" }
+
+[[notify.webhooks]]
+url = "https://hooks.example.com/second"
+`)
+
+	keys, err := Keys(data, FormatTOML)
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	expected := []Key{
+		{Path: "main", Line: 2},
+		{Path: "main.home_gateway", Line: 3},
+		{Path: "cloudflare", Line: 5},
+		{Path: "cloudflare.update_records", Line: 6},
+		{Path: "cloudflare.follow.ip", Line: 9},
+		{Path: "notify.webhooks[0]", Line: 11},
+		{Path: "notify.webhooks[0].url", Line: 12},
+		{Path: "notify.webhooks[0].headers", Line: 13},
+		{Path: "notify.webhooks[1]", Line: 15},
+		{Path: "notify.webhooks[1].url", Line: 16},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Keys() = %+v, expected %+v", keys, expected)
+	}
+
+	if _, err := Keys([]byte("[main\n"), FormatTOML); err == nil {
+		t.Errorf("expected an error for invalid TOML")
+	}
+}
+
+func TestKeysYAMLAndJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{
+			name:   "yaml",
+			format: FormatYAML,
+			data: `cloudflare:
+  update_records:
+    - home.example.com
+notify:
+  webhooks:
+    - url: https://hooks.example.com/first
+    - url: https://hooks.example.com/second
+`,
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			data: `{
+  "cloudflare": {
+    "update_records": ["home.example.com"]
+  },
+  "notify": {
+    "webhooks": [
+      {"url": "https://hooks.example.com/first"},
+      {"url": "https://hooks.example.com/second"}
+    ]
+  }
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := Keys([]byte(tt.data), tt.format)
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			lines := map[string]int{}
+			for _, key := range keys {
+				lines[key.Path] = key.Line
+			}
+			expected := map[string]int{
+				"cloudflare":                1,
+				"cloudflare.update_records": 2,
+				"notify":                    4,
+				"notify.webhooks":           5,
+				"notify.webhooks[0]":        6,
+				"notify.webhooks[0].url":    6,
+				"notify.webhooks[1]":        7,
+				"notify.webhooks[1].url":    7,
+			}
+			if tt.format == FormatJSON {
+				for path, line := range expected {
+					expected[path] = line + 1
+				}
+			}
+			if !reflect.DeepEqual(lines, expected) {
+				t.Errorf("Keys() lines = %v, expected %v", lines, expected)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		line   int
+	}{
+		{name: "toml", format: FormatTOML, data: "[main]\nhome_gateway = \n", line: 2},
+		{name: "yaml", format: FormatYAML, data: "main:\n  home_gateway: [\n", line: 2},
+		{name: "json", format: FormatJSON, data: "{\n  \"main\": {\n    \"home_gateway\": ,\n", line: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode([]byte(tt.data), tt.format)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || syntaxErr.Line != tt.line {
+				t.Errorf("Decode() error = %#v, expected a syntax error on line %d", err, tt.line)
+			}
+		})
+	}
+
+	values, err := Decode([]byte("main:\n  home_gateway: 192.168.1.1\n"), FormatYAML)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if main, _ := values["main"].(map[string]interface{}); main["home_gateway"] != "192.168.1.1" {
+		t.Errorf("unexpected values %v", values)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]string{
+		"/etc/cloudflare-dyndns/config.yaml": FormatYAML,
+		"config.YML":                         FormatYAML,
+		"config.json":                        FormatJSON,
+		"config.toml":                        FormatTOML,
+		"/root/.cloudflare-dyndns":           FormatTOML,
+		"/run/credentials/x.service/config":  FormatTOML,
+	}
+	for path, expected := range tests {
+		if format := FormatOf(path); format != expected {
+			t.Errorf("FormatOf(%s) = %s, expected %s", path, format, expected)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+)
+
+// PermissionError describes a config or secret file that other users can
+// access, or that belongs to another user.
+type PermissionError struct {
+	Path string
+	Mode os.FileMode
+	// Owner is the user ID owning the file when it is neither the current user
+	// nor root, and -1 otherwise.
+	Owner int
+}
+
+// Open reports whether users other than the owner can access the file.
+func (e *PermissionError) Open() bool {
+	return e.Mode&0o077 != 0
+}
+
+func (e *PermissionError) Error() string {
+	var problems []string
+	if e.Open() {
+		problems = append(problems, fmt.Sprintf("can be accessed by other users (mode %04o)", e.Mode))
+	}
+	if e.Owner >= 0 {
+		problems = append(problems, fmt.Sprintf("is owned by another user (uid %d)", e.Owner))
+	}
+
+	return fmt.Sprintf("%s %s", e.Path, strings.Join(problems, " and "))
+}
+
+// Fix returns the commands that give the file safe permissions.
+func (e *PermissionError) Fix() string {
+	var commands []string
+	if e.Owner >= 0 {
+		owner := fmt.Sprint(os.Getuid())
+		if current, err := user.Current(); err == nil {
+			owner = current.Username
+		}
+		commands = append(commands, fmt.Sprintf("chown %s %s", owner, e.Path))
+	}
+	if e.Open() {
+		commands = append(commands, fmt.Sprintf("chmod 600 %s", e.Path))
+	}
+
+	return strings.Join(commands, " && ")
+}
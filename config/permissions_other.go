@@ -0,0 +1,9 @@
+//go:build !unix
+
+package config
+
+// CheckPermissions is only supported on Unix, where files have an owner and a
+// mode that other users can be denied by.
+func CheckPermissions(path string) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+// CheckPermissions returns a *PermissionError when the file at path can be
+// accessed by other users, or is owned by a user other than the current one or
+// root, as ssh does for private keys.
+func CheckPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	permErr := &PermissionError{Path: path, Mode: info.Mode().Perm(), Owner: -1}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		permErr.Owner = int(stat.Uid)
+	}
+	if uid := os.Getuid(); permErr.Owner == uid || permErr.Owner == 0 {
+		permErr.Owner = -1
+	}
+	if !permErr.Open() && permErr.Owner < 0 {
+		return nil
+	}
+
+	return permErr
+}
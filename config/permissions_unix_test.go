@@ -0,0 +1,63 @@
+//go:build unix
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	tests := []struct {
+		name string
+		mode os.FileMode
+		open bool
+	}{
+		{name: "private", mode: 0600},
+		{name: "readOnly", mode: 0400},
+		{name: "groupReadable", mode: 0640, open: true},
+		{name: "worldReadable", mode: 0644, open: true},
+		{name: "groupWritable", mode: 0620, open: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte("[main]\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			err := CheckPermissions(path)
+			var permErr *PermissionError
+			if !tt.open {
+				if err != nil {
+					t.Errorf("CheckPermissions() error = %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &permErr) || permErr.Mode != tt.mode || permErr.Owner != -1 {
+				t.Fatalf("CheckPermissions() = %v, want a PermissionError for mode %04o", err, tt.mode)
+			}
+			if fix := permErr.Fix(); fix != "chmod 600 "+path {
+				t.Errorf("Fix() = %q", fix)
+			}
+		})
+	}
+
+	if err := CheckPermissions(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file to be reported, got %v", err)
+	}
+}
+
+func TestPermissionError(t *testing.T) {
+	err := &PermissionError{Path: "/etc/cloudflare-dyndns/config.toml", Mode: 0644, Owner: 1001}
+	expected := "/etc/cloudflare-dyndns/config.toml can be accessed by other users (mode 0644) and is owned by another user (uid 1001)"
+	if err.Error() != expected {
+		t.Errorf("Error() = %q, want %q", err.Error(), expected)
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretFilePath returns the file a secret is read from. When running as a
+// systemd service with credentials, a relative path is looked up in
+// $CREDENTIALS_DIRECTORY first, so that a credential can be named directly.
+func SecretFilePath(path string) string {
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" && !filepath.IsAbs(path) {
+		credential := filepath.Join(dir, path)
+		if _, err := os.Stat(credential); err == nil {
+			return credential
+		}
+	}
+
+	return path
+}
+
+// ReadSecretFile returns the trimmed contents of a file holding a secret, found
+// with SecretFilePath.
+func ReadSecretFile(path string) (string, error) {
+	path = SecretFilePath(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+
+	return secret, nil
+}
+
+// RunSecretCommand runs a command through the shell, such as
+// "pass show cloudflare/ddns", and returns the trimmed output as the secret.
+func RunSecretCommand(command string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = os.Stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%q timed out after %s", command, timeout)
+	}
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%q failed: %w: %s", command, err, message)
+		}
+		return "", fmt.Errorf("%q failed: %w", command, err)
+	}
+	secret := strings.TrimSpace(stdout.String())
+	if secret == "" {
+		return "", fmt.Errorf("%q printed nothing", command)
+	}
+
+	return secret, nil
+}
+
+// Redactor replaces secrets with [REDACTED] in text, such as log messages and
+// errors. The secrets can be changed while it is in use.
+type Redactor struct {
+	mu       sync.RWMutex
+	replacer *strings.Replacer
+}
+
+// SetSecrets replaces the secrets that are redacted.
+func (r *Redactor) SetSecrets(secrets []string) {
+	// Replace longer secrets first, in case one contains another.
+	sorted := append([]string(nil), secrets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	var pairs []string
+	for _, secret := range sorted {
+		if secret != "" {
+			pairs = append(pairs, secret, "[REDACTED]")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replacer = nil
+	if len(pairs) > 0 {
+		r.replacer = strings.NewReplacer(pairs...)
+	}
+}
+
+// Redact returns s with every secret replaced.
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.replacer == nil {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// Writer returns a writer that redacts secrets before writing to w. Each write
+// should hold whole lines, as a secret split across writes is not redacted.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactWriter{redactor: r, w: w}
+}
+
+type redactWriter struct {
+	redactor *Redactor
+	w        io.Writer
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	if err := os.WriteFile(path, []byte("  secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if secret, err := ReadSecretFile(path); err != nil || secret != "secret" {
+		t.Errorf("ReadSecretFile() = %q, %v", secret, err)
+	}
+
+	// Relative paths are looked up in the systemd credentials directory first.
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	if secret, err := ReadSecretFile("token"); err != nil || secret != "secret" {
+		t.Errorf("ReadSecretFile() of a credential = %q, %v", secret, err)
+	}
+
+	if err := os.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSecretFile(path); err == nil {
+		t.Errorf("expected an error for an empty secret file")
+	}
+	if _, err := ReadSecretFile(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected an error for a missing secret file")
+	}
+}
+
+func TestRunSecretCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("secret command tests use a POSIX shell")
+	}
+
+	if secret, err := RunSecretCommand("printf '  secret\\n\\n'", time.Second); err != nil || secret != "secret" {
+		t.Errorf("RunSecretCommand() = %q, %v", secret, err)
+	}
+
+	_, err := RunSecretCommand("echo 'no such entry' >&2; exit 1", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "no such entry") {
+		t.Errorf("expected the command's error output, got %v", err)
+	}
+
+	if _, err := RunSecretCommand("true", time.Second); err == nil {
+		t.Errorf("expected an error for a command that prints nothing")
+	}
+
+	started := time.Now()
+	if _, err := RunSecretCommand("sleep 5", 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("expected the command to be stopped on timeout, took %s", elapsed)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	var r Redactor
+	if redacted := r.Redact("token abc"); redacted != "token abc" {
+		t.Errorf("expected nothing to be redacted without secrets, got %q", redacted)
+	}
+
+	r.SetSecrets([]string{"abc", "abcdef", ""})
+	if redacted := r.Redact("tokens abc and abcdef"); redacted != "tokens [REDACTED] and [REDACTED]" {
+		t.Errorf("unexpected redaction %q", redacted)
+	}
+
+	var out bytes.Buffer
+	w := r.Writer(&out)
+	if n, err := w.Write([]byte("POST https://hooks.example.com/abcdef failed\n")); err != nil || n != 45 {
+		t.Errorf("Write() = %d, %v", n, err)
+	}
+	if out.String() != "POST https://hooks.example.com/[REDACTED] failed\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
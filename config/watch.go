@@ -0,0 +1,80 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch calls fn after the config file at path changes and no further change
+// has been seen for the debounce period. The directory is watched rather than
+// the file, so that editors saving through a rename and symlinks being swapped
+// (such as a Kubernetes ConfigMap) are noticed. It blocks until the context is
+// cancelled.
+func Watch(ctx context.Context, path string, debounce time.Duration, fn func()) error {
+	configFile, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	realConfigFile, _ := filepath.EvalSymlinks(configFile)
+
+	return watchDir(ctx, filepath.Dir(configFile), debounce, fn, func(event fsnotify.Event) bool {
+		currentConfigFile, _ := filepath.EvalSymlinks(configFile)
+		if filepath.Clean(event.Name) == configFile && !event.Has(fsnotify.Chmod) ||
+			currentConfigFile != "" && currentConfigFile != realConfigFile {
+			realConfigFile = currentConfigFile
+			return true
+		}
+		return false
+	})
+}
+
+// WatchDir is like Watch, but calls fn after any file in the directory is
+// added, changed or removed, such as the files of a conf.d directory.
+func WatchDir(ctx context.Context, dir string, debounce time.Duration, fn func()) error {
+	return watchDir(ctx, dir, debounce, fn, func(event fsnotify.Event) bool {
+		return !event.Has(fsnotify.Chmod)
+	})
+}
+
+// watchDir calls fn once the events in dir that changed reports have settled.
+func watchDir(ctx context.Context, dir string, debounce time.Duration, fn func(), changed func(fsnotify.Event) bool) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if changed(event) {
+				timer.Reset(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-timer.C:
+			fn()
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("a = 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	changes := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, path, 50*time.Millisecond, func() {
+			changes <- struct{}{}
+		})
+	}()
+	// Give the watcher time to start.
+	time.Sleep(100 * time.Millisecond)
+
+	// Other files in the directory are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "other.toml"), []byte("b = 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+		t.Fatal("expected a change to another file to be ignored")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Several writes in quick succession result in a single change.
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(path, []byte("a = 2\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the write to be noticed")
+	}
+	select {
+	case <-changes:
+		t.Fatal("expected the writes to be merged into one change")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Saving through a rename, as many editors do, is noticed too.
+	tmp := filepath.Join(dir, "config.toml.tmp")
+	if err := os.WriteFile(tmp, []byte("a = 3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the rename to be noticed")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch() did not stop")
+	}
+}
+
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	changes := make(chan struct{}, 10)
+	go func() {
+		_ = WatchDir(ctx, dir, 50*time.Millisecond, func() {
+			changes <- struct{}{}
+		})
+	}()
+	// Give the watcher time to start.
+	time.Sleep(100 * time.Millisecond)
+
+	// Adding a file is noticed.
+	if err := os.WriteFile(filepath.Join(dir, "home.toml"), []byte("a = 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the new file to be noticed")
+	}
+}
@@ -2,4 +2,7 @@ package constants
 
 const (
 	MaxTries = 3
+	// DefaultUserAgent is sent to Cloudflare and the IP address services unless
+	// main.user_agent is set.
+	DefaultUserAgent = "cloudflare-dyndns/1.0.0"
 )
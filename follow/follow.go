@@ -0,0 +1,51 @@
+package follow
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"slices"
+	"strings"
+)
+
+// Filter restricts which records follow the address. An empty filter matches
+// every record.
+type Filter struct {
+	Tags          []string
+	CommentMarker string
+}
+
+// Matches reports whether the record carries one of the filter's tags and
+// contains its comment marker.
+func (f Filter) Matches(record cloudflare.DnsRecord) bool {
+	if len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, func(tag string) bool {
+		return slices.Contains(record.Tags, tag)
+	}) {
+		return false
+	}
+
+	if f.CommentMarker != "" && !strings.Contains(record.Comment, f.CommentMarker) {
+		return false
+	}
+
+	return true
+}
+
+// Plan returns a change for every A or AAAA record whose content equals the old
+// address and that matches the filter. Records listed in skip are left out.
+func Plan(records []cloudflare.DnsRecord, oldIp, newIp string, skip []string, filter Filter) []cloudflare.RecordChange {
+	var changes []cloudflare.RecordChange
+	if oldIp == "" || oldIp == newIp {
+		return changes
+	}
+
+	for _, record := range records {
+		if record.Type != "A" && record.Type != "AAAA" {
+			continue
+		}
+		if record.IP != oldIp || slices.Contains(skip, record.Name) || !filter.Matches(record) {
+			continue
+		}
+		changes = append(changes, cloudflare.RecordChange{Record: record, OldIP: record.IP, NewIP: newIp})
+	}
+
+	return changes
+}
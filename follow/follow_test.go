@@ -0,0 +1,45 @@
+package follow
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	records := []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"},
+		{ID: "2", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1", Tags: []string{"ddns"}, Comment: "home [ddns]"},
+		{ID: "3", Name: "cam.example.com", Type: "A", IP: "1.1.1.1", Tags: []string{"other"}},
+		{ID: "4", Name: "web.example.com", Type: "A", IP: "9.9.9.9", Tags: []string{"ddns"}},
+		{ID: "5", Name: "alias.example.com", Type: "CNAME", IP: "1.1.1.1"},
+	}
+
+	tests := []struct {
+		name     string
+		oldIp    string
+		newIp    string
+		filter   Filter
+		expected []string
+	}{
+		{name: "noFilter", oldIp: "1.1.1.1", newIp: "2.2.2.2", expected: []string{"2", "3"}},
+		{name: "tagFilter", oldIp: "1.1.1.1", newIp: "2.2.2.2", filter: Filter{Tags: []string{"ddns"}}, expected: []string{"2"}},
+		{name: "commentFilter", oldIp: "1.1.1.1", newIp: "2.2.2.2", filter: Filter{CommentMarker: "[ddns]"}, expected: []string{"2"}},
+		{name: "bothFilters", oldIp: "1.1.1.1", newIp: "2.2.2.2", filter: Filter{Tags: []string{"other"}, CommentMarker: "[ddns]"}, expected: nil},
+		{name: "unchangedIp", oldIp: "1.1.1.1", newIp: "1.1.1.1", expected: nil},
+		{name: "unknownOldIp", oldIp: "", newIp: "2.2.2.2", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Plan(records, tt.oldIp, tt.newIp, []string{"home.example.com"}, tt.filter)
+			if len(changes) != len(tt.expected) {
+				t.Fatalf("expected %d changes, got %d: %v", len(tt.expected), len(changes), changes)
+			}
+			for i, change := range changes {
+				if change.Record.ID != tt.expected[i] || change.OldIP != tt.oldIp || change.NewIP != tt.newIp {
+					t.Errorf("unexpected change %+v", change)
+				}
+			}
+		})
+	}
+}
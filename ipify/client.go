@@ -7,6 +7,7 @@ import (
 	"errors"
 	"github.com/jpillora/backoff"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -14,31 +15,55 @@ import (
 
 type Client struct {
 	config config.Config
+	client *http.Client
 }
 
 func New(config *config.Config) *Client {
 	return &Client{
 		config: *config,
+		// No global timeout since we set per-request timeouts via context.
+		client: &http.Client{},
 	}
 }
 
 func (ip *Client) GetPublicIP() (string, error) {
 	result, err := ip.makeRequest(ip.config.IpifyURL)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return result, nil
 }
 
+// GetPublicIPFrom asks another service returning the address as plain text,
+// such as https://api4.ipify.org for the IPv4 address only.
+func (ip *Client) GetPublicIPFrom(url string) (string, error) {
+	return ip.makeRequest(url)
+}
+
+// GetPublicIPOver asks the configured service for the address of one family, by
+// only connecting to it over network, which is "tcp4" or "tcp6".
+func (ip *Client) GetPublicIPOver(network string) (string, error) {
+	dialer := &net.Dialer{Timeout: constants.MaxTries * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	family := &Client{config: ip.config, client: &http.Client{Transport: transport}}
+
+	return family.GetPublicIP()
+}
+
 func (ip *Client) makeRequest(url string) (string, error) {
 	b := &backoff.Backoff{
 		Jitter: true,
 	}
 
-	// Create an HTTP client without a global timeout since we'll set
-	// per-request timeouts via context.
-	client := &http.Client{}
+	// Reuse the client's connections between requests where possible.
+	client := ip.client
+	if client == nil {
+		client = &http.Client{}
+	}
 
 	for tries := 0; tries < constants.MaxTries; tries++ {
 		// Create a context with a timeout for each request attempt.
@@ -76,3 +76,19 @@ func TestGetPublicIP(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPublicIPOver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("123.123.123.123"))
+	}))
+	defer server.Close()
+
+	client := New(&config.Config{IpifyURL: server.URL, UserAgent: "TestAgent"})
+	if ip, err := client.GetPublicIPOver("tcp4"); err != nil || ip != "123.123.123.123" {
+		t.Errorf("GetPublicIPOver(tcp4) = %q, %v", ip, err)
+	}
+	// The test server only listens on IPv4.
+	if ip, err := client.GetPublicIPOver("tcp6"); err == nil {
+		t.Errorf("expected an error over IPv6, got %q", ip)
+	}
+}
@@ -0,0 +1,144 @@
+// Package lock provides an advisory lock file, so that only one process at a
+// time updates the records of a config file.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// errWouldBlock is returned by the platform lock functions when the lock is held.
+var errWouldBlock = errors.New("lock is held")
+
+// Holder describes the process holding a lock, as recorded in the lock file.
+type Holder struct {
+	PID   int
+	Since time.Time
+}
+
+// LockedError is returned when another process holds the lock.
+type LockedError struct {
+	Path   string
+	Holder Holder
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder.PID == 0 {
+		return fmt.Sprintf("%s is %s", e.Path, ErrLocked)
+	}
+
+	return fmt.Sprintf("%s is %s (pid %d, since %s)", e.Path, ErrLocked, e.Holder.PID, e.Holder.Since.Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Stale reports whether the lock has been held for longer than maxAge, which
+// suggests the holder is hung.
+func (e *LockedError) Stale(maxAge time.Duration) bool {
+	return !e.Holder.Since.IsZero() && time.Since(e.Holder.Since) > maxAge
+}
+
+// Lock is a held lock file.
+type Lock struct {
+	file *os.File
+}
+
+// TryAcquire takes the lock at path without waiting. A *LockedError is returned
+// when another process holds it.
+func TryAcquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, &LockedError{Path: path, Holder: readHolder(path)}
+		}
+		return nil, err
+	}
+
+	// Record who holds the lock, for the processes that find it held.
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339))), 0)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Acquire takes the lock at path, waiting until it is released or the context
+// is cancelled. onWait, when set, is called once if the lock is held.
+func Acquire(ctx context.Context, path string, onWait func(*LockedError)) (*Lock, error) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	waiting := false
+	for {
+		l, err := TryAcquire(path)
+		var lockedErr *LockedError
+		if !errors.As(err, &lockedErr) {
+			return l, err
+		}
+		if !waiting && onWait != nil {
+			onWait(lockedErr)
+		}
+		waiting = true
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Release releases the lock. The lock file is left in place, since removing it
+// could let two processes lock different files of the same name.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	_ = l.file.Truncate(0)
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+
+	return err
+}
+
+// readHolder reads the process recorded in the lock file at path.
+func readHolder(path string) Holder {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Holder{}
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		return Holder{}
+	}
+
+	pid, _ := strconv.Atoi(lines[0])
+	since, _ := time.Parse(time.RFC3339, lines[1])
+
+	return Holder{PID: pid, Since: since}
+}
@@ -0,0 +1,18 @@
+//go:build !unix && !windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile is only supported on Unix and Windows.
+func lockFile(file *os.File) error {
+	return errors.ErrUnsupported
+}
+
+// unlockFile is only supported on Unix and Windows.
+func unlockFile(file *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,102 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTryAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "example.lock")
+
+	first, err := TryAcquire(path)
+	if err != nil {
+		t.Fatalf("TryAcquire() error = %v", err)
+	}
+
+	_, err = TryAcquire(path)
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected the lock to be held, got %v", err)
+	}
+	if lockedErr.Holder.PID != os.Getpid() {
+		t.Errorf("expected the holder to be pid %d, got %d", os.Getpid(), lockedErr.Holder.PID)
+	}
+	if time.Since(lockedErr.Holder.Since) > time.Minute {
+		t.Errorf("unexpected lock time %s", lockedErr.Holder.Since)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	second, err := TryAcquire(path)
+	if err != nil {
+		t.Fatalf("TryAcquire() after Release() error = %v", err)
+	}
+	if err := second.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+}
+
+func TestAcquireWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.lock")
+
+	held, err := TryAcquire(path)
+	if err != nil {
+		t.Fatalf("TryAcquire() error = %v", err)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		<-waited
+		_ = held.Release()
+	}()
+
+	l, err := Acquire(context.Background(), path, func(*LockedError) {
+		close(waited)
+	})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	_ = l.Release()
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.lock")
+
+	held, err := TryAcquire(path)
+	if err != nil {
+		t.Fatalf("TryAcquire() error = %v", err)
+	}
+	defer held.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Acquire(ctx, path, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to be cancelled, got %v", err)
+	}
+}
+
+func TestLockedErrorStale(t *testing.T) {
+	tests := []struct {
+		name     string
+		holder   Holder
+		expected bool
+	}{
+		{name: "recent", holder: Holder{PID: 1, Since: time.Now().Add(-time.Minute)}, expected: false},
+		{name: "old", holder: Holder{PID: 1, Since: time.Now().Add(-time.Hour)}, expected: true},
+		{name: "unknown", holder: Holder{}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lockedErr := &LockedError{Path: "example.lock", Holder: tt.holder}
+			if got := lockedErr.Stale(15 * time.Minute); got != tt.expected {
+				t.Errorf("Stale() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
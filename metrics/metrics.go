@@ -0,0 +1,258 @@
+// Package metrics keeps track of update runs and exposes them in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/updater"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const namespace = "cloudflare_dyndns"
+
+// latencyBuckets are the upper bounds, in seconds, of the Cloudflare API latency histogram.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]float64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Metrics collects what happened during update runs. It implements
+// updater.Observer and is safe for concurrent use.
+type Metrics struct {
+	mu sync.Mutex
+	// profile is added as a label to every sample of the metrics of a Set.
+	profile string
+
+	startTime           time.Time
+	detectionAttempts   map[string]float64
+	detectionFailures   map[string]float64
+	currentIP           map[string]string
+	apiLatency          map[string]*histogram
+	apiErrors           map[string]float64
+	runs                map[string]float64
+	lastRun             time.Time
+	lastSuccess         time.Time
+	failingSince        time.Time
+	recordsOutOfSync    float64
+	recordsUpdatedTotal float64
+	lastRunSuccess      float64
+	lastRunDuration     float64
+	lastRunChanged      float64
+}
+
+// New returns an empty set of metrics.
+func New() *Metrics {
+	return &Metrics{
+		startTime:         time.Now(),
+		detectionAttempts: map[string]float64{},
+		detectionFailures: map[string]float64{},
+		currentIP:         map[string]string{},
+		apiLatency:        map[string]*histogram{},
+		apiErrors:         map[string]float64{},
+		runs:              map[string]float64{},
+	}
+}
+
+// Detected counts an attempt to detect the public IP address.
+func (m *Metrics) Detected(provider string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.detectionAttempts[provider]++
+	if err != nil {
+		m.detectionFailures[provider]++
+	}
+}
+
+// CloudflareRequest records the latency and any error codes of a Cloudflare API request.
+func (m *Metrics) CloudflareRequest(op string, duration time.Duration, dnsErrors []cloudflare.ResponseErrors, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.apiLatency[op]
+	if !ok {
+		h = &histogram{}
+		m.apiLatency[op] = h
+	}
+	h.observe(duration.Seconds())
+
+	for _, dnsError := range dnsErrors {
+		m.apiErrors[strconv.Itoa(dnsError.Code)]++
+	}
+	if err != nil && len(dnsErrors) == 0 {
+		// The request failed before Cloudflare could answer with an error code.
+		m.apiErrors["none"]++
+	}
+}
+
+// Finished records the outcome of a run.
+func (m *Metrics) Finished(result *updater.Result, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.lastRun = now
+	m.lastRunSuccess = map[bool]float64{true: 1, false: 0}[err == nil]
+
+	if result != nil && result.IP != "" {
+		m.currentIP[map[bool]string{true: "ipv4", false: "ipv6"}[result.IsIPv4]] = result.IP
+	}
+
+	switch {
+	case err != nil:
+		m.runs["failure"]++
+		if m.failingSince.IsZero() {
+			m.failingSince = now
+		}
+	case result != nil && result.Skipped:
+		m.runs["skipped"]++
+		m.failingSince = time.Time{}
+	default:
+		m.runs["success"]++
+		m.lastSuccess = now
+		m.failingSince = time.Time{}
+	}
+
+	if result != nil {
+		outOfSync := len(result.Missing)
+		changed := 0
+		for _, record := range result.Records {
+			switch record.Action {
+			case updater.ActionFailed:
+				outOfSync++
+			case updater.ActionUpdated:
+				changed++
+			}
+		}
+		m.recordsOutOfSync = float64(outOfSync)
+		m.recordsUpdatedTotal += float64(changed)
+		m.lastRunChanged = float64(changed)
+		m.lastRunDuration = result.Duration.Seconds()
+	}
+}
+
+// SetLastSuccess sets when the last successful run finished, unless a later
+// successful run has already been recorded.
+func (m *Metrics) SetLastSuccess(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t.After(m.lastSuccess) {
+		m.lastSuccess = t
+	}
+}
+
+func (m *Metrics) keepLastSuccess(path string) {
+	if last, ok := readLastSuccess(path, m.profile); ok {
+		m.SetLastSuccess(last)
+	}
+}
+
+// Healthy reports whether updates have not been failing for longer than maxFailing.
+func (m *Metrics) Healthy(maxFailing time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.failingSince.IsZero() || time.Since(m.failingSince) < maxFailing
+}
+
+// Ready reports whether at least one run has completed without an error.
+func (m *Metrics) Ready() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.runs["success"] > 0 || m.runs["skipped"] > 0
+}
+
+// Write writes every metric in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) error {
+	for _, f := range m.families() {
+		if err := f.writeTo(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Metrics) families() []family {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counterByLabel := func(name, help, labelName string, values map[string]float64) family {
+		f := family{name: namespace + "_" + name, help: help, kind: "counter"}
+		for _, k := range sortedKeys(values) {
+			f.samples = append(f.samples, sample{labels: []label{{labelName, k}}, value: values[k]})
+		}
+		return f
+	}
+	timestamp := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+
+	ipInfo := family{name: namespace + "_ip_info", help: "The public IP address currently detected, per address family.", kind: "gauge"}
+	for _, k := range sortedKeys(m.currentIP) {
+		ipInfo.samples = append(ipInfo.samples, sample{labels: []label{{"family", k}, {"address", m.currentIP[k]}}, value: 1})
+	}
+
+	latency := family{name: namespace + "_cloudflare_request_duration_seconds", help: "Latency of requests to the Cloudflare API.", kind: "histogram"}
+	for _, op := range sortedKeys(m.apiLatency) {
+		h := m.apiLatency[op]
+		for i, bound := range latencyBuckets {
+			latency.samples = append(latency.samples, sample{suffix: "_bucket", labels: []label{{"op", op}, {"le", formatValue(bound)}}, value: h.counts[i]})
+		}
+		latency.samples = append(latency.samples,
+			sample{suffix: "_bucket", labels: []label{{"op", op}, {"le", "+Inf"}}, value: h.count},
+			sample{suffix: "_sum", labels: []label{{"op", op}}, value: h.sum},
+			sample{suffix: "_count", labels: []label{{"op", op}}, value: h.count},
+		)
+	}
+
+	families := []family{
+		counterByLabel("detection_attempts_total", "Attempts to detect the public IP address, per provider.", "provider", m.detectionAttempts),
+		counterByLabel("detection_failures_total", "Failed attempts to detect the public IP address, per provider.", "provider", m.detectionFailures),
+		ipInfo,
+		latency,
+		counterByLabel("cloudflare_errors_total", "Errors returned by the Cloudflare API, per error code.", "code", m.apiErrors),
+		counterByLabel("runs_total", "Update runs, per result.", "result", m.runs),
+		{name: namespace + "_records_updated_total", help: "DNS records updated.", kind: "counter", samples: []sample{{value: m.recordsUpdatedTotal}}},
+		{name: namespace + "_records_out_of_sync", help: "DNS records that could not be updated or found during the last run.", kind: "gauge", samples: []sample{{value: m.recordsOutOfSync}}},
+		{name: namespace + "_last_run_success", help: "Whether the last update run succeeded.", kind: "gauge", samples: []sample{{value: m.lastRunSuccess}}},
+		{name: namespace + "_last_run_duration_seconds", help: "How long the last update run took.", kind: "gauge", samples: []sample{{value: m.lastRunDuration}}},
+		{name: namespace + "_last_run_records_changed", help: "DNS records updated during the last run.", kind: "gauge", samples: []sample{{value: m.lastRunChanged}}},
+		{name: namespace + "_last_run_timestamp_seconds", help: "When the last update run finished.", kind: "gauge", samples: []sample{{value: timestamp(m.lastRun)}}},
+		{name: namespace + "_last_success_timestamp_seconds", help: "When the last successful update run finished.", kind: "gauge", samples: []sample{{value: timestamp(m.lastSuccess)}}},
+		{name: namespace + "_start_time_seconds", help: "When the process started.", kind: "gauge", samples: []sample{{value: timestamp(m.startTime)}}},
+	}
+	if m.profile != "" {
+		for _, f := range families {
+			for i := range f.samples {
+				f.samples[i].labels = append([]label{{"profile", m.profile}}, f.samples[i].labels...)
+			}
+		}
+	}
+
+	return families
+}
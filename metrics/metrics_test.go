@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/updater"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Write(t *testing.T) {
+	m := New()
+	m.Detected(updater.ProviderIpify, nil)
+	m.Detected(updater.ProviderIpify, errors.New("timeout"))
+	m.CloudflareRequest("list", 150*time.Millisecond, nil, nil)
+	m.CloudflareRequest("update", 2*time.Second, []cloudflare.ResponseErrors{{Code: 1004, Message: "DNS Validation Error"}}, errors.New(""))
+	m.Finished(&updater.Result{
+		IP: "2001:db8::1",
+		Records: []updater.RecordResult{
+			{Name: "home.example.com", Action: updater.ActionUpdated},
+			{Name: "vpn.example.com", Action: updater.ActionFailed},
+		},
+		Missing: []string{"gone.example.com"},
+	}, errors.New("failed"))
+
+	var out strings.Builder
+	if err := m.Write(&out); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"# TYPE cloudflare_dyndns_detection_attempts_total counter\n",
+		`cloudflare_dyndns_detection_attempts_total{provider="ipify"} 2` + "\n",
+		`cloudflare_dyndns_detection_failures_total{provider="ipify"} 1` + "\n",
+		`cloudflare_dyndns_ip_info{family="ipv6",address="2001:db8::1"} 1` + "\n",
+		`cloudflare_dyndns_cloudflare_request_duration_seconds_bucket{op="list",le="0.25"} 1` + "\n",
+		`cloudflare_dyndns_cloudflare_request_duration_seconds_bucket{op="list",le="0.1"} 0` + "\n",
+		`cloudflare_dyndns_cloudflare_request_duration_seconds_bucket{op="update",le="+Inf"} 1` + "\n",
+		`cloudflare_dyndns_cloudflare_request_duration_seconds_count{op="update"} 1` + "\n",
+		`cloudflare_dyndns_cloudflare_errors_total{code="1004"} 1` + "\n",
+		`cloudflare_dyndns_runs_total{result="failure"} 1` + "\n",
+		"cloudflare_dyndns_records_updated_total 1\n",
+		"cloudflare_dyndns_records_out_of_sync 2\n",
+		"cloudflare_dyndns_last_success_timestamp_seconds 0\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	handler := Handler(m, time.Hour)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to fail before the first run, got %d", code)
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("expected /healthz to pass before the first run, got %d", code)
+	}
+
+	m.Finished(&updater.Result{IP: "1.1.1.1", IsIPv4: true}, nil)
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("expected /readyz to pass after a successful run, got %d", code)
+	}
+	if code := get("/metrics"); code != http.StatusOK {
+		t.Errorf("expected /metrics to be served, got %d", code)
+	}
+
+	// Failing for longer than allowed makes the health probe fail.
+	m.Finished(&updater.Result{}, errors.New("failed"))
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("expected /healthz to pass right after a failure, got %d", code)
+	}
+	rec := httptest.NewRecorder()
+	Handler(m, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /healthz to fail once failing for too long, got %d", rec.Code)
+	}
+
+	// A successful run makes it healthy again.
+	m.Finished(&updater.Result{IP: "1.1.1.1", IsIPv4: true}, nil)
+	if !m.Healthy(0) {
+		t.Errorf("expected a successful run to make the updater healthy")
+	}
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Collector is a source of metrics: the Metrics of a single configuration, or
+// the Set of several profiles run together.
+type Collector interface {
+	// Write writes every metric in the Prometheus text exposition format.
+	Write(w io.Writer) error
+	// Healthy reports whether updates have not been failing for longer than maxFailing.
+	Healthy(maxFailing time.Duration) bool
+	// Ready reports whether updates have completed without an error.
+	Ready() bool
+
+	// keepLastSuccess carries over the last success timestamps of an earlier
+	// textfile at path.
+	keepLastSuccess(path string)
+}
+
+// Set holds the metrics of several profiles run together, so that one textfile
+// or endpoint can expose all of them. Every sample has a profile label.
+type Set struct {
+	mu       sync.Mutex
+	names    []string
+	profiles map[string]*Metrics
+}
+
+// NewSet returns an empty set of metrics.
+func NewSet() *Set {
+	return &Set{profiles: map[string]*Metrics{}}
+}
+
+// Profile returns the metrics of a profile, which observe its update runs.
+func (s *Set) Profile(name string) *Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.profiles[name]
+	if !ok {
+		m = New()
+		m.profile = name
+		s.profiles[name] = m
+		s.names = append(s.names, name)
+	}
+
+	return m
+}
+
+// all returns the metrics of every profile, in the order they were added.
+func (s *Set) all() []*Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make([]*Metrics, 0, len(s.names))
+	for _, name := range s.names {
+		all = append(all, s.profiles[name])
+	}
+
+	return all
+}
+
+// Write writes the metrics of every profile, with the samples of each metric
+// kept under a single HELP and TYPE.
+func (s *Set) Write(w io.Writer) error {
+	var merged []family
+	for _, m := range s.all() {
+		families := m.families()
+		if merged == nil {
+			merged = families
+			continue
+		}
+		for i := range families {
+			merged[i].samples = append(merged[i].samples, families[i].samples...)
+		}
+	}
+	for _, f := range merged {
+		if err := f.writeTo(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Healthy reports whether the updates of no profile have been failing for
+// longer than maxFailing.
+func (s *Set) Healthy(maxFailing time.Duration) bool {
+	for _, m := range s.all() {
+		if !m.Healthy(maxFailing) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Ready reports whether every profile has completed a run without an error.
+func (s *Set) Ready() bool {
+	all := s.all()
+	for _, m := range all {
+		if !m.Ready() {
+			return false
+		}
+	}
+
+	return len(all) > 0
+}
+
+func (s *Set) keepLastSuccess(path string) {
+	for _, m := range s.all() {
+		m.keepLastSuccess(path)
+	}
+}
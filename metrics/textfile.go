@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteTextfile atomically writes the metrics to path, for the node_exporter
+// textfile collector. The last success timestamp of an earlier file at path is
+// carried over, so that a failed run does not hide how long updates have been
+// failing.
+func WriteTextfile(path string, c Collector) error {
+	c.keepLastSuccess(path)
+
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		return err
+	}
+
+	// Write to a temporary file in the same directory so the collector never
+	// reads a partially written file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// readLastSuccess reads the last success timestamp of a profile from an earlier
+// textfile, or the timestamp without a profile label when profile is empty.
+func readLastSuccess(path, profile string) (time.Time, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	prefix := namespace + "_last_success_timestamp_seconds "
+	if profile != "" {
+		prefix = namespace + "_last_success_timestamp_seconds" + formatLabels([]label{{"profile", profile}}) + " "
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		seconds, err := strconv.ParseFloat(strings.TrimPrefix(line, prefix), 64)
+		if err != nil || seconds <= 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(seconds*1e9)), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"cloudflare-dyndns/updater"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudflare_dyndns.prom")
+
+	// A successful run records when it happened.
+	m := New()
+	m.Finished(&updater.Result{
+		IP:       "1.1.1.1",
+		IsIPv4:   true,
+		Duration: 1500 * time.Millisecond,
+		Records:  []updater.RecordResult{{Name: "home.example.com", Action: updater.ActionUpdated}},
+	}, nil)
+	if err := WriteTextfile(path, m); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"cloudflare_dyndns_last_run_success 1\n",
+		"cloudflare_dyndns_last_run_duration_seconds 1.5\n",
+		"cloudflare_dyndns_last_run_records_changed 1\n",
+		`cloudflare_dyndns_ip_info{family="ipv4",address="1.1.1.1"} 1` + "\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected textfile to contain %q, got:\n%s", want, data)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("expected a world readable textfile, got %v, %v", info, err)
+	}
+	firstSuccess, ok := readLastSuccess(path, "")
+	if !ok {
+		t.Fatalf("expected the textfile to hold the last success timestamp")
+	}
+
+	// A failed run in a new process keeps the last success timestamp.
+	m = New()
+	m.Finished(&updater.Result{}, errors.New("failed"))
+	if err := WriteTextfile(path, m); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "cloudflare_dyndns_last_run_success 0\n") {
+		t.Errorf("expected the failed run to be recorded, got:\n%s", data)
+	}
+	if lastSuccess, ok := readLastSuccess(path, ""); !ok || lastSuccess.Sub(firstSuccess).Abs() > time.Millisecond {
+		t.Errorf("expected the last success timestamp %v to be kept, got %v", firstSuccess, lastSuccess)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the textfile to remain, got %d entries", len(entries))
+	}
+}
+
+func TestWriteTextfileSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudflare_dyndns.prom")
+
+	set := NewSet()
+	set.Profile("home").Finished(&updater.Result{IP: "1.1.1.1", IsIPv4: true}, nil)
+	set.Profile("office").Finished(&updater.Result{IP: "2.2.2.2", IsIPv4: true}, errors.New("failed"))
+	if err := WriteTextfile(path, set); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`cloudflare_dyndns_last_run_success{profile="home"} 1` + "\n",
+		`cloudflare_dyndns_last_run_success{profile="office"} 0` + "\n",
+		`cloudflare_dyndns_ip_info{profile="office",family="ipv4",address="2.2.2.2"} 1` + "\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected textfile to contain %q, got:\n%s", want, data)
+		}
+	}
+	if count := strings.Count(string(data), "# TYPE cloudflare_dyndns_last_run_success "); count != 1 {
+		t.Errorf("expected each metric to be declared once, got %d declarations", count)
+	}
+	homeSuccess, ok := readLastSuccess(path, "home")
+	if !ok {
+		t.Fatalf("expected the textfile to hold the last success timestamp of the profile")
+	}
+	if !set.Healthy(time.Hour) || set.Ready() {
+		t.Errorf("expected the set to be healthy but not ready while a profile has not succeeded")
+	}
+
+	// Each profile keeps its own last success timestamp in a new process.
+	set = NewSet()
+	set.Profile("home").Finished(&updater.Result{}, errors.New("failed"))
+	set.Profile("office").Finished(&updater.Result{}, errors.New("failed"))
+	if err := WriteTextfile(path, set); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+	if lastSuccess, ok := readLastSuccess(path, "home"); !ok || lastSuccess.Sub(homeSuccess).Abs() > time.Millisecond {
+		t.Errorf("expected the last success timestamp %v of home to be kept, got %v", homeSuccess, lastSuccess)
+	}
+	if _, ok := readLastSuccess(path, "office"); ok {
+		t.Errorf("expected office to have no last success timestamp")
+	}
+}
@@ -0,0 +1,283 @@
+// Package mqtt publishes the current public IP addresses and the status of
+// updates to an MQTT broker, with Home Assistant discovery so that they show up
+// as sensors.
+package mqtt
+
+import (
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/updater"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog"
+)
+
+// The values published to the status topic.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+	StatusAway   = "away"
+)
+
+// invalidNodeID matches the characters Home Assistant does not allow in node IDs.
+var invalidNodeID = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// Publisher publishes the results of update runs to an MQTT broker.
+type Publisher struct {
+	cfg     config.MQTT
+	logger  zerolog.Logger
+	version string
+	client  paho.Client
+	topics  topics
+}
+
+// topics are the state topics results are published to.
+type topics struct {
+	ipv4       string
+	ipv6       string
+	lastUpdate string
+	status     string
+}
+
+// defaultNodeID returns the node ID used when none is configured, which is
+// unique to the machine.
+func defaultNodeID() string {
+	hostname, _ := os.Hostname()
+	return "cloudflare_dyndns_" + hostname
+}
+
+// WithProfile returns the settings for the publisher of one of several profiles
+// run together. The node and client IDs end with the profile name, so that the
+// profiles don't take over each other's connection to the broker or Home
+// Assistant device.
+func WithProfile(cfg config.MQTT, profile string) config.MQTT {
+	if cfg.NodeID == "" {
+		cfg.NodeID = defaultNodeID()
+	}
+	cfg.NodeID += "_" + profile
+	if cfg.ClientID != "" {
+		cfg.ClientID += "-" + profile
+	}
+
+	return cfg
+}
+
+// New returns a Publisher for the MQTT settings in the configuration. The
+// version is shown on the Home Assistant device.
+func New(cfg config.MQTT, version string, logger zerolog.Logger) (*Publisher, error) {
+	if cfg.Broker == "" {
+		return nil, &config.KeyError{Key: "mqtt.broker", Err: errors.New("must be set")}
+	}
+	if cfg.QoS < 0 || cfg.QoS > 2 {
+		return nil, &config.KeyError{Key: "mqtt.qos", Err: errors.New("must be 0, 1 or 2")}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	if cfg.NodeID == "" {
+		cfg.NodeID = defaultNodeID()
+	}
+	cfg.NodeID = strings.Trim(invalidNodeID.ReplaceAllString(cfg.NodeID, "_"), "_")
+	if cfg.ClientID == "" {
+		cfg.ClientID = strings.ReplaceAll(cfg.NodeID, "_", "-")
+	}
+
+	prefix := strings.TrimSuffix(cfg.TopicPrefix, "/") + "/" + cfg.NodeID
+	p := &Publisher{
+		cfg:     cfg,
+		logger:  logger,
+		version: version,
+		topics: topics{
+			ipv4:       orDefault(cfg.IPv4Topic, prefix+"/ipv4"),
+			ipv6:       orDefault(cfg.IPv6Topic, prefix+"/ipv6"),
+			lastUpdate: orDefault(cfg.LastUpdateTopic, prefix+"/last_update"),
+			status:     orDefault(cfg.StatusTopic, prefix+"/status"),
+		},
+	}
+
+	tlsConfig, err := p.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(cfg.Timeout).
+		SetWriteTimeout(cfg.Timeout).
+		SetAutoReconnect(true).
+		SetTLSConfig(tlsConfig)
+	p.client = paho.NewClient(opts)
+
+	return p, nil
+}
+
+func orDefault(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// tlsConfig returns the TLS configuration for brokers using ssl://, tls:// or mqtts://.
+func (p *Publisher) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if p.cfg.CAFile != "" {
+		ca, err := os.ReadFile(p.cfg.CAFile)
+		if err != nil {
+			return nil, &config.KeyError{Key: "mqtt.ca_file", Err: err}
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, &config.KeyError{Key: "mqtt.ca_file", Err: fmt.Errorf("no certificates found in %s", p.cfg.CAFile)}
+		}
+	}
+
+	if p.cfg.CertFile != "" || p.cfg.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(p.cfg.CertFile, p.cfg.KeyFile)
+		if err != nil {
+			return nil, &config.KeyError{Key: "mqtt.cert_file", Err: err}
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// Connect connects to the broker and publishes the Home Assistant discovery
+// messages, if enabled. The connection is kept open and re-established if lost.
+func (p *Publisher) Connect() error {
+	if err := wait(p.client.Connect(), p.cfg.Timeout); err != nil {
+		return fmt.Errorf("connecting to %s: %w", p.cfg.Broker, err)
+	}
+	p.logger.Info().Msg(fmt.Sprintf("connected to MQTT broker %s", p.cfg.Broker))
+
+	if p.cfg.Discovery {
+		return p.publishDiscovery()
+	}
+
+	return nil
+}
+
+// Close disconnects from the broker.
+func (p *Publisher) Close() {
+	p.client.Disconnect(uint(p.cfg.Timeout.Milliseconds()))
+}
+
+// Publish sends the result of an update run to the state topics, connecting to
+// the broker first if needed.
+func (p *Publisher) Publish(result *updater.Result, err error) error {
+	if !p.client.IsConnected() {
+		if connectErr := p.Connect(); connectErr != nil {
+			return connectErr
+		}
+	}
+
+	var errs []error
+	switch {
+	case result.Skipped:
+		errs = append(errs, p.publish(p.topics.status, StatusAway))
+	case err != nil:
+		errs = append(errs, p.publish(p.topics.status, StatusFailed))
+	default:
+		ipTopic := p.topics.ipv6
+		if result.IsIPv4 {
+			ipTopic = p.topics.ipv4
+		}
+		errs = append(errs,
+			p.publish(ipTopic, result.IP),
+			p.publish(p.topics.lastUpdate, time.Now().UTC().Format(time.RFC3339)),
+			p.publish(p.topics.status, StatusOK),
+		)
+	}
+
+	return errors.Join(errs...)
+}
+
+// sensor is a Home Assistant MQTT discovery message for a sensor.
+type sensor struct {
+	Name        string `json:"name"`
+	UniqueID    string `json:"unique_id"`
+	ObjectID    string `json:"object_id"`
+	StateTopic  string `json:"state_topic"`
+	Icon        string `json:"icon,omitempty"`
+	DeviceClass string `json:"device_class,omitempty"`
+	Device      device `json:"device"`
+}
+
+type device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// publishDiscovery announces a sensor for each state topic to Home Assistant.
+func (p *Publisher) publishDiscovery() error {
+	hostname, _ := os.Hostname()
+	d := device{
+		Identifiers:  []string{p.cfg.NodeID},
+		Name:         "Cloudflare DynDNS " + hostname,
+		Manufacturer: "cloudflare-dyndns",
+		Model:        "cloudflare-dyndns",
+		SWVersion:    p.version,
+	}
+
+	sensors := map[string]sensor{
+		"ipv4":        {Name: "Public IPv4 address", StateTopic: p.topics.ipv4, Icon: "mdi:ip-network"},
+		"ipv6":        {Name: "Public IPv6 address", StateTopic: p.topics.ipv6, Icon: "mdi:ip-network"},
+		"last_update": {Name: "Last update", StateTopic: p.topics.lastUpdate, DeviceClass: "timestamp"},
+		"status":      {Name: "Update status", StateTopic: p.topics.status, Icon: "mdi:dns"},
+	}
+
+	var errs []error
+	for object, s := range sensors {
+		s.UniqueID = p.cfg.NodeID + "_" + object
+		s.ObjectID = s.UniqueID
+		s.Device = d
+		payload, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		topic := fmt.Sprintf("%s/sensor/%s/%s/config", p.cfg.DiscoveryPrefix, p.cfg.NodeID, object)
+		// Discovery messages are always retained, so Home Assistant finds them after a restart.
+		errs = append(errs, p.publishRetained(topic, string(payload), true))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Publisher) publish(topic, payload string) error {
+	return p.publishRetained(topic, payload, p.cfg.Retain)
+}
+
+func (p *Publisher) publishRetained(topic, payload string, retain bool) error {
+	if err := wait(p.client.Publish(topic, byte(p.cfg.QoS), retain, payload), p.cfg.Timeout); err != nil {
+		return fmt.Errorf("publishing to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+// wait waits for an MQTT operation to complete.
+func wait(token paho.Token, timeout time.Duration) error {
+	if !token.WaitTimeout(timeout) {
+		return errors.New("timed out")
+	}
+
+	return token.Error()
+}
@@ -0,0 +1,329 @@
+package mqtt
+
+import (
+	"bufio"
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/updater"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// message is a message received by the test broker.
+type message struct {
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// broker is a minimal embedded MQTT 3.1.1 broker that accepts every client and
+// remembers the last message published to each topic.
+type broker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	username string
+	password string
+	messages map[string]message
+}
+
+func newBroker(t *testing.T, tlsConfig *tls.Config) *broker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	b := &broker{listener: listener, messages: map[string]message{}}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *broker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			b.connect(body)
+			_, _ = conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			qos := (header >> 1) & 0x03
+			topicLength := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+topicLength])
+			rest := body[2+topicLength:]
+			if qos > 0 {
+				_, _ = conn.Write([]byte{0x40, 0x02, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			b.mu.Lock()
+			b.messages[topic] = message{payload: string(rest), qos: qos, retain: header&0x01 == 1}
+			b.mu.Unlock()
+		case 12: // PINGREQ
+			_, _ = conn.Write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// connect remembers the credentials in a CONNECT packet.
+func (b *broker) connect(body []byte) {
+	readString := func() string {
+		length := int(binary.BigEndian.Uint16(body))
+		value := string(body[2 : 2+length])
+		body = body[2+length:]
+		return value
+	}
+
+	readString() // protocol name
+	flags := body[1]
+	body = body[4:] // level, flags and keep alive
+	readString()    // client ID
+	if flags&0x04 != 0 {
+		readString() // will topic
+		readString() // will message
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if flags&0x80 != 0 {
+		b.username = readString()
+	}
+	if flags&0x40 != 0 {
+		b.password = readString()
+	}
+}
+
+func (b *broker) message(topic string) (message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, ok := b.messages[topic]
+	return m, ok
+}
+
+func testConfig(broker string) config.MQTT {
+	return config.MQTT{
+		Broker:          broker,
+		QoS:             1,
+		Retain:          true,
+		Timeout:         5 * time.Second,
+		TopicPrefix:     "cloudflare-dyndns",
+		Discovery:       true,
+		DiscoveryPrefix: "homeassistant",
+		NodeID:          "test node",
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	b := newBroker(t, nil)
+
+	cfg := testConfig("tcp://" + b.addr())
+	cfg.Username = "user"
+	cfg.Password = "pass"
+	cfg.StatusTopic = "home/dyndns/status"
+	p, err := New(cfg, "1.2.3", zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer p.Close()
+
+	b.mu.Lock()
+	if b.username != "user" || b.password != "pass" {
+		t.Errorf("unexpected credentials %q, %q", b.username, b.password)
+	}
+	b.mu.Unlock()
+
+	// Home Assistant discovery.
+	discovery, ok := b.message("homeassistant/sensor/test_node/ipv4/config")
+	if !ok || !discovery.retain {
+		t.Fatalf("expected a retained discovery message, got %+v", discovery)
+	}
+	var s sensor
+	if err := json.Unmarshal([]byte(discovery.payload), &s); err != nil {
+		t.Fatalf("unable to decode the discovery message %s: %v", discovery.payload, err)
+	}
+	if s.StateTopic != "cloudflare-dyndns/test_node/ipv4" || s.UniqueID != "test_node_ipv4" || s.Device.SWVersion != "1.2.3" {
+		t.Errorf("unexpected sensor %+v", s)
+	}
+	if status, _ := b.message("homeassistant/sensor/test_node/status/config"); !strings.Contains(status.payload, `"state_topic":"home/dyndns/status"`) {
+		t.Errorf("expected the status sensor to use the configured topic, got %s", status.payload)
+	}
+
+	// A successful run.
+	if err := p.Publish(&updater.Result{IP: "2.2.2.2", IsIPv4: true}, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if ip, _ := b.message("cloudflare-dyndns/test_node/ipv4"); ip.payload != "2.2.2.2" || !ip.retain || ip.qos != 1 {
+		t.Errorf("unexpected ipv4 message %+v", ip)
+	}
+	if status, _ := b.message("home/dyndns/status"); status.payload != StatusOK {
+		t.Errorf("unexpected status %+v", status)
+	}
+	lastUpdate, _ := b.message("cloudflare-dyndns/test_node/last_update")
+	if _, err := time.Parse(time.RFC3339, lastUpdate.payload); err != nil {
+		t.Errorf("unexpected last update %q", lastUpdate.payload)
+	}
+
+	// A failed run leaves the address alone.
+	if err := p.Publish(&updater.Result{IP: "3.3.3.3", IsIPv4: true}, errors.New("failed")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if status, _ := b.message("home/dyndns/status"); status.payload != StatusFailed {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if ip, _ := b.message("cloudflare-dyndns/test_node/ipv4"); ip.payload != "2.2.2.2" {
+		t.Errorf("expected the address to be unchanged, got %+v", ip)
+	}
+
+	// A skipped run.
+	if err := p.Publish(&updater.Result{Skipped: true}, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if status, _ := b.message("home/dyndns/status"); status.payload != StatusAway {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestPublisherTLS(t *testing.T) {
+	// Borrow the certificate of an httptest server, which is valid for 127.0.0.1.
+	httpServer := httptest.NewTLSServer(nil)
+	certificate := httpServer.TLS.Certificates[0]
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpServer.Certificate().Raw})
+	httpServer.Close()
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBroker(t, &tls.Config{Certificates: []tls.Certificate{certificate}})
+
+	cfg := testConfig("ssl://" + b.addr())
+	cfg.Discovery = false
+	cfg.CAFile = caFile
+	p, err := New(cfg, "1.2.3", zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer p.Close()
+
+	if err := p.Publish(&updater.Result{IP: "2001:db8::1"}, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if ip, _ := b.message("cloudflare-dyndns/test_node/ipv6"); ip.payload != "2001:db8::1" {
+		t.Errorf("unexpected ipv6 message %+v", ip)
+	}
+	if _, ok := b.message("homeassistant/sensor/test_node/ipv4/config"); ok {
+		t.Errorf("expected no discovery messages")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(config.MQTT{}, "", zerolog.Nop()); err == nil {
+		t.Errorf("expected a missing broker to be rejected")
+	}
+
+	cfg := testConfig("tcp://127.0.0.1:1883")
+	cfg.QoS = 3
+	if _, err := New(cfg, "", zerolog.Nop()); err == nil {
+		t.Errorf("expected an invalid QoS to be rejected")
+	}
+
+	cfg = testConfig("tcp://127.0.0.1:1883")
+	cfg.CAFile = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := New(cfg, "", zerolog.Nop()); err == nil {
+		t.Errorf("expected a missing CA file to be rejected")
+	}
+}
+
+func TestWithProfile(t *testing.T) {
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		name         string
+		cfg          config.MQTT
+		wantNodeID   string
+		wantClientID string
+	}{
+		{
+			name:       "defaults",
+			cfg:        config.MQTT{Broker: "tcp://127.0.0.1:1883"},
+			wantNodeID: strings.Trim(invalidNodeID.ReplaceAllString("cloudflare_dyndns_"+hostname+"_home", "_"), "_"),
+		},
+		{
+			name:         "configured",
+			cfg:          config.MQTT{Broker: "tcp://127.0.0.1:1883", NodeID: "router", ClientID: "router-client"},
+			wantNodeID:   "router_home",
+			wantClientID: "router-client-home",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(WithProfile(tt.cfg, "home"), "", zerolog.Nop())
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			wantClientID := tt.wantClientID
+			if wantClientID == "" {
+				wantClientID = strings.ReplaceAll(tt.wantNodeID, "_", "-")
+			}
+			if p.cfg.NodeID != tt.wantNodeID || p.cfg.ClientID != wantClientID {
+				t.Errorf("got node ID %q and client ID %q, want %q and %q", p.cfg.NodeID, p.cfg.ClientID, tt.wantNodeID, wantClientID)
+			}
+		})
+	}
+}
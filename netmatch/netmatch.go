@@ -0,0 +1,348 @@
+// Package netmatch decides whether the machine is on the home network, from its
+// default gateways and the state of its network interfaces.
+package netmatch
+
+import (
+	"bytes"
+	"cloudflare-dyndns/config"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/jackpal/gateway"
+)
+
+const (
+	// MatchAll requires every rule to match.
+	MatchAll = "all"
+	// MatchAny requires at least one rule to match.
+	MatchAny = "any"
+)
+
+// ErrNoGateway is returned by a Probe when there is no default gateway.
+var ErrNoGateway = errors.New("no default gateway")
+
+// Probe looks up the state of the network that the rules are checked against.
+type Probe struct {
+	// Gateway returns the IPv4 default gateway.
+	Gateway func() (netip.Addr, error)
+	// IPv6Gateway returns the IPv6 default gateway.
+	IPv6Gateway func() (netip.Addr, error)
+	// NeighbourMAC returns the MAC address of a neighbour, or nil when it is
+	// not in the neighbour table.
+	NeighbourMAC func(ip netip.Addr) (net.HardwareAddr, error)
+	// InterfaceUp reports whether the network interface with the name exists
+	// and is up.
+	InterfaceUp func(name string) (bool, error)
+}
+
+// SystemProbe returns a Probe that looks up the machine's network.
+func SystemProbe() Probe {
+	return Probe{
+		Gateway:      discoverGateway,
+		IPv6Gateway:  discoverIPv6Gateway,
+		NeighbourMAC: neighbourMAC,
+		InterfaceUp:  interfaceUp,
+	}
+}
+
+// Rules decide whether the machine is on the home network.
+type Rules struct {
+	any    bool
+	checks []check
+}
+
+// check reports whether a rule matches, and describes why when it does not.
+type check func(l *lookup) (bool, string, error)
+
+// Status is the outcome of checking the rules.
+type Status struct {
+	Matched bool
+	// Gateway is the IPv4 default gateway, when it was looked up.
+	Gateway string
+	// Reason describes the rules that did not match.
+	Reason string
+}
+
+// Parse returns the rules in the [network] section of cfg, with home_gateway
+// added to the allowed gateways. A config.KeyError is returned for every
+// invalid value, joined into one error.
+func Parse(cfg *config.Config) (*Rules, error) {
+	var errs []error
+	rules := &Rules{}
+	switch strings.ToLower(cfg.Network.Match) {
+	case "", MatchAll:
+	case MatchAny:
+		rules.any = true
+	default:
+		errs = append(errs, &config.KeyError{Key: "network.match", Err: fmt.Errorf(`%q is not "all" or "any"`, cfg.Network.Match)})
+	}
+
+	var gateways []netip.Prefix
+	var allowed []string
+	if cfg.HomeGateway != "" {
+		prefixes, err := parsePrefixes("main.home_gateway", []string{cfg.HomeGateway}, false)
+		errs = append(errs, err...)
+		gateways = append(gateways, prefixes...)
+		allowed = append(allowed, cfg.HomeGateway)
+	}
+	prefixes, err := parsePrefixes("network.gateways", cfg.Network.Gateways, false)
+	errs = append(errs, err...)
+	gateways = append(gateways, prefixes...)
+	allowed = append(allowed, cfg.Network.Gateways...)
+	if len(allowed) > 0 {
+		rules.checks = append(rules.checks, func(l *lookup) (bool, string, error) {
+			gw, err := l.gateway()
+			if errors.Is(err, ErrNoGateway) {
+				return false, "there is no default gateway", nil
+			} else if err != nil {
+				return false, "", err
+			}
+			if containsAddr(gateways, gw) {
+				return true, "", nil
+			}
+			return false, fmt.Sprintf("gateway %s is not one of %s", gw, strings.Join(allowed, ", ")), nil
+		})
+	}
+
+	var macs []net.HardwareAddr
+	for _, value := range cfg.Network.GatewayMACs {
+		mac, err := net.ParseMAC(value)
+		if err != nil {
+			errs = append(errs, &config.KeyError{Key: "network.gateway_macs", Err: fmt.Errorf("%q is not a MAC address", value)})
+			continue
+		}
+		macs = append(macs, mac)
+	}
+	if len(cfg.Network.GatewayMACs) > 0 {
+		rules.checks = append(rules.checks, func(l *lookup) (bool, string, error) {
+			gw, err := l.gateway()
+			if errors.Is(err, ErrNoGateway) {
+				return false, "there is no default gateway", nil
+			} else if err != nil {
+				return false, "", err
+			}
+			mac, err := l.probe.NeighbourMAC(gw)
+			if err != nil {
+				return false, "", fmt.Errorf("failed to look up the MAC address of gateway %s: %w", gw, err)
+			}
+			if mac == nil {
+				return false, fmt.Sprintf("the MAC address of gateway %s is unknown", gw), nil
+			}
+			for _, allowed := range macs {
+				if bytes.Equal(mac, allowed) {
+					return true, "", nil
+				}
+			}
+			return false, fmt.Sprintf("gateway %s has MAC address %s, not one of %s", gw, mac, strings.Join(cfg.Network.GatewayMACs, ", ")), nil
+		})
+	}
+
+	ipv6Gateways, err := parsePrefixes("network.ipv6_gateways", cfg.Network.IPv6Gateways, true)
+	errs = append(errs, err...)
+	if len(cfg.Network.IPv6Gateways) > 0 {
+		rules.checks = append(rules.checks, func(l *lookup) (bool, string, error) {
+			gw, err := l.probe.IPv6Gateway()
+			if errors.Is(err, ErrNoGateway) {
+				return false, "there is no IPv6 default gateway", nil
+			} else if err != nil {
+				return false, "", fmt.Errorf("failed to look up the IPv6 default gateway: %w", err)
+			}
+			if containsAddr(ipv6Gateways, gw) {
+				return true, "", nil
+			}
+			return false, fmt.Sprintf("IPv6 gateway %s is not one of %s", gw, strings.Join(cfg.Network.IPv6Gateways, ", ")), nil
+		})
+	}
+
+	errs = append(errs, checkInterfaces("network.require_interfaces", cfg.Network.RequireInterfaces)...)
+	errs = append(errs, checkInterfaces("network.forbid_interfaces", cfg.Network.ForbidInterfaces)...)
+	if required := cfg.Network.RequireInterfaces; len(required) > 0 {
+		rules.checks = append(rules.checks, func(l *lookup) (bool, string, error) {
+			for _, name := range required {
+				up, err := l.probe.InterfaceUp(name)
+				if err != nil {
+					return false, "", fmt.Errorf("failed to look up interface %s: %w", name, err)
+				}
+				if !up {
+					return false, fmt.Sprintf("interface %s is not up", name), nil
+				}
+			}
+			return true, "", nil
+		})
+	}
+	if forbidden := cfg.Network.ForbidInterfaces; len(forbidden) > 0 {
+		rules.checks = append(rules.checks, func(l *lookup) (bool, string, error) {
+			for _, name := range forbidden {
+				up, err := l.probe.InterfaceUp(name)
+				if err != nil {
+					return false, "", fmt.Errorf("failed to look up interface %s: %w", name, err)
+				}
+				if up {
+					return false, fmt.Sprintf("interface %s is up", name), nil
+				}
+			}
+			return true, "", nil
+		})
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Empty reports whether no rules are configured, so that updates happen from
+// any network.
+func (r *Rules) Empty() bool {
+	return len(r.checks) == 0
+}
+
+// Check looks up the network with the probe and reports whether the rules
+// match it. With "all", the first rule that does not match is given as the
+// reason; with "any", every rule is.
+func (r *Rules) Check(p Probe) (Status, error) {
+	l := &lookup{probe: p}
+	var status Status
+	var reasons []string
+	for _, check := range r.checks {
+		matched, reason, err := check(l)
+		status.Gateway = l.gatewayString()
+		if err != nil {
+			return status, err
+		}
+		if matched && r.any {
+			status.Matched = true
+			return status, nil
+		}
+		if !matched {
+			reasons = append(reasons, reason)
+			if !r.any {
+				break
+			}
+		}
+	}
+
+	status.Matched = len(reasons) == 0
+	status.Reason = strings.Join(reasons, "; ")
+
+	return status, nil
+}
+
+// lookup keeps the IPv4 default gateway once looked up, as several rules use it.
+type lookup struct {
+	probe      Probe
+	done       bool
+	gw         netip.Addr
+	gatewayErr error
+}
+
+func (l *lookup) gateway() (netip.Addr, error) {
+	if !l.done {
+		l.gw, l.gatewayErr = l.probe.Gateway()
+		l.done = true
+	}
+
+	return l.gw, l.gatewayErr
+}
+
+// gatewayString returns the IPv4 default gateway, or "" when it was not found.
+func (l *lookup) gatewayString() string {
+	if !l.gw.IsValid() {
+		return ""
+	}
+
+	return l.gw.String()
+}
+
+// parsePrefixes parses addresses and CIDR ranges of gateways, returning a
+// config.KeyError for each that is invalid or of the wrong family.
+func parsePrefixes(key string, values []string, ipv6 bool) ([]netip.Prefix, []error) {
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+
+	var prefixes []netip.Prefix
+	var errs []error
+	for _, value := range values {
+		var prefix netip.Prefix
+		var err error
+		if strings.Contains(value, "/") {
+			prefix, err = netip.ParsePrefix(value)
+			prefix = prefix.Masked()
+		} else {
+			var addr netip.Addr
+			if addr, err = netip.ParseAddr(value); err == nil {
+				addr = addr.WithZone("").Unmap()
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+		}
+		if err != nil || prefix.Addr().Is6() != ipv6 {
+			errs = append(errs, &config.KeyError{Key: key, Err: fmt.Errorf("%q is not an %s address or CIDR range", value, family)})
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, errs
+}
+
+// containsAddr reports whether any of the prefixes contains addr.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkInterfaces returns a config.KeyError for every empty interface name.
+func checkInterfaces(key string, names []string) []error {
+	var errs []error
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, &config.KeyError{Key: key, Err: errors.New("interface names must not be empty")})
+		}
+	}
+
+	return errs
+}
+
+// discoverGateway returns the IPv4 default gateway of the machine.
+func discoverGateway() (netip.Addr, error) {
+	ip, err := gateway.DiscoverGateway()
+	var noGateway *gateway.ErrNoGateway
+	if errors.As(err, &noGateway) {
+		return netip.Addr{}, ErrNoGateway
+	} else if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("%q is not a valid gateway address", ip)
+	}
+
+	return addr.Unmap(), nil
+}
+
+// interfaceUp reports whether the network interface exists and is up.
+func interfaceUp(name string) (bool, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return false, err
+	}
+	for _, iface := range interfaces {
+		if iface.Name == name {
+			return iface.Flags&net.FlagUp != 0, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+package netmatch
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// discoverIPv6Gateway returns the IPv6 default gateway from the kernel's
+// routing table.
+func discoverIPv6Gateway() (netip.Addr, error) {
+	f, err := os.Open("/proc/net/ipv6_route")
+	if os.IsNotExist(err) {
+		return netip.Addr{}, ErrNoGateway
+	} else if err != nil {
+		return netip.Addr{}, err
+	}
+	defer f.Close()
+
+	return parseIPv6Routes(f)
+}
+
+// parseIPv6Routes returns the gateway of the default route with the lowest
+// metric in the format of /proc/net/ipv6_route.
+func parseIPv6Routes(r io.Reader) (netip.Addr, error) {
+	const (
+		rtfUp      = 0x1
+		rtfGateway = 0x2
+	)
+
+	var best netip.Addr
+	var bestMetric uint64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[1] != "00" || strings.Trim(fields[0], "0") != "" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil || flags&(rtfUp|rtfGateway) != rtfUp|rtfGateway {
+			continue
+		}
+		metric, err := strconv.ParseUint(fields[5], 16, 32)
+		if err != nil {
+			continue
+		}
+		nextHop, err := hex.DecodeString(fields[4])
+		if err != nil {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(nextHop)
+		if !ok || addr.IsUnspecified() {
+			continue
+		}
+		if !best.IsValid() || metric < bestMetric {
+			best, bestMetric = addr, metric
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return netip.Addr{}, err
+	}
+	if !best.IsValid() {
+		return netip.Addr{}, ErrNoGateway
+	}
+
+	return best, nil
+}
+
+// neighbourMAC returns the MAC address of an IPv4 neighbour from the kernel's
+// ARP table.
+func neighbourMAC(ip netip.Addr) (net.HardwareAddr, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseARP(f, ip)
+}
+
+// parseARP returns the MAC address of ip in the format of /proc/net/arp, or nil
+// when it has no complete entry.
+func parseARP(r io.Reader, ip netip.Addr) (net.HardwareAddr, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != ip.String() {
+			continue
+		}
+		// Entries still being resolved have no flags and a zero address.
+		if flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32); err != nil || flags == 0 {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil {
+			continue
+		}
+
+		return mac, nil
+	}
+
+	return nil, scanner.Err()
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+package netmatch
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseIPv6Routes(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   string
+		expected string
+	}{
+		{
+			name: "lowestMetric",
+			routes: `20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000002 00000400 00000001 00000000 00000003    wlan0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000100 00000001 00000000 00000003     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+`,
+			expected: "fe80::1",
+		},
+		{
+			name: "noDefaultRoute",
+			routes: `20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := parseIPv6Routes(strings.NewReader(tt.routes))
+			if tt.expected == "" {
+				if !errors.Is(err, ErrNoGateway) {
+					t.Errorf("parseIPv6Routes() = %v, %v, want %v", addr, err, ErrNoGateway)
+				}
+				return
+			}
+			if err != nil || addr.String() != tt.expected {
+				t.Errorf("parseIPv6Routes() = %v, %v, want %s", addr, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseARP(t *testing.T) {
+	table := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+192.168.1.20     0x1         0x0         00:00:00:00:00:00     *        eth0
+`
+
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{ip: "192.168.1.1", expected: "aa:bb:cc:dd:ee:ff"},
+		{ip: "192.168.1.20"},
+		{ip: "192.168.1.30"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			mac, err := parseARP(strings.NewReader(table), netip.MustParseAddr(tt.ip))
+			if err != nil {
+				t.Fatalf("parseARP() error = %v", err)
+			}
+			if mac.String() != tt.expected {
+				t.Errorf("parseARP() = %q, want %q", mac, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package netmatch
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// discoverIPv6Gateway is only supported on Linux.
+func discoverIPv6Gateway() (netip.Addr, error) {
+	return netip.Addr{}, errors.ErrUnsupported
+}
+
+// neighbourMAC is only supported on Linux.
+func neighbourMAC(ip netip.Addr) (net.HardwareAddr, error) {
+	return nil, errors.ErrUnsupported
+}
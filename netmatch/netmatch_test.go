@@ -0,0 +1,152 @@
+package netmatch
+
+import (
+	"cloudflare-dyndns/config"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// testProbe returns a Probe for a network with the given gateways, gateway MAC
+// address and interfaces that are up. An empty gateway is not found.
+func testProbe(gw, gw6, mac string, up ...string) Probe {
+	lookup := func(s string) func() (netip.Addr, error) {
+		return func() (netip.Addr, error) {
+			if s == "" {
+				return netip.Addr{}, ErrNoGateway
+			}
+			return netip.MustParseAddr(s), nil
+		}
+	}
+
+	return Probe{
+		Gateway:     lookup(gw),
+		IPv6Gateway: lookup(gw6),
+		NeighbourMAC: func(ip netip.Addr) (net.HardwareAddr, error) {
+			if mac == "" || ip.String() != gw {
+				return nil, nil
+			}
+			return net.ParseMAC(mac)
+		},
+		InterfaceUp: func(name string) (bool, error) {
+			for _, u := range up {
+				if u == name {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	cfg := &config.Config{
+		HomeGateway: "192.168.1.256",
+		Network: config.Network{
+			Match:             "some",
+			Gateways:          []string{"10.0.0.0/8", "10.0.0.0/33", "2001:db8::1"},
+			GatewayMACs:       []string{"aa:bb:cc:dd:ee:ff", "aa-bb"},
+			IPv6Gateways:      []string{"fe80::1%eth0", "192.168.1.1"},
+			RequireInterfaces: []string{" "},
+		},
+	}
+
+	_, err := Parse(cfg)
+	var keys []string
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var keyErr *config.KeyError
+		if !errors.As(err, &keyErr) {
+			t.Fatalf("expected a config.KeyError, got %v", err)
+		}
+		keys = append(keys, keyErr.Key)
+	}
+	expected := "network.match main.home_gateway network.gateways network.gateways network.gateway_macs network.ipv6_gateways network.require_interfaces"
+	if got := strings.Join(keys, " "); got != expected {
+		t.Errorf("Parse() errors for %s, want %s", got, expected)
+	}
+
+	rules, err := Parse(&config.Config{})
+	if err != nil || !rules.Empty() {
+		t.Errorf("Parse() = %v, %v, want empty rules", rules, err)
+	}
+}
+
+func TestRules_Check(t *testing.T) {
+	home := testProbe("192.168.1.1", "fe80::1", "aa:bb:cc:dd:ee:ff", "eth0")
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		probe   Probe
+		matched bool
+		reason  string
+	}{
+		{name: "homeGateway", cfg: config.Config{HomeGateway: "192.168.1.1"}, probe: home, matched: true},
+		{name: "otherGateway", cfg: config.Config{HomeGateway: "192.168.1.1"}, probe: testProbe("10.0.0.1", "", ""), reason: "gateway 10.0.0.1 is not one of 192.168.1.1"},
+		{name: "noGateway", cfg: config.Config{HomeGateway: "192.168.1.1"}, probe: testProbe("", "", ""), reason: "there is no default gateway"},
+		{name: "gatewayRange", cfg: config.Config{Network: config.Network{Gateways: []string{"10.0.0.1", "192.168.0.0/16"}}}, probe: home, matched: true},
+		{name: "gatewayMAC", cfg: config.Config{Network: config.Network{GatewayMACs: []string{"AA-BB-CC-DD-EE-FF"}}}, probe: home, matched: true},
+		{name: "otherGatewayMAC", cfg: config.Config{Network: config.Network{GatewayMACs: []string{"aa:bb:cc:dd:ee:ff"}}}, probe: testProbe("192.168.1.1", "", "11:22:33:44:55:66"), reason: "gateway 192.168.1.1 has MAC address 11:22:33:44:55:66, not one of aa:bb:cc:dd:ee:ff"},
+		{name: "unknownGatewayMAC", cfg: config.Config{Network: config.Network{GatewayMACs: []string{"aa:bb:cc:dd:ee:ff"}}}, probe: testProbe("192.168.1.1", "", ""), reason: "the MAC address of gateway 192.168.1.1 is unknown"},
+		{name: "ipv6Gateway", cfg: config.Config{Network: config.Network{IPv6Gateways: []string{"fe80::1%eth0"}}}, probe: home, matched: true},
+		{name: "noIPv6Gateway", cfg: config.Config{Network: config.Network{IPv6Gateways: []string{"fe80::1"}}}, probe: testProbe("192.168.1.1", "", ""), reason: "there is no IPv6 default gateway"},
+		{name: "requiredInterface", cfg: config.Config{Network: config.Network{RequireInterfaces: []string{"eth0", "wlan0"}}}, probe: home, reason: "interface wlan0 is not up"},
+		{name: "forbiddenInterface", cfg: config.Config{Network: config.Network{ForbidInterfaces: []string{"wg0", "tun0"}}}, probe: testProbe("192.168.1.1", "", "", "tun0"), reason: "interface tun0 is up"},
+		{name: "forbiddenInterfaceDown", cfg: config.Config{Network: config.Network{ForbidInterfaces: []string{"wg0", "tun0"}}}, probe: home, matched: true},
+		{
+			name:   "allStopsAtFirst",
+			cfg:    config.Config{Network: config.Network{Gateways: []string{"192.168.1.1"}, ForbidInterfaces: []string{"eth0"}, RequireInterfaces: []string{"wg0"}}},
+			probe:  home,
+			reason: "interface wg0 is not up",
+		},
+		{
+			name:    "anyMatches",
+			cfg:     config.Config{Network: config.Network{Match: "ANY", Gateways: []string{"10.0.0.1"}, IPv6Gateways: []string{"fe80::1"}}},
+			probe:   home,
+			matched: true,
+		},
+		{
+			name:   "anyGivesEveryReason",
+			cfg:    config.Config{Network: config.Network{Match: "any", Gateways: []string{"10.0.0.1"}, ForbidInterfaces: []string{"eth0"}}},
+			probe:  home,
+			reason: "gateway 192.168.1.1 is not one of 10.0.0.1; interface eth0 is up",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Parse(&tt.cfg)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			status, err := rules.Check(tt.probe)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if status.Matched != tt.matched || status.Reason != tt.reason {
+				t.Errorf("Check() = %+v, want matched %v and reason %q", status, tt.matched, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRules_CheckError(t *testing.T) {
+	probe := testProbe("192.168.1.1", "", "")
+	probe.IPv6Gateway = func() (netip.Addr, error) {
+		return netip.Addr{}, errors.ErrUnsupported
+	}
+
+	rules, err := Parse(&config.Config{Network: config.Network{Gateways: []string{"192.168.1.1"}, IPv6Gateways: []string{"fe80::1"}}})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	status, err := rules.Check(probe)
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Check() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if status.Gateway != "192.168.1.1" {
+		t.Errorf("Check() gateway = %q, want 192.168.1.1", status.Gateway)
+	}
+}
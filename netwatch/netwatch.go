@@ -0,0 +1,48 @@
+// Package netwatch reports changes to the machine's network addresses and routes
+// so that an update can be started as soon as the connection changes.
+package netwatch
+
+import (
+	"context"
+	"time"
+)
+
+// Watch calls fn once the network addresses or routes have changed and no
+// further change has been seen for the debounce period. It blocks until the
+// context is cancelled. errors.ErrUnsupported is returned on platforms where
+// changes cannot be watched, and the read error when watching stops early.
+func Watch(ctx context.Context, debounce time.Duration, fn func()) error {
+	events, errs, err := subscribe(ctx)
+	if err != nil {
+		return err
+	}
+
+	return debounceEvents(ctx, events, errs, debounce, fn)
+}
+
+// debounceEvents calls fn once no event has arrived for the debounce period.
+// When the events channel is closed, the error sent on errs, if any, is returned.
+func debounceEvents(ctx context.Context, events <-chan struct{}, errs <-chan error, debounce time.Duration, fn func()) error {
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-events:
+			if !ok {
+				select {
+				case err := <-errs:
+					return err
+				default:
+					return nil
+				}
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			fn()
+		}
+	}
+}
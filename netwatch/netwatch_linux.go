@@ -0,0 +1,114 @@
+//go:build linux
+
+package netwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Multicast groups from linux/rtnetlink.h, which the syscall package does not define.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// subscribe listens for rtnetlink address and route notifications. The events
+// channel is closed when reading stops, after a read error has been sent on the
+// errors channel.
+func subscribe(ctx context.Context) (<-chan struct{}, <-chan error, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socket", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr | rtmgrpIPv4Route | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		_ = syscall.Close(fd)
+		return nil, nil, os.NewSyscallError("bind", err)
+	}
+
+	// Wrapping the non-blocking socket in a file hands it to the runtime poller,
+	// so that closing the file interrupts a pending read.
+	socket := os.NewFile(uintptr(fd), "netlink")
+	go func() {
+		<-ctx.Done()
+		_ = socket.Close()
+	}()
+
+	events := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		if err := readEvents(ctx, socket, events); err != nil {
+			errs <- err
+		}
+	}()
+
+	return events, errs, nil
+}
+
+// readEvents reads netlink messages from r and sends an event for every change,
+// until the context is cancelled or reading fails.
+func readEvents(ctx context.Context, r io.Reader, events chan<- struct{}) error {
+	buf := make([]byte, os.Getpagesize()*4)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// The kernel drops messages when the buffer overflows; treat that
+			// as a change, since something clearly happened, and carry on.
+			if errors.Is(err, syscall.ENOBUFS) {
+				notify(events)
+				continue
+			}
+			return fmt.Errorf("failed to read network changes: %w", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		if changed(msgs) {
+			notify(events)
+		}
+	}
+}
+
+// changed reports whether any of the messages is an address change or a change
+// to a default route.
+func changed(msgs []syscall.NetlinkMessage) bool {
+	for _, msg := range msgs {
+		switch msg.Header.Type {
+		case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+			return true
+		case syscall.RTM_NEWROUTE:
+			// The second byte of the rtmsg header is the destination prefix
+			// length, which is zero for a default route.
+			if len(msg.Data) >= syscall.SizeofRtMsg && msg.Data[1] == 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// notify sends an event without blocking; a pending event already covers this one.
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package netwatch
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestChanged(t *testing.T) {
+	route := func(dstLen byte) []byte {
+		data := make([]byte, syscall.SizeofRtMsg)
+		data[0] = syscall.AF_INET
+		data[1] = dstLen
+		return data
+	}
+
+	tests := []struct {
+		name string
+		msgs []syscall.NetlinkMessage
+		want bool
+	}{
+		{name: "newAddress", msgs: []syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWADDR}}}, want: true},
+		{name: "deletedAddress", msgs: []syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: syscall.RTM_DELADDR}}}, want: true},
+		{name: "defaultRoute", msgs: []syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: route(0)}}, want: true},
+		{name: "otherRoute", msgs: []syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: route(24)}}, want: false},
+		{name: "truncatedRoute", msgs: []syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: []byte{0}}}, want: false},
+		{name: "link", msgs: []syscall.NetlinkMessage{{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK}}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changed(tt.msgs); got != tt.want {
+				t.Errorf("changed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	events, _, err := subscribe(t.Context())
+	if err != nil {
+		t.Skipf("netlink is not available: %v", err)
+	}
+	if events == nil {
+		t.Fatal("expected an event channel")
+	}
+}
+
+// fakeSocket returns the results of reads in order.
+type fakeSocket struct {
+	reads []func(buf []byte) (int, error)
+}
+
+func (f *fakeSocket) Read(buf []byte) (int, error) {
+	read := f.reads[0]
+	f.reads = f.reads[1:]
+	return read(buf)
+}
+
+func TestReadEvents(t *testing.T) {
+	readErr := errors.New("socket closed unexpectedly")
+	socket := &fakeSocket{reads: []func(buf []byte) (int, error){
+		// os.File wraps the errno, as it does for the netlink socket.
+		func(buf []byte) (int, error) {
+			return 0, &os.PathError{Op: "read", Path: "netlink", Err: syscall.ENOBUFS}
+		},
+		func(buf []byte) (int, error) {
+			return 0, readErr
+		},
+	}}
+
+	events := make(chan struct{}, 1)
+	err := readEvents(t.Context(), socket, events)
+	if !errors.Is(err, readErr) {
+		t.Errorf("readEvents() error = %v, want %v", err, readErr)
+	}
+	select {
+	case <-events:
+	default:
+		t.Errorf("expected an event after the buffer overflowed")
+	}
+}
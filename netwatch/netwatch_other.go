@@ -0,0 +1,13 @@
+//go:build !linux
+
+package netwatch
+
+import (
+	"context"
+	"errors"
+)
+
+// subscribe is only supported on Linux.
+func subscribe(ctx context.Context) (<-chan struct{}, <-chan error, error) {
+	return nil, nil, errors.ErrUnsupported
+}
@@ -0,0 +1,73 @@
+package netwatch
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDebounceEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	events := make(chan struct{})
+	calls := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- debounceEvents(ctx, events, nil, 50*time.Millisecond, func() {
+			calls <- struct{}{}
+		})
+	}()
+
+	// A burst of events results in a single call.
+	for i := 0; i < 5; i++ {
+		events <- struct{}{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("expected a call after the burst of events")
+	}
+	select {
+	case <-calls:
+		t.Fatal("expected only one call for the burst of events")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("debounceEvents() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("debounceEvents() did not stop")
+	}
+}
+
+func TestDebounceEventsClosed(t *testing.T) {
+	events := make(chan struct{})
+	close(events)
+
+	var calls atomic.Int32
+	if err := debounceEvents(t.Context(), events, nil, time.Millisecond, func() { calls.Add(1) }); err != nil {
+		t.Errorf("debounceEvents() error = %v", err)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("expected no calls, got %d", calls.Load())
+	}
+}
+
+func TestDebounceEventsError(t *testing.T) {
+	events := make(chan struct{})
+	errs := make(chan error, 1)
+	errs <- errors.New("read failed")
+	close(events)
+
+	if err := debounceEvents(t.Context(), events, errs, time.Millisecond, func() {}); err == nil || err.Error() != "read failed" {
+		t.Errorf("debounceEvents() error = %v, want the read error", err)
+	}
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"context"
+	"errors"
+	"time"
+)
+
+// discordColors are the embed colours used for each event.
+var discordColors = map[Kind]int{
+	EventIPChanged:       0x3498db,
+	EventUpdateFailed:    0xe74c3c,
+	EventGatewayMismatch: 0xf1c40f,
+	EventRecovered:       0x2ecc71,
+}
+
+// Discord posts notifications to a Discord channel webhook as embeds.
+type Discord struct {
+	webhookURL string
+	username   string
+	sender     *sender
+}
+
+func newDiscord(cfg config.Discord, s *sender) (*Discord, error) {
+	if cfg.WebhookURL == "" {
+		return nil, errors.New("webhook_url is required")
+	}
+
+	username := cfg.Username
+	if username == "" {
+		username = "cloudflare-dyndns"
+	}
+
+	return &Discord{webhookURL: cfg.WebhookURL, username: username, sender: s}, nil
+}
+
+// Notify posts the event to the webhook.
+func (n *Discord) Notify(ctx context.Context, event Event) error {
+	type embedField struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline"`
+	}
+	type footer struct {
+		Text string `json:"text"`
+	}
+	type embed struct {
+		Title       string       `json:"title"`
+		Description string       `json:"description"`
+		Color       int          `json:"color"`
+		Timestamp   string       `json:"timestamp"`
+		Fields      []embedField `json:"fields,omitempty"`
+		Footer      footer       `json:"footer"`
+	}
+
+	e := embed{
+		Title:       event.Title(),
+		Description: event.Message(),
+		Color:       discordColors[event.Kind],
+		Timestamp:   event.Time.Format(time.RFC3339),
+		Footer:      footer{Text: event.Hostname},
+	}
+	for _, f := range eventFields(event) {
+		e.Fields = append(e.Fields, embedField{Name: f.name, Value: f.value, Inline: true})
+	}
+
+	return n.sender.sendJSON(ctx, n.webhookURL, nil, map[string]interface{}{
+		"username": n.username,
+		"embeds":   []embed{e},
+	})
+}
@@ -0,0 +1,335 @@
+package notify
+
+import (
+	"bytes"
+	"cloudflare-dyndns/config"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The ways of securing the connection to an SMTP server.
+const (
+	EmailTLSStartTLS = "starttls"
+	EmailTLSImplicit = "implicit"
+	EmailTLSNone     = "none"
+)
+
+// The SMTP authentication mechanisms.
+const (
+	EmailAuthPlain = "plain"
+	EmailAuthLogin = "login"
+)
+
+// emailHTML is the HTML part of notification emails.
+var emailHTML = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<h2>{{.Title}}</h2>
+<p>{{.Message}}</p>
+<table cellpadding="4">
+{{- range .Fields}}
+<tr><th align="left">{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+<tr><th align="left">Host</th><td>{{.Hostname}}</td></tr>
+<tr><th align="left">Time</th><td>{{.Time}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// Email mails notifications through an SMTP server.
+type Email struct {
+	host     string
+	port     int
+	security string
+	auth     string
+	username string
+	password string
+	from     *mail.Address
+	to       []*mail.Address
+	timeout  time.Duration
+
+	// tlsConfig, when set, replaces the default TLS configuration.
+	tlsConfig *tls.Config
+}
+
+func newEmail(cfg config.Email, timeout time.Duration) (*Email, error) {
+	if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("host, from and to are required")
+	}
+
+	e := &Email{
+		host:     cfg.Host,
+		port:     cfg.Port,
+		security: strings.ToLower(cfg.TLS),
+		auth:     strings.ToLower(cfg.Auth),
+		username: cfg.Username,
+		password: cfg.Password,
+		timeout:  timeout,
+	}
+	if e.timeout <= 0 {
+		e.timeout = 10 * time.Second
+	}
+
+	switch e.security {
+	case "":
+		e.security = EmailTLSStartTLS
+	case EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone:
+	default:
+		return nil, fmt.Errorf("unknown tls %q, use %s, %s or %s", cfg.TLS, EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone)
+	}
+	if e.port == 0 {
+		e.port = map[string]int{EmailTLSStartTLS: 587, EmailTLSImplicit: 465, EmailTLSNone: 25}[e.security]
+	}
+
+	switch e.auth {
+	case "":
+		e.auth = EmailAuthPlain
+	case EmailAuthPlain, EmailAuthLogin:
+	default:
+		return nil, fmt.Errorf("unknown auth %q, use %s or %s", cfg.Auth, EmailAuthPlain, EmailAuthLogin)
+	}
+
+	var err error
+	if e.from, err = mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	for _, to := range cfg.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		e.to = append(e.to, address)
+	}
+
+	return e, nil
+}
+
+// Notify mails the event to every recipient.
+func (e *Email) Notify(ctx context.Context, event Event) error {
+	message, err := e.message(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	client, err := e.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if e.username != "" {
+		if err := client.Auth(e.smtpAuth()); err != nil {
+			return fmt.Errorf("authenticating with %s: %w", e.host, err)
+		}
+	}
+	if err := client.Mail(e.from.Address); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := client.Rcpt(to.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dial connects to the SMTP server, securing the connection as configured.
+func (e *Email) dial(ctx context.Context) (*smtp.Client, error) {
+	tlsConfig := e.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: e.host}
+	}
+
+	address := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if e.security == EmailTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if e.security == EmailTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			_ = client.Close()
+			return nil, fmt.Errorf("%s does not support STARTTLS", e.host)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func (e *Email) smtpAuth() smtp.Auth {
+	if e.auth == EmailAuthLogin {
+		return &loginAuth{username: e.username, password: e.password}
+	}
+
+	return smtp.PlainAuth("", e.username, e.password, e.host)
+}
+
+// message builds the email for the event, with a text and an HTML part.
+func (e *Email) message(event Event) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	var to []string
+	for _, address := range e.to {
+		to = append(to, address.String())
+	}
+	headers := []string{
+		"From: " + e.from.String(),
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", fmt.Sprintf("[cloudflare-dyndns] %s on %s", event.Title(), event.Hostname)),
+		"Date: " + event.Time.Format(time.RFC1123Z),
+		"Message-ID: " + messageID(e.from.Address),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + body.Boundary(),
+	}
+	var message bytes.Buffer
+	message.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	text := event.Message() + "\n"
+	for _, field := range eventFields(event) {
+		text += fmt.Sprintf("\n%s: %s", field.name, field.value)
+	}
+	text += fmt.Sprintf("\nHost: %s\nTime: %s\n", event.Hostname, event.Time.Format(time.RFC3339))
+
+	var html bytes.Buffer
+	type htmlField struct{ Name, Value string }
+	var fields []htmlField
+	for _, field := range eventFields(event) {
+		fields = append(fields, htmlField{Name: field.name, Value: field.value})
+	}
+	err := emailHTML.Execute(&html, map[string]interface{}{
+		"Title":    event.Title(),
+		"Message":  event.Message(),
+		"Fields":   fields,
+		"Hostname": event.Hostname,
+		"Time":     event.Time.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{contentType: "text/plain; charset=utf-8", content: text},
+		{contentType: "text/html; charset=utf-8", content: html.String()},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(strings.ReplaceAll(part.content, "\n", "\r\n"))); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	message.Write(buf.Bytes())
+	return message.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID in the domain of the sender.
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which some servers
+// offer instead of PLAIN.
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSuffix(string(fromServer), ":")) {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+// isLocalhost reports whether name is the local machine, which credentials may
+// be sent to without encryption.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,287 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message received by the test SMTP server.
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+	auth string
+	tls  bool
+}
+
+// smtpServer is a minimal in-process SMTP server for testing the email notifier.
+type smtpServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	implicit  bool
+
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+// newSMTPServer starts a test SMTP server. Its certificate is trusted by the
+// returned TLS configuration.
+func newSMTPServer(t *testing.T, implicit bool) (*smtpServer, *tls.Config) {
+	t.Helper()
+
+	// Borrow the certificate of an httptest server, which is valid for 127.0.0.1.
+	httpServer := httptest.NewTLSServer(nil)
+	certificate := httpServer.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(httpServer.Certificate())
+	httpServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+		implicit:  implicit,
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	secure := s.implicit
+	if s.implicit {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 localhost ESMTP test")
+
+	var message smtpMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			extensions := []string{"250-localhost", "250-AUTH PLAIN LOGIN"}
+			if !secure {
+				extensions = append(extensions, "250-STARTTLS")
+			}
+			extensions = append(extensions, "250 8BITMIME")
+			for _, extension := range extensions {
+				_ = text.PrintfLine("%s", extension)
+			}
+		case "STARTTLS":
+			_ = text.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			secure = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			switch strings.ToUpper(mechanism) {
+			case "PLAIN":
+				decoded, _ := base64.StdEncoding.DecodeString(initial)
+				parts := strings.Split(string(decoded), "\x00")
+				message.auth = "PLAIN " + strings.Join(parts[1:], ":")
+			case "LOGIN":
+				_ = text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				username, _ := text.ReadLine()
+				_ = text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				password, _ := text.ReadLine()
+				decodedUsername, _ := base64.StdEncoding.DecodeString(username)
+				decodedPassword, _ := base64.StdEncoding.DecodeString(password)
+				message.auth = "LOGIN " + string(decodedUsername) + ":" + string(decodedPassword)
+			}
+			_ = text.PrintfLine("235 authenticated")
+		case "MAIL":
+			message.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			if i := strings.Index(message.from, ">"); i >= 0 {
+				message.from = message.from[:i]
+			}
+			_ = text.PrintfLine("250 ok")
+		case "RCPT":
+			message.to = append(message.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			_ = text.PrintfLine("250 ok")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			message.tls = secure
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpServer) received() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.messages
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		implicit bool
+		cfg      config.Email
+		auth     string
+	}{
+		{
+			name: "starttls_plain",
+			cfg:  config.Email{TLS: "starttls", Username: "user", Password: "pass"},
+			auth: "PLAIN user:pass",
+		},
+		{
+			name:     "implicit_login",
+			implicit: true,
+			cfg:      config.Email{TLS: "implicit", Auth: "login", Username: "user", Password: "pass"},
+			auth:     "LOGIN user:pass",
+		},
+		{
+			name: "no_auth",
+			cfg:  config.Email{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, tlsConfig := newSMTPServer(t, tt.implicit)
+
+			cfg := tt.cfg
+			cfg.Host = "127.0.0.1"
+			cfg.Port = server.port()
+			cfg.From = "DynDNS <dyndns@example.com>"
+			cfg.To = []string{"oncall@example.com", "Admin <admin@example.com>"}
+			n, err := newEmail(cfg, 5*time.Second)
+			if err != nil {
+				t.Fatalf("newEmail() error = %v", err)
+			}
+			n.tlsConfig = tlsConfig
+
+			if err := n.Notify(t.Context(), testEvent); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+
+			messages := server.received()
+			if len(messages) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(messages))
+			}
+			received := messages[0]
+			if received.from != "dyndns@example.com" || strings.Join(received.to, ",") != "oncall@example.com,admin@example.com" {
+				t.Errorf("unexpected envelope %s -> %v", received.from, received.to)
+			}
+			if received.auth != tt.auth || !received.tls {
+				t.Errorf("unexpected auth %q or tls %v", received.auth, received.tls)
+			}
+
+			parsed, err := mail.ReadMessage(strings.NewReader(received.data))
+			if err != nil {
+				t.Fatalf("unable to parse the message: %v", err)
+			}
+			subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+			if subject != "[cloudflare-dyndns] IP address changed on router" {
+				t.Errorf("unexpected subject %q", subject)
+			}
+			mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/alternative" {
+				t.Fatalf("unexpected content type %s: %v", parsed.Header.Get("Content-Type"), err)
+			}
+
+			reader := multipart.NewReader(parsed.Body, params["boundary"])
+			var parts []string
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(part)
+				parts = append(parts, part.Header.Get("Content-Type"))
+				if !strings.Contains(string(content), "2.2.2.2") {
+					t.Errorf("expected the %s part to contain the new IP address, got %s", part.Header.Get("Content-Type"), content)
+				}
+			}
+			if strings.Join(parts, ",") != "text/plain; charset=utf-8,text/html; charset=utf-8" {
+				t.Errorf("unexpected parts %v", parts)
+			}
+		})
+	}
+}
+
+func TestNewEmail(t *testing.T) {
+	valid := config.Email{Host: "smtp.example.com", From: "dyndns@example.com", To: []string{"oncall@example.com"}}
+
+	n, err := newEmail(valid, 0)
+	if err != nil {
+		t.Fatalf("newEmail() error = %v", err)
+	}
+	if n.port != 587 || n.security != EmailTLSStartTLS || n.auth != EmailAuthPlain {
+		t.Errorf("unexpected defaults %+v", n)
+	}
+
+	for name, modify := range map[string]func(*config.Email){
+		"missing_to":   func(cfg *config.Email) { cfg.To = nil },
+		"invalid_tls":  func(cfg *config.Email) { cfg.TLS = "ssl" },
+		"invalid_auth": func(cfg *config.Email) { cfg.Auth = "cram-md5" },
+		"invalid_from": func(cfg *config.Email) { cfg.From = "not an address" },
+	} {
+		cfg := valid
+		modify(&cfg)
+		if _, err := newEmail(cfg, 0); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
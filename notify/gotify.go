@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"context"
+	"errors"
+	"strings"
+)
+
+// Gotify sends notifications to a Gotify server as messages.
+type Gotify struct {
+	baseURL  string
+	token    string
+	priority int
+	sender   *sender
+}
+
+func newGotify(cfg config.Gotify, s *sender) (*Gotify, error) {
+	if cfg.BaseURL == "" || cfg.Token == "" {
+		return nil, errors.New("base_url and token are required")
+	}
+
+	return &Gotify{
+		baseURL:  strings.TrimSuffix(cfg.BaseURL, "/"),
+		token:    cfg.Token,
+		priority: cfg.Priority,
+		sender:   s,
+	}, nil
+}
+
+// Notify sends the event as a message. Failures are sent with a high priority
+// unless a priority is configured.
+func (n *Gotify) Notify(ctx context.Context, event Event) error {
+	priority := n.priority
+	if priority == 0 {
+		priority = 5
+		if event.Kind == EventUpdateFailed {
+			priority = 8
+		}
+	}
+
+	return n.sender.sendJSON(ctx, n.baseURL+"/message", map[string]string{"X-Gotify-Key": n.token}, map[string]interface{}{
+		"title":    event.Title(),
+		"message":  event.Message(),
+		"priority": priority,
+	})
+}
@@ -0,0 +1,338 @@
+// Package notify tells people about changed IP addresses and failing updates,
+// for example by calling a webhook.
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/updater"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Kind names something that happened during a run that notifications can be sent for.
+type Kind string
+
+const (
+	// EventIPChanged is sent when a run updates one or more records.
+	EventIPChanged Kind = "ip_changed"
+	// EventUpdateFailed is sent when the number of runs that failed in a row
+	// reaches the failure threshold.
+	EventUpdateFailed Kind = "update_failed"
+	// EventGatewayMismatch is sent when runs start being skipped because the
+	// gateway is not the home gateway.
+	EventGatewayMismatch Kind = "gateway_mismatch"
+	// EventRecovered is sent when a run succeeds after update_failed was sent.
+	EventRecovered Kind = "recovered"
+)
+
+// kinds lists every event notifiers can choose from.
+var kinds = []Kind{EventIPChanged, EventUpdateFailed, EventGatewayMismatch, EventRecovered}
+
+// DefaultEvents are sent to notifiers that do not choose their own events.
+var DefaultEvents = kinds
+
+// Event describes something that happened during a run.
+type Event struct {
+	Kind     Kind
+	Hostname string
+	Time     time.Time
+	OldIP    string
+	NewIP    string
+	Records  []string
+	Errors   []string
+	Failures int
+	Gateway  string
+	// Reason describes why the machine is not on the home network.
+	Reason string
+}
+
+// Title returns a short heading for the event.
+func (e Event) Title() string {
+	switch e.Kind {
+	case EventIPChanged:
+		return "IP address changed"
+	case EventUpdateFailed:
+		return "IP address update failed"
+	case EventGatewayMismatch:
+		return "Not on the home network"
+	case EventRecovered:
+		return "IP address updates recovered"
+	}
+
+	return string(e.Kind)
+}
+
+// Message returns a one line description of the event.
+func (e Event) Message() string {
+	switch e.Kind {
+	case EventIPChanged:
+		if e.OldIP == "" {
+			return fmt.Sprintf("IP address of %s set to %s for %s.", e.Hostname, e.NewIP, strings.Join(e.Records, ", "))
+		}
+		return fmt.Sprintf("IP address of %s changed from %s to %s for %s.", e.Hostname, e.OldIP, e.NewIP, strings.Join(e.Records, ", "))
+	case EventUpdateFailed:
+		return fmt.Sprintf("Updating the IP address of %s has failed %d time(s) in a row: %s", e.Hostname, e.Failures, strings.Join(e.Errors, "; "))
+	case EventGatewayMismatch:
+		if e.Reason != "" {
+			return fmt.Sprintf("%s is not on the home network, as %s, updates are paused.", e.Hostname, e.Reason)
+		}
+		return fmt.Sprintf("%s is using gateway %s instead of the home gateway, updates are paused.", e.Hostname, e.Gateway)
+	case EventRecovered:
+		return fmt.Sprintf("Updating the IP address of %s works again after %d failure(s), publishing %s.", e.Hostname, e.Failures, e.NewIP)
+	}
+
+	return string(e.Kind)
+}
+
+// Notifier sends a notification for an event.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// target is a notifier along with the events it is sent.
+type target struct {
+	name     string
+	notifier Notifier
+	events   []Kind
+}
+
+// Dispatcher works out the events of each run and sends them to the configured notifiers.
+type Dispatcher struct {
+	logger           zerolog.Logger
+	failureThreshold int
+	targets          []target
+}
+
+// New returns a Dispatcher for the notifiers in the given configuration.
+func New(cfg *config.Config, logger zerolog.Logger) (*Dispatcher, error) {
+	d := &Dispatcher{
+		logger:           logger,
+		failureThreshold: max(cfg.NotifyFailureThreshold, 1),
+	}
+	s := newSender(cfg.NotifyTimeout, cfg.NotifyRetries)
+
+	var errs []error
+	for i, webhookCfg := range cfg.Webhooks {
+		webhook, err := newWebhook(webhookCfg, s)
+		errs = append(errs, d.add(fmt.Sprintf("notify.webhooks[%d]", i), webhook, err, webhookCfg.Events))
+	}
+	for i, slackCfg := range cfg.Slack {
+		slack, err := newSlack(slackCfg, s)
+		errs = append(errs, d.add(fmt.Sprintf("notify.slack[%d]", i), slack, err, slackCfg.Events))
+	}
+	for i, discordCfg := range cfg.Discord {
+		discord, err := newDiscord(discordCfg, s)
+		errs = append(errs, d.add(fmt.Sprintf("notify.discord[%d]", i), discord, err, discordCfg.Events))
+	}
+	for i, ntfyCfg := range cfg.Ntfy {
+		ntfy, err := newNtfy(ntfyCfg, s)
+		errs = append(errs, d.add(fmt.Sprintf("notify.ntfy[%d]", i), ntfy, err, ntfyCfg.Events))
+	}
+	for i, gotifyCfg := range cfg.Gotify {
+		gotify, err := newGotify(gotifyCfg, s)
+		errs = append(errs, d.add(fmt.Sprintf("notify.gotify[%d]", i), gotify, err, gotifyCfg.Events))
+	}
+	for i, telegramCfg := range cfg.Telegram {
+		telegram, err := newTelegram(telegramCfg, s)
+		errs = append(errs, d.add(fmt.Sprintf("notify.telegram[%d]", i), telegram, err, telegramCfg.Events))
+	}
+	for i, emailCfg := range cfg.Email {
+		email, err := newEmail(emailCfg, cfg.NotifyTimeout)
+		errs = append(errs, d.add(fmt.Sprintf("notify.email[%d]", i), email, err, emailCfg.Events))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// add sends the given events, or the default events when empty, to the notifier.
+// err is the error from creating the notifier, if any. Problems are returned as
+// a *config.KeyError naming the block they are in.
+func (d *Dispatcher) add(name string, notifier Notifier, err error, events []string) error {
+	if err != nil {
+		return &config.KeyError{Key: name, Err: err}
+	}
+
+	t := target{name: name, notifier: notifier, events: DefaultEvents}
+	if len(events) > 0 {
+		t.events = nil
+		for _, event := range events {
+			kind, err := ParseKind(event)
+			if err != nil {
+				return &config.KeyError{Key: name + ".events", Err: err}
+			}
+			t.events = append(t.events, kind)
+		}
+	}
+	d.targets = append(d.targets, t)
+
+	return nil
+}
+
+// ParseKind returns the event with the given name.
+func ParseKind(name string) (Kind, error) {
+	kind := Kind(name)
+	if !slices.Contains(kinds, kind) {
+		return "", fmt.Errorf("unknown event %q", name)
+	}
+
+	return kind, nil
+}
+
+// Sample returns an example of the given event, for testing notifiers.
+func Sample(kind Kind, records []string) Event {
+	hostname, _ := os.Hostname()
+	event := Event{
+		Kind:     kind,
+		Hostname: hostname,
+		Time:     time.Now().UTC(),
+		OldIP:    "192.0.2.1",
+		NewIP:    "192.0.2.2",
+		Records:  records,
+	}
+
+	switch kind {
+	case EventUpdateFailed:
+		event.OldIP = ""
+		event.Errors = []string{"this is a test notification"}
+		event.Failures = 3
+	case EventGatewayMismatch:
+		event.OldIP, event.NewIP, event.Records = "", "", nil
+		event.Gateway = "192.0.2.254"
+		event.Reason = "gateway 192.0.2.254 is not one of 192.168.1.1"
+	case EventRecovered:
+		event.OldIP, event.Records = "", nil
+		event.Failures = 3
+	}
+
+	return event
+}
+
+// Empty reports whether no notifiers are configured.
+func (d *Dispatcher) Empty() bool {
+	return len(d.targets) == 0
+}
+
+// Dispatch sends the events of a run to the notifiers that asked for them.
+// Failed notifications are logged and returned together.
+func (d *Dispatcher) Dispatch(ctx context.Context, result *updater.Result, err error) error {
+	if d == nil || d.Empty() {
+		return nil
+	}
+
+	var errs []error
+	for _, event := range Events(result, err, d.failureThreshold) {
+		errs = append(errs, d.Send(ctx, event))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Send sends a single event to the notifiers that asked for it.
+func (d *Dispatcher) Send(ctx context.Context, event Event) error {
+	var errs []error
+	for _, t := range d.targets {
+		if !slices.Contains(t.events, event.Kind) {
+			continue
+		}
+
+		if err := t.notifier.Notify(ctx, event); err != nil {
+			d.logger.Error().Msg(fmt.Sprintf("unable to send %s notification to %s: %v", event.Kind, t.name, err))
+			errs = append(errs, fmt.Errorf("%s: %w", t.name, err))
+			continue
+		}
+		d.logger.Info().Msg(fmt.Sprintf("sent %s notification to %s", event.Kind, t.name))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Events returns the events of a run. A failure is only reported on the run
+// that reaches the failure threshold, and a gateway mismatch on the first run
+// skipped, so that a long outage is not repeated on every run.
+func Events(result *updater.Result, err error, failureThreshold int) []Event {
+	hostname, _ := os.Hostname()
+	base := Event{Hostname: hostname, Time: time.Now().UTC(), NewIP: result.IP}
+
+	var events []Event
+	if result.Skipped {
+		if !result.PreviousSkipped {
+			event := base
+			event.Kind = EventGatewayMismatch
+			event.Gateway = result.CurrentGateway
+			event.Reason = result.SkipReason
+			events = append(events, event)
+		}
+		return events
+	}
+
+	if err == nil && result.PreviousFailures >= failureThreshold {
+		event := base
+		event.Kind = EventRecovered
+		event.Failures = result.PreviousFailures
+		events = append(events, event)
+	}
+
+	if result.Changed() {
+		event := base
+		event.Kind = EventIPChanged
+		for _, record := range result.Records {
+			if record.Action != updater.ActionUpdated {
+				continue
+			}
+			if event.OldIP == "" && record.Reason == updater.ReasonConfigured {
+				event.OldIP = record.OldIP
+			}
+			event.Records = append(event.Records, record.Name)
+		}
+		events = append(events, event)
+	}
+
+	if err != nil && result.PreviousFailures+1 == failureThreshold {
+		event := base
+		event.Kind = EventUpdateFailed
+		event.Failures = result.PreviousFailures + 1
+		event.Errors = append(event.Errors, err.Error())
+		for _, record := range result.Records {
+			if record.Action == updater.ActionFailed {
+				event.Records = append(event.Records, record.Name)
+				event.Errors = append(event.Errors, fmt.Sprintf("%s: %v", record.Name, record.Error))
+			}
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// field is a named detail of an event, shown separately by notifiers that
+// support it.
+type field struct {
+	name  string
+	value string
+}
+
+// eventFields returns the details of an event worth showing on their own.
+func eventFields(event Event) []field {
+	var fields []field
+	if event.OldIP != "" {
+		fields = append(fields, field{name: "Old IP", value: event.OldIP})
+	}
+	if event.NewIP != "" {
+		fields = append(fields, field{name: "New IP", value: event.NewIP})
+	}
+	if len(event.Records) > 0 {
+		fields = append(fields, field{name: "Records", value: strings.Join(event.Records, ", ")})
+	}
+
+	return fields
+}
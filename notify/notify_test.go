@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/updater"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEvents(t *testing.T) {
+	changed := &updater.Result{
+		IP: "2.2.2.2",
+		Records: []updater.RecordResult{
+			{Name: "home.example.com", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Action: updater.ActionUpdated, Reason: updater.ReasonConfigured},
+			{Name: "www.example.com", OldIP: "2.2.2.2", NewIP: "2.2.2.2", Action: updater.ActionUnchanged, Reason: updater.ReasonConfigured},
+			{Name: "vpn.example.com", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Action: updater.ActionUpdated, Reason: updater.ReasonFollow},
+		},
+	}
+	failed := &updater.Result{
+		IP: "2.2.2.2",
+		Records: []updater.RecordResult{
+			{Name: "home.example.com", Action: updater.ActionFailed, Error: errors.New("rejected")},
+		},
+		PreviousFailures: 2,
+	}
+
+	tests := []struct {
+		name     string
+		result   *updater.Result
+		err      error
+		expected []Kind
+	}{
+		{name: "unchanged", result: &updater.Result{IP: "2.2.2.2"}},
+		{name: "changed", result: changed, expected: []Kind{EventIPChanged}},
+		{name: "failure_threshold_reached", result: failed, err: errors.New("failed to update 1 DNS record(s)"), expected: []Kind{EventUpdateFailed}},
+		{name: "failure_below_threshold", result: &updater.Result{PreviousFailures: 1}, err: errors.New("timeout")},
+		{name: "failure_above_threshold", result: &updater.Result{PreviousFailures: 3}, err: errors.New("timeout")},
+		{name: "recovered", result: &updater.Result{IP: "2.2.2.2", PreviousFailures: 3}, expected: []Kind{EventRecovered}},
+		{name: "recovered_below_threshold", result: &updater.Result{IP: "2.2.2.2", PreviousFailures: 2}},
+		{name: "gateway_mismatch", result: &updater.Result{Skipped: true, CurrentGateway: "10.0.0.1"}, expected: []Kind{EventGatewayMismatch}},
+		{name: "gateway_mismatch_repeated", result: &updater.Result{Skipped: true, CurrentGateway: "10.0.0.1", PreviousSkipped: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kinds []Kind
+			for _, event := range Events(tt.result, tt.err, 3) {
+				kinds = append(kinds, event.Kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.expected) {
+				t.Errorf("expected events %v, got %v", tt.expected, kinds)
+			}
+		})
+	}
+
+	event := Events(changed, nil, 3)[0]
+	if event.OldIP != "1.1.1.1" || event.NewIP != "2.2.2.2" || !reflect.DeepEqual(event.Records, []string{"home.example.com", "vpn.example.com"}) {
+		t.Errorf("unexpected ip_changed event %+v", event)
+	}
+
+	event = Events(failed, errors.New("failed to update 1 DNS record(s)"), 3)[0]
+	if event.Failures != 3 || len(event.Errors) != 2 || !strings.Contains(event.Errors[1], "rejected") {
+		t.Errorf("unexpected update_failed event %+v", event)
+	}
+}
+
+// recordingNotifier remembers the events it was sent.
+type recordingNotifier struct {
+	events []Kind
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, event Event) error {
+	n.events = append(n.events, event.Kind)
+	return nil
+}
+
+func TestDispatcher_Send(t *testing.T) {
+	d, err := New(&config.Config{}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if !d.Empty() {
+		t.Errorf("expected no notifiers")
+	}
+
+	all := &recordingNotifier{}
+	failures := &recordingNotifier{}
+	if err := d.add("all", all, nil, nil); err != nil {
+		t.Fatalf("add() error = %v", err)
+	}
+	if err := d.add("failures", failures, nil, []string{"update_failed"}); err != nil {
+		t.Fatalf("add() error = %v", err)
+	}
+	if err := d.add("unknown", failures, nil, []string{"ip_lost"}); err == nil {
+		t.Errorf("expected an unknown event to be rejected")
+	}
+
+	for _, kind := range []Kind{EventIPChanged, EventUpdateFailed} {
+		if err := d.Send(t.Context(), Event{Kind: kind}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if !reflect.DeepEqual(all.events, []Kind{EventIPChanged, EventUpdateFailed}) {
+		t.Errorf("unexpected events %v", all.events)
+	}
+	if !reflect.DeepEqual(failures.events, []Kind{EventUpdateFailed}) {
+		t.Errorf("unexpected events %v", failures.events)
+	}
+}
+
+func TestSample(t *testing.T) {
+	for _, kind := range kinds {
+		parsed, err := ParseKind(string(kind))
+		if err != nil || parsed != kind {
+			t.Errorf("ParseKind(%q) = %q, %v", kind, parsed, err)
+		}
+
+		event := Sample(kind, []string{"home.example.com"})
+		if event.Kind != kind || event.Message() == string(kind) || event.Title() == string(kind) {
+			t.Errorf("unexpected sample %+v", event)
+		}
+	}
+
+	if _, err := ParseKind("ip_lost"); err == nil {
+		t.Errorf("expected an unknown event to be rejected")
+	}
+}
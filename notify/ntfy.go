@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// ntfyTags are the tags, shown as emojis, added to each event.
+var ntfyTags = map[Kind]string{
+	EventIPChanged:       "globe_with_meridians",
+	EventUpdateFailed:    "warning",
+	EventGatewayMismatch: "house",
+	EventRecovered:       "white_check_mark",
+}
+
+// Ntfy publishes notifications to an ntfy topic.
+type Ntfy struct {
+	baseURL  string
+	topic    string
+	token    string
+	priority int
+	tags     []string
+	sender   *sender
+}
+
+func newNtfy(cfg config.Ntfy, s *sender) (*Ntfy, error) {
+	if cfg.Topic == "" {
+		return nil, errors.New("topic is required")
+	}
+	if cfg.Priority < 0 || cfg.Priority > 5 {
+		return nil, errors.New("priority must be between 1 and 5")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = "https://ntfy.sh"
+	}
+
+	return &Ntfy{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		topic:    cfg.Topic,
+		token:    cfg.Token,
+		priority: cfg.Priority,
+		tags:     cfg.Tags,
+		sender:   s,
+	}, nil
+}
+
+// Notify publishes the event to the topic. Failures are sent with a high
+// priority unless a priority is configured.
+func (n *Ntfy) Notify(ctx context.Context, event Event) error {
+	priority := n.priority
+	if priority == 0 {
+		priority = 3
+		if event.Kind == EventUpdateFailed {
+			priority = 4
+		}
+	}
+
+	var headers map[string]string
+	if n.token != "" {
+		headers = map[string]string{"Authorization": "Bearer " + n.token}
+	}
+
+	return n.sender.sendJSON(ctx, n.baseURL+"/", headers, map[string]interface{}{
+		"topic":    n.topic,
+		"title":    event.Title(),
+		"message":  event.Message(),
+		"priority": priority,
+		"tags":     append(slices.Clone(n.tags), ntfyTags[event.Kind]),
+	})
+}
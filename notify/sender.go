@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jpillora/backoff"
+)
+
+// sender makes HTTP requests to notification services, retrying requests that
+// fail on the network or with a server error.
+type sender struct {
+	client  *http.Client
+	retries int
+	backoff backoff.Backoff
+}
+
+func newSender(timeout time.Duration, retries int) *sender {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &sender{
+		client:  &http.Client{Timeout: timeout},
+		retries: max(retries, 0),
+		backoff: backoff.Backoff{Min: time.Second, Max: 30 * time.Second, Jitter: true},
+	}
+}
+
+// send makes the request, returning an error for any response other than 2xx.
+func (s *sender) send(ctx context.Context, method, rawURL string, headers map[string]string, body []byte) error {
+	b := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.attempt(ctx, method, rawURL, headers, body)
+		if err == nil || !retry || attempt >= s.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.Duration()):
+		}
+	}
+}
+
+// attempt makes the request once and reports whether a failure is worth retrying.
+func (s *sender) attempt(ctx context.Context, method, rawURL string, headers map[string]string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return false, hideURL(err, nil)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, hideURL(err, req.URL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("%s %s: %s: %s", method, endpoint(req.URL), resp.Status, bytes.TrimSpace(message))
+}
+
+// sendJSON posts the payload encoded as JSON.
+func (s *sender) sendJSON(ctx context.Context, rawURL string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	for key, value := range headers {
+		jsonHeaders[key] = value
+	}
+
+	return s.send(ctx, http.MethodPost, rawURL, jsonHeaders, body)
+}
+
+// endpoint returns the scheme and host of a URL. The path, query and user info
+// are left out, as services such as Slack, Discord and Gotify keep their tokens
+// there.
+func endpoint(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// hideURL replaces the URL in a request error with its endpoint, or hides it
+// entirely when it could not be parsed.
+func hideURL(err error, u *url.URL) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u != nil {
+			urlErr.URL = endpoint(u)
+		} else {
+			urlErr.URL = "(invalid URL)"
+		}
+	}
+
+	return err
+}
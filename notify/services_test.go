@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeBody decodes the JSON body of the only request the receiver was sent.
+func decodeBody(t *testing.T, recv *receiver) map[string]interface{} {
+	t.Helper()
+
+	if len(recv.bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(recv.bodies))
+	}
+	if contentType := recv.requests[0].Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected a JSON request, got %s", contentType)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(recv.bodies[0]), &body); err != nil {
+		t.Fatalf("expected a JSON body, got %s: %v", recv.bodies[0], err)
+	}
+	return body
+}
+
+func TestSlack(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	n, err := newSlack(config.Slack{WebhookURL: server.URL + "/services/T000/B000/XXX"}, testSender(0))
+	if err != nil {
+		t.Fatalf("newSlack() error = %v", err)
+	}
+	if err := n.Notify(t.Context(), testEvent); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	body := decodeBody(t, recv)
+	if recv.requests[0].URL.Path != "/services/T000/B000/XXX" {
+		t.Errorf("unexpected path %s", recv.requests[0].URL.Path)
+	}
+	if body["text"] != testEvent.Message() {
+		t.Errorf("unexpected text %v", body["text"])
+	}
+	blocks, _ := body["blocks"].([]interface{})
+	if len(blocks) != 4 || blocks[0].(map[string]interface{})["type"] != "header" {
+		t.Errorf("unexpected blocks %v", body["blocks"])
+	}
+
+	if _, err := newSlack(config.Slack{}, testSender(0)); err == nil {
+		t.Errorf("expected a missing webhook_url to be rejected")
+	}
+}
+
+func TestDiscord(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	n, err := newDiscord(config.Discord{WebhookURL: server.URL}, testSender(0))
+	if err != nil {
+		t.Fatalf("newDiscord() error = %v", err)
+	}
+	if err := n.Notify(t.Context(), testEvent); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	body := decodeBody(t, recv)
+	embeds, _ := body["embeds"].([]interface{})
+	if body["username"] != "cloudflare-dyndns" || len(embeds) != 1 {
+		t.Fatalf("unexpected body %s", recv.bodies[0])
+	}
+	embed := embeds[0].(map[string]interface{})
+	if embed["title"] != "IP address changed" || embed["color"] != float64(discordColors[EventIPChanged]) || len(embed["fields"].([]interface{})) != 3 {
+		t.Errorf("unexpected embed %v", embed)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	n, err := newNtfy(config.Ntfy{BaseURL: server.URL + "/", Topic: "dyndns", Token: "tk_secret", Tags: []string{"home"}}, testSender(0))
+	if err != nil {
+		t.Fatalf("newNtfy() error = %v", err)
+	}
+	failed := testEvent
+	failed.Kind = EventUpdateFailed
+	if err := n.Notify(t.Context(), failed); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	body := decodeBody(t, recv)
+	if recv.requests[0].URL.Path != "/" || recv.requests[0].Header.Get("Authorization") != "Bearer tk_secret" {
+		t.Errorf("unexpected request %s %v", recv.requests[0].URL.Path, recv.requests[0].Header)
+	}
+	if body["topic"] != "dyndns" || body["priority"] != float64(4) || body["title"] != failed.Title() {
+		t.Errorf("unexpected body %s", recv.bodies[0])
+	}
+	if tags, _ := body["tags"].([]interface{}); len(tags) != 2 || tags[0] != "home" || tags[1] != "warning" {
+		t.Errorf("unexpected tags %v", body["tags"])
+	}
+
+	if _, err := newNtfy(config.Ntfy{Topic: "dyndns", Priority: 6}, testSender(0)); err == nil {
+		t.Errorf("expected an invalid priority to be rejected")
+	}
+}
+
+func TestGotify(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	n, err := newGotify(config.Gotify{BaseURL: server.URL, Token: "app-token", Priority: 7}, testSender(0))
+	if err != nil {
+		t.Fatalf("newGotify() error = %v", err)
+	}
+	if err := n.Notify(t.Context(), testEvent); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	body := decodeBody(t, recv)
+	if recv.requests[0].URL.Path != "/message" || recv.requests[0].Header.Get("X-Gotify-Key") != "app-token" {
+		t.Errorf("unexpected request %s %v", recv.requests[0].URL.Path, recv.requests[0].Header)
+	}
+	if body["message"] != testEvent.Message() || body["priority"] != float64(7) {
+		t.Errorf("unexpected body %s", recv.bodies[0])
+	}
+
+	if _, err := newGotify(config.Gotify{BaseURL: server.URL}, testSender(0)); err == nil {
+		t.Errorf("expected a missing token to be rejected")
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	n, err := newTelegram(config.Telegram{BaseURL: server.URL, BotToken: "123:secret", ChatID: "-100"}, testSender(0))
+	if err != nil {
+		t.Fatalf("newTelegram() error = %v", err)
+	}
+	if err := n.Notify(t.Context(), testEvent); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	body := decodeBody(t, recv)
+	if recv.requests[0].URL.Path != "/bot123:secret/sendMessage" {
+		t.Errorf("unexpected path %s", recv.requests[0].URL.Path)
+	}
+	if body["chat_id"] != "-100" || !strings.Contains(body["text"].(string), "New IP: 2.2.2.2") {
+		t.Errorf("unexpected body %s", recv.bodies[0])
+	}
+
+	// The bot token is kept out of errors.
+	recv.failures = 1
+	err = n.Notify(t.Context(), testEvent)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected an error without the bot token, got %v", err)
+	}
+}
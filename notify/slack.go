@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Slack posts notifications to a Slack incoming webhook as Block Kit messages.
+type Slack struct {
+	webhookURL string
+	sender     *sender
+}
+
+func newSlack(cfg config.Slack, s *sender) (*Slack, error) {
+	if cfg.WebhookURL == "" {
+		return nil, errors.New("webhook_url is required")
+	}
+
+	return &Slack{webhookURL: cfg.WebhookURL, sender: s}, nil
+}
+
+// Notify posts the event to the webhook.
+func (n *Slack) Notify(ctx context.Context, event Event) error {
+	type text struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	type block struct {
+		Type     string `json:"type"`
+		Text     *text  `json:"text,omitempty"`
+		Fields   []text `json:"fields,omitempty"`
+		Elements []text `json:"elements,omitempty"`
+	}
+
+	blocks := []block{
+		{Type: "header", Text: &text{Type: "plain_text", Text: event.Title()}},
+		{Type: "section", Text: &text{Type: "mrkdwn", Text: event.Message()}},
+	}
+	if fields := eventFields(event); len(fields) > 0 {
+		section := block{Type: "section"}
+		for _, field := range fields {
+			section.Fields = append(section.Fields, text{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", field.name, field.value)})
+		}
+		blocks = append(blocks, section)
+	}
+	blocks = append(blocks, block{Type: "context", Elements: []text{
+		{Type: "mrkdwn", Text: fmt.Sprintf("%s · %s", event.Hostname, event.Time.Format(time.RFC3339))},
+	}})
+
+	return n.sender.sendJSON(ctx, n.webhookURL, nil, map[string]interface{}{
+		"text":   event.Message(),
+		"blocks": blocks,
+	})
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Telegram sends notifications to a chat with the Telegram Bot API.
+type Telegram struct {
+	baseURL  string
+	botToken string
+	chatID   string
+	sender   *sender
+}
+
+func newTelegram(cfg config.Telegram, s *sender) (*Telegram, error) {
+	if cfg.BotToken == "" || cfg.ChatID == "" {
+		return nil, errors.New("bot_token and chat_id are required")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.telegram.org"
+	}
+
+	return &Telegram{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		botToken: cfg.BotToken,
+		chatID:   cfg.ChatID,
+		sender:   s,
+	}, nil
+}
+
+// Notify sends the event to the chat with sendMessage.
+func (n *Telegram) Notify(ctx context.Context, event Event) error {
+	text := event.Title() + "\n\n" + event.Message()
+	for _, field := range eventFields(event) {
+		text += fmt.Sprintf("\n%s: %s", field.name, field.value)
+	}
+
+	err := n.sender.sendJSON(ctx, fmt.Sprintf("%s/bot%s/sendMessage", n.baseURL, n.botToken), nil, map[string]interface{}{
+		"chat_id": n.chatID,
+		"text":    text,
+	})
+	if err != nil {
+		// The bot token is part of the URL, keep it out of the logs.
+		return errors.New(strings.ReplaceAll(err.Error(), n.botToken, "REDACTED"))
+	}
+
+	return nil
+}
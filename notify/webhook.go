@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"bytes"
+	"cloudflare-dyndns/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// DefaultWebhookBody is the template used for webhooks without a body of their own.
+const DefaultWebhookBody = `{"event": {{json .Kind}}, "hostname": {{json .Hostname}}, "time": {{json .Time}}, ` +
+	`"old_ip": {{json .OldIP}}, "new_ip": {{json .NewIP}}, "records": {{json .Records}}, ` +
+	`"errors": {{json .Errors}}, "failures": {{.Failures}}, "message": {{json .Message}}}`
+
+// templateFuncs are available to webhook body templates.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// Webhook sends notifications to a URL, with a body rendered from a Go template.
+type Webhook struct {
+	url     string
+	method  string
+	headers map[string]string
+	body    *template.Template
+	sender  *sender
+}
+
+// newWebhook returns a Webhook for the given configuration.
+func newWebhook(cfg config.Webhook, s *sender) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+
+	body := cfg.Body
+	if body == "" {
+		body = DefaultWebhookBody
+	}
+	tmpl, err := template.New("body").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Webhook{
+		url:     cfg.URL,
+		method:  strings.ToUpper(cfg.Method),
+		headers: map[string]string{"Content-Type": "application/json"},
+		body:    tmpl,
+		sender:  s,
+	}
+	if w.method == "" {
+		w.method = http.MethodPost
+	}
+	if cfg.ContentType != "" {
+		w.headers["Content-Type"] = cfg.ContentType
+	}
+	for key, value := range cfg.Headers {
+		w.headers[http.CanonicalHeaderKey(key)] = value
+	}
+
+	return w, nil
+}
+
+// Notify renders the body for the event and sends it to the webhook.
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, event); err != nil {
+		return err
+	}
+
+	return w.sender.send(ctx, w.method, w.url, w.headers, body.Bytes())
+}
@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"cloudflare-dyndns/config"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is an httptest handler that remembers the requests it was sent and
+// fails the first few of them.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testSender returns a sender that retries without waiting.
+func testSender(retries int) *sender {
+	s := newSender(time.Second, retries)
+	s.backoff.Min = time.Millisecond
+	s.backoff.Max = time.Millisecond
+	return s
+}
+
+var testEvent = Event{
+	Kind:     EventIPChanged,
+	Hostname: "router",
+	Time:     time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	OldIP:    "1.1.1.1",
+	NewIP:    "2.2.2.2",
+	Records:  []string{"home.example.com"},
+}
+
+func TestWebhook_DefaultBody(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	w, err := newWebhook(config.Webhook{URL: server.URL, Headers: map[string]string{"x-api-key": "secret"}}, testSender(0))
+	if err != nil {
+		t.Fatalf("newWebhook() error = %v", err)
+	}
+	if err := w.Notify(t.Context(), testEvent); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	if len(recv.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(recv.requests))
+	}
+	req := recv.requests[0]
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" || req.Header.Get("X-Api-Key") != "secret" {
+		t.Errorf("unexpected request %s %v", req.Method, req.Header)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(recv.bodies[0]), &body); err != nil {
+		t.Fatalf("expected a JSON body, got %s: %v", recv.bodies[0], err)
+	}
+	if body["event"] != "ip_changed" || body["old_ip"] != "1.1.1.1" || body["new_ip"] != "2.2.2.2" || body["hostname"] != "router" {
+		t.Errorf("unexpected body %s", recv.bodies[0])
+	}
+}
+
+func TestWebhook_Template(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	w, err := newWebhook(config.Webhook{
+		URL:         server.URL,
+		Method:      "put",
+		ContentType: "text/plain",
+		Body:        `{{.Hostname}}: {{.OldIP}} -> {{.NewIP}} ({{join .Records ","}})`,
+	}, testSender(0))
+	if err != nil {
+		t.Fatalf("newWebhook() error = %v", err)
+	}
+	if err := w.Notify(t.Context(), testEvent); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	if recv.requests[0].Method != http.MethodPut || recv.requests[0].Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected request %s %v", recv.requests[0].Method, recv.requests[0].Header)
+	}
+	if expected := "router: 1.1.1.1 -> 2.2.2.2 (home.example.com)"; recv.bodies[0] != expected {
+		t.Errorf("expected body %q, got %q", expected, recv.bodies[0])
+	}
+
+	if _, err := newWebhook(config.Webhook{URL: server.URL, Body: "{{.Hostname"}, testSender(0)); err == nil {
+		t.Errorf("expected an invalid template to be rejected")
+	}
+	if _, err := newWebhook(config.Webhook{}, testSender(0)); err == nil {
+		t.Errorf("expected a missing URL to be rejected")
+	}
+}
+
+func TestWebhook_Retries(t *testing.T) {
+	recv := &receiver{failures: 2}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	w, err := newWebhook(config.Webhook{URL: server.URL}, testSender(2))
+	if err != nil {
+		t.Fatalf("newWebhook() error = %v", err)
+	}
+	if err := w.Notify(t.Context(), testEvent); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(recv.requests) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(recv.requests))
+	}
+
+	recv.failures = 5
+	if err := w.Notify(t.Context(), testEvent); err == nil {
+		t.Errorf("expected an error once the retries are used up")
+	}
+	if len(recv.requests) != 6 {
+		t.Errorf("expected 3 more attempts, got %d", len(recv.requests)-3)
+	}
+}
+
+func TestWebhook_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	s := testSender(0)
+	s.client.Timeout = 50 * time.Millisecond
+	w, err := newWebhook(config.Webhook{URL: server.URL}, s)
+	if err != nil {
+		t.Fatalf("newWebhook() error = %v", err)
+	}
+	if err := w.Notify(t.Context(), testEvent); err == nil {
+		t.Errorf("expected the request to time out")
+	}
+}
+
+func TestWebhook_ErrorsHideURL(t *testing.T) {
+	recv := &receiver{failures: 1}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+	closed := httptest.NewServer(recv)
+	closed.Close()
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "status", url: server.URL + "/hooks/T000/secret-token?token=secret-token"},
+		{name: "unreachable", url: closed.URL + "/hooks/T000/secret-token"},
+		{name: "invalid", url: "http://exa mple.com/hooks/secret-token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testSender(0).send(t.Context(), http.MethodPost, tt.url, nil, nil)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if strings.Contains(err.Error(), "secret-token") {
+				t.Errorf("error leaks the URL token: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package prefix
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+const (
+	MinLength = 48
+	MaxLength = 64
+)
+
+// Of returns the IPv6 network prefix of the given address, which may be a plain
+// address or a prefix in CIDR notation.
+func Of(addr string, bits int) (netip.Prefix, error) {
+	if bits < MinLength || bits > MaxLength {
+		return netip.Prefix{}, fmt.Errorf("prefix length must be between /%d and /%d", MinLength, MaxLength)
+	}
+
+	var ip netip.Addr
+	if p, err := netip.ParsePrefix(addr); err == nil {
+		ip = p.Addr()
+	} else if ip, err = netip.ParseAddr(addr); err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not an IPv6 address or prefix", addr)
+	}
+	if !ip.Is6() || ip.Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("%q is not an IPv6 address", addr)
+	}
+
+	return ip.Prefix(bits)
+}
+
+// Rewrite moves addr from the old prefix onto the new one, keeping the host bits.
+func Rewrite(addr netip.Addr, from, to netip.Prefix) (netip.Addr, error) {
+	if from.Bits() != to.Bits() {
+		return netip.Addr{}, errors.New("prefixes must have the same length")
+	}
+	if !from.Contains(addr) {
+		return netip.Addr{}, fmt.Errorf("%s is not within %s", addr, from)
+	}
+
+	src := addr.As16()
+	dst := to.Addr().As16()
+	bits := to.Bits()
+	for i := 0; i < 16; i++ {
+		switch {
+		case (i+1)*8 <= bits:
+			// Entirely network bits, already taken from the new prefix.
+		case i*8 >= bits:
+			dst[i] = src[i]
+		default:
+			mask := byte(0xff >> (bits - i*8))
+			dst[i] = dst[i]&^mask | src[i]&mask
+		}
+	}
+
+	return netip.AddrFrom16(dst), nil
+}
+
+// Plan returns a change for every AAAA record whose content falls within the old
+// prefix. Records listed in skip are left out.
+func Plan(records []cloudflare.DnsRecord, from, to netip.Prefix, skip []string) []cloudflare.RecordChange {
+	var changes []cloudflare.RecordChange
+	if from == to {
+		return changes
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, name := range skip {
+		skipped[name] = true
+	}
+
+	for _, record := range records {
+		if record.Type != "AAAA" || skipped[record.Name] {
+			continue
+		}
+		addr, err := netip.ParseAddr(record.IP)
+		if err != nil || !from.Contains(addr) {
+			continue
+		}
+		newAddr, err := Rewrite(addr, from, to)
+		if err != nil {
+			continue
+		}
+		changes = append(changes, cloudflare.RecordChange{Record: record, OldIP: record.IP, NewIP: newAddr.String()})
+	}
+
+	return changes
+}
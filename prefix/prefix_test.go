@@ -0,0 +1,93 @@
+package prefix
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"net/netip"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		bits    int
+		want    string
+		wantErr bool
+	}{
+		{name: "address64", addr: "2001:db8:1:2:aaaa:bbbb:cccc:dddd", bits: 64, want: "2001:db8:1:2::/64"},
+		{name: "address56", addr: "2001:db8:1:2ff::1", bits: 56, want: "2001:db8:1:200::/56"},
+		{name: "prefixInput", addr: "2001:db8:1::/48", bits: 48, want: "2001:db8:1::/48"},
+		{name: "tooShort", addr: "2001:db8::1", bits: 32, wantErr: true},
+		{name: "tooLong", addr: "2001:db8::1", bits: 96, wantErr: true},
+		{name: "ipv4", addr: "1.2.3.4", bits: 64, wantErr: true},
+		{name: "garbage", addr: "not-an-ip", bits: 64, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Of(tt.addr, tt.bits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Of() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("Of() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		from    string
+		to      string
+		want    string
+		wantErr bool
+	}{
+		{name: "slash64", addr: "2001:db8:1:2::10", from: "2001:db8:1:2::/64", to: "2001:db8:9:8::/64", want: "2001:db8:9:8::10"},
+		{name: "slash48", addr: "2001:db8:1:2::10", from: "2001:db8:1::/48", to: "2001:db8:9::/48", want: "2001:db8:9:2::10"},
+		{name: "slash60", addr: "2001:db8:1:2f::10", from: "2001:db8:1:20::/60", to: "2001:db8:9:90::/60", want: "2001:db8:9:9f::10"},
+		{name: "notInPrefix", addr: "2001:db8:5::1", from: "2001:db8:1::/48", to: "2001:db8:9::/48", wantErr: true},
+		{name: "lengthMismatch", addr: "2001:db8:1::1", from: "2001:db8:1::/48", to: "2001:db8:9::/56", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Rewrite(netip.MustParseAddr(tt.addr), netip.MustParsePrefix(tt.from), netip.MustParsePrefix(tt.to))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rewrite() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("Rewrite() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	records := []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "AAAA", IP: "2001:db8:1:2::1"},
+		{ID: "2", Name: "nas.example.com", Type: "AAAA", IP: "2001:db8:1:2::20"},
+		{ID: "3", Name: "other.example.com", Type: "AAAA", IP: "2001:db8:7:7::1"},
+		{ID: "4", Name: "v4.example.com", Type: "A", IP: "1.2.3.4"},
+		{ID: "5", Name: "printer.example.com", Type: "AAAA", IP: "2001:db8:1:2::30"},
+	}
+	from := netip.MustParsePrefix("2001:db8:1:2::/64")
+	to := netip.MustParsePrefix("2001:db8:3:4::/64")
+
+	changes := Plan(records, from, to, []string{"home.example.com"})
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d: %v", len(changes), changes)
+	}
+	if changes[0].Record.ID != "2" || changes[0].NewIP != "2001:db8:3:4::20" {
+		t.Errorf("unexpected first change: %+v", changes[0])
+	}
+	if changes[1].Record.ID != "5" || changes[1].OldIP != "2001:db8:1:2::30" || changes[1].NewIP != "2001:db8:3:4::30" {
+		t.Errorf("unexpected second change: %+v", changes[1])
+	}
+
+	if changes := Plan(records, from, from, nil); len(changes) != 0 {
+		t.Errorf("expected no changes for an unchanged prefix, got %v", changes)
+	}
+}
@@ -0,0 +1,179 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// State holds what was last published to Cloudflare for a single config file.
+type State struct {
+	LastIPv4     string    `json:"last_ipv4,omitempty"`
+	LastIPv6     string    `json:"last_ipv6,omitempty"`
+	Records      []Record  `json:"records,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitempty"`
+	ReconciledAt time.Time `json:"reconciled_at,omitempty"`
+	// Failures counts the runs that have failed in a row.
+	Failures int `json:"failures,omitempty"`
+	// AwayFromHome is set while runs are skipped because the gateway is not the
+	// home gateway.
+	AwayFromHome bool `json:"away_from_home,omitempty"`
+}
+
+// Record is the address last published for a single DNS record.
+type Record struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	IP   string `json:"ip"`
+}
+
+// Dir returns the directory state files are kept in, following the XDG base
+// directory specification.
+func Dir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, "cloudflare-dyndns"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".local", "state", "cloudflare-dyndns"), nil
+}
+
+// Path returns the state file for the given config file. Each config file gets
+// its own state file so that several zones can be updated from one machine.
+func Path(configFile string) (string, error) {
+	return ProfilePath(configFile, "")
+}
+
+// ProfilePath returns the state file for a profile of the given config file,
+// or for the whole config file when profile is empty.
+func ProfilePath(configFile, profile string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	absPath, err := filepath.Abs(configFile)
+	if err != nil {
+		return "", err
+	}
+	key := absPath
+	name := strings.TrimPrefix(filepath.Base(absPath), ".")
+	if profile != "" {
+		key += "#" + profile
+		name += "-" + filepath.Base(profile)
+	}
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(dir, name+"-"+hex.EncodeToString(sum[:4])+".json"), nil
+}
+
+// ZonePath returns the state file for the given zone, used when the settings
+// come from environment variables instead of a config file.
+func ZonePath(zoneID string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "zone-"+filepath.Base(zoneID)+".json"), nil
+}
+
+// Load reads the state file at path. A missing file results in an empty state.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Save atomically writes the state to path, creating its directory if needed.
+func (s *State) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LastIP returns the last published address for the IPv4 or IPv6 family.
+func (s *State) LastIP(isIPv4 bool) string {
+	if isIPv4 {
+		return s.LastIPv4
+	}
+
+	return s.LastIPv6
+}
+
+// SetLastIP records the address published for its family.
+func (s *State) SetLastIP(ip string, isIPv4 bool) {
+	if isIPv4 {
+		s.LastIPv4 = ip
+	} else {
+		s.LastIPv6 = ip
+	}
+	s.UpdatedAt = time.Now().UTC()
+}
+
+// Record returns the last published record with the given name and type.
+func (s *State) Record(name, recordType string) (Record, bool) {
+	for _, record := range s.Records {
+		if record.Name == name && record.Type == recordType {
+			return record, true
+		}
+	}
+
+	return Record{}, false
+}
+
+// SetRecord stores the record, replacing any earlier record with the same name
+// and type.
+func (s *State) SetRecord(record Record) {
+	for i := range s.Records {
+		if s.Records[i].Name == record.Name && s.Records[i].Type == record.Type {
+			s.Records[i] = record
+			return
+		}
+	}
+	s.Records = append(s.Records, record)
+}
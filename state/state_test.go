@@ -0,0 +1,120 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/var/state")
+
+	first, err := Path("/etc/cloudflare-dyndns/example.com.toml")
+	if err != nil {
+		t.Fatalf("Path() error = %v", err)
+	}
+	if !strings.HasPrefix(first, "/var/state/cloudflare-dyndns/example.com.toml-") || !strings.HasSuffix(first, ".json") {
+		t.Errorf("unexpected state path %s", first)
+	}
+
+	second, err := Path("/etc/cloudflare-dyndns/other/example.com.toml")
+	if err != nil {
+		t.Fatalf("Path() error = %v", err)
+	}
+	if first == second {
+		t.Errorf("expected different config files to use different state files, both got %s", first)
+	}
+
+	profile, err := ProfilePath("/etc/cloudflare-dyndns/example.com.toml", "office")
+	if err != nil {
+		t.Fatalf("ProfilePath() error = %v", err)
+	}
+	if profile == first || !strings.HasPrefix(profile, "/var/state/cloudflare-dyndns/example.com.toml-office-") {
+		t.Errorf("expected the profile to use its own state file, got %s", profile)
+	}
+}
+
+func TestPathWithoutXdgStateHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+
+	path, err := Path("/root/.cloudflare-dyndns")
+	if err != nil {
+		t.Fatalf("Path() error = %v", err)
+	}
+	if filepath.Dir(path) != filepath.Join(home, ".local", "state", "cloudflare-dyndns") {
+		t.Errorf("unexpected state path %s", path)
+	}
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		t.Errorf("expected a visible state file name, got %s", filepath.Base(path))
+	}
+}
+
+func TestLoadAndSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() of a missing file error = %v", err)
+	}
+	if s.LastIP(true) != "" || s.LastIP(false) != "" {
+		t.Errorf("expected an empty state, got %+v", s)
+	}
+
+	s.SetLastIP("1.2.3.4", true)
+	s.SetLastIP("2001:db8::1", false)
+	if err := s.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.LastIPv4 != "1.2.3.4" || loaded.LastIPv6 != "2001:db8::1" || loaded.UpdatedAt.IsZero() {
+		t.Errorf("unexpected loaded state %+v", loaded)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file to remain, got %d entries", len(entries))
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("not-json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Errorf("expected an error for an invalid state file")
+	}
+}
+
+func TestRecords(t *testing.T) {
+	s := &State{}
+	if _, ok := s.Record("home.example.com", "A"); ok {
+		t.Errorf("expected no record in an empty state")
+	}
+
+	s.SetRecord(Record{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"})
+	s.SetRecord(Record{ID: "2", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1"})
+	s.SetRecord(Record{ID: "1", Name: "home.example.com", Type: "A", IP: "2.2.2.2"})
+	s.SetRecord(Record{ID: "3", Name: "home.example.com", Type: "AAAA", IP: "2001:db8::1"})
+
+	if len(s.Records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", s.Records)
+	}
+	if record, ok := s.Record("home.example.com", "A"); !ok || record.IP != "2.2.2.2" {
+		t.Errorf("expected the record to be replaced, got %+v", record)
+	}
+	if record, ok := s.Record("home.example.com", "AAAA"); !ok || record.IP != "2001:db8::1" {
+		t.Errorf("expected the AAAA record to be kept separately, got %+v", record)
+	}
+}
@@ -0,0 +1,169 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Errorf("expected nothing to be sent without NOTIFY_SOCKET, got %v, %v", sent, err)
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix datagram sockets are not available: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+	sent, err := Notify("READY=1\nSTATUS=Running")
+	if !sent || err != nil {
+		t.Fatalf("Notify() = %v, %v", sent, err)
+	}
+
+	buf := make([]byte, 128)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("unable to read the notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=Running" {
+		t.Errorf("unexpected notification %q", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if _, ok := WatchdogInterval(); ok {
+		t.Errorf("expected the watchdog to be disabled")
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if interval, ok := WatchdogInterval(); !ok || interval != 30*time.Second {
+		t.Errorf("expected a 30s watchdog, got %v, %v", interval, ok)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if _, ok := WatchdogInterval(); ok {
+		t.Errorf("expected the watchdog of another process to be ignored")
+	}
+}
+
+func TestUnits(t *testing.T) {
+	opts := UnitOptions{
+		Name:       "cloudflare-dyndns",
+		Mode:       ModeTimer,
+		Binary:     "/usr/local/bin/cloudflare-dyndns",
+		ConfigFile: "/etc/cloudflare-dyndns/example.com.toml",
+		Interval:   10 * time.Minute,
+	}
+
+	units, err := Units(opts)
+	if err != nil {
+		t.Fatalf("Units() error = %v", err)
+	}
+	if len(units) != 2 || units[0].Name != "cloudflare-dyndns.service" || units[1].Name != "cloudflare-dyndns.timer" {
+		t.Fatalf("unexpected units %+v", units)
+	}
+	for _, want := range []string{
+		"Type=oneshot\n",
+		"ExecStart=/usr/local/bin/cloudflare-dyndns update --config %d/config\n",
+		"LoadCredential=config:/etc/cloudflare-dyndns/example.com.toml\n",
+		"DynamicUser=yes\n",
+		"ProtectSystem=strict\n",
+	} {
+		if !strings.Contains(units[0].Content, want) {
+			t.Errorf("expected the service to contain %q, got:\n%s", want, units[0].Content)
+		}
+	}
+	if strings.Contains(units[0].Content, "[Install]") {
+		t.Errorf("expected the oneshot service to be started by the timer only")
+	}
+	if !strings.Contains(units[1].Content, "OnUnitActiveSec=600s\n") {
+		t.Errorf("expected the timer to use the interval, got:\n%s", units[1].Content)
+	}
+
+	opts.Mode = ModeDaemon
+	units, err = Units(opts)
+	if err != nil {
+		t.Fatalf("Units() error = %v", err)
+	}
+	if len(units) != 1 {
+		t.Fatalf("expected only a service in daemon mode, got %+v", units)
+	}
+	for _, want := range []string{"Type=notify\n", "daemon --config %d/config\n", "WatchdogSec=120s\n", "WantedBy=multi-user.target"} {
+		if !strings.Contains(units[0].Content, want) {
+			t.Errorf("expected the service to contain %q, got:\n%s", want, units[0].Content)
+		}
+	}
+
+	opts.ConfigFile = "/etc/cloudflare-dyndns/config.yaml"
+	units, err = Units(opts)
+	if err != nil {
+		t.Fatalf("Units() error = %v", err)
+	}
+	for _, want := range []string{"daemon --config %d/config.yaml\n", "LoadCredential=config.yaml:/etc/cloudflare-dyndns/config.yaml\n"} {
+		if !strings.Contains(units[0].Content, want) {
+			t.Errorf("expected the service to contain %q, got:\n%s", want, units[0].Content)
+		}
+	}
+
+	opts.Profile = "home"
+	units, err = Units(opts)
+	if err != nil {
+		t.Fatalf("Units() error = %v", err)
+	}
+	if want := "daemon --config %d/config.yaml --profile home\n"; !strings.Contains(units[0].Content, want) {
+		t.Errorf("expected the service to contain %q, got:\n%s", want, units[0].Content)
+	}
+
+	opts.Profile = ""
+	opts.AllProfiles = true
+	opts.Mode = ModeTimer
+	units, err = Units(opts)
+	if err != nil {
+		t.Fatalf("Units() error = %v", err)
+	}
+	if want := "update --config %d/config.yaml --all-profiles\n"; !strings.Contains(units[0].Content, want) {
+		t.Errorf("expected the service to contain %q, got:\n%s", want, units[0].Content)
+	}
+
+	for _, invalid := range []UnitOptions{
+		{Name: "bad name", Mode: ModeDaemon, Binary: "/bin/x", ConfigFile: "/etc/x"},
+		{Name: "x", Mode: ModeDaemon, Binary: "x", ConfigFile: "/etc/x"},
+		{Name: "x", Mode: "cron", Binary: "/bin/x", ConfigFile: "/etc/x"},
+		{Name: "x", Mode: ModeDaemon, Binary: "/bin/x", ConfigFile: "/etc/x", Profile: "home", AllProfiles: true},
+		{Name: "x", Mode: ModeDaemon, Binary: "/bin/x", ConfigFile: "/etc/x", Profile: "%h"},
+	} {
+		if _, err := Units(invalid); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir := t.TempDir()
+	paths, err := Install(dir, []Unit{{Name: "a.service", Content: "service"}, {Name: "a.timer", Content: "timer"}})
+	if err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 paths, got %v", paths)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.timer"))
+	if err != nil || string(data) != "timer" {
+		t.Errorf("unexpected timer content %q, %v", data, err)
+	}
+}
@@ -0,0 +1,201 @@
+package systemd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	ModeDaemon = "daemon"
+	ModeTimer  = "timer"
+)
+
+// UnitOptions describes the units to generate.
+type UnitOptions struct {
+	// Name is the unit name without a suffix.
+	Name string
+	// Mode is ModeDaemon for a long-running service, or ModeTimer for a oneshot
+	// update started by a timer.
+	Mode string
+	// Binary is the absolute path of the executable.
+	Binary string
+	// ConfigFile is the absolute path of the config file, passed to the service
+	// as a credential so that the dynamic user can read it.
+	ConfigFile string
+	// Interval is how often the timer starts an update.
+	Interval time.Duration
+	// Watchdog is how long the daemon may go without a watchdog ping.
+	Watchdog time.Duration
+	// Profile is the profile of the config file the service runs with, if any.
+	Profile string
+	// AllProfiles runs the service for every profile of the config file.
+	AllProfiles bool
+}
+
+// Unit is a generated unit file.
+type Unit struct {
+	Name    string
+	Content string
+}
+
+var serviceTemplate = template.Must(template.New("service").Parse(`# Generated by cloudflare-dyndns install-service.
+[Unit]
+Description=Cloudflare dynamic DNS updater
+Documentation=https://github.com/FlashBIOS/cloudflare-dyndns
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+{{- if eq .Mode "daemon" }}
+Type=notify
+ExecStart={{ .Binary }} daemon --config %d/{{ .Credential }}{{ .ProfileArgs }}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=30s
+WatchdogSec={{ .WatchdogSec }}
+{{- else }}
+Type=oneshot
+ExecStart={{ .Binary }} update --config %d/{{ .Credential }}{{ .ProfileArgs }}
+{{- end }}
+LoadCredential={{ .Credential }}:{{ .ConfigFile }}
+DynamicUser=yes
+StateDirectory=cloudflare-dyndns
+Environment=XDG_STATE_HOME=%S
+
+# Hardening.
+CapabilityBoundingSet=
+AmbientCapabilities=
+NoNewPrivileges=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+PrivateDevices=yes
+PrivateTmp=yes
+ProtectClock=yes
+ProtectControlGroups=yes
+ProtectHome=yes
+ProtectHostname=yes
+ProtectKernelLogs=yes
+ProtectKernelModules=yes
+ProtectKernelTunables=yes
+ProtectProc=invisible
+ProtectSystem=strict
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+UMask=0077
+{{- if eq .Mode "daemon" }}
+
+[Install]
+WantedBy=multi-user.target
+{{- end }}
+`))
+
+var timerTemplate = template.Must(template.New("timer").Parse(`# Generated by cloudflare-dyndns install-service.
+[Unit]
+Description=Run the Cloudflare dynamic DNS updater every {{ .Interval }}
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec={{ .IntervalSec }}
+RandomizedDelaySec=30s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+// Units returns the unit files for the given options: a service, plus a timer
+// in timer mode.
+func Units(opts UnitOptions) ([]Unit, error) {
+	if opts.Name == "" || strings.ContainsAny(opts.Name, "/ ") {
+		return nil, fmt.Errorf("%q is not a valid unit name", opts.Name)
+	}
+	if !filepath.IsAbs(opts.Binary) || !filepath.IsAbs(opts.ConfigFile) {
+		return nil, errors.New("the binary and config file must be absolute paths")
+	}
+	if opts.Mode != ModeDaemon && opts.Mode != ModeTimer {
+		return nil, fmt.Errorf("unknown mode %q, expected %q or %q", opts.Mode, ModeDaemon, ModeTimer)
+	}
+	if opts.Profile != "" && opts.AllProfiles {
+		return nil, errors.New("a profile and all profiles cannot both be chosen")
+	}
+	// The profile is written into ExecStart, where quotes, spaces and systemd
+	// specifiers would change its meaning.
+	if strings.ContainsAny(opts.Profile, " \t\n\"'\\%$;") {
+		return nil, fmt.Errorf("%q is not a profile name that can be used in a unit", opts.Profile)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Minute
+	}
+	if opts.Watchdog <= 0 {
+		opts.Watchdog = 2 * time.Minute
+	}
+
+	// The credential keeps the extension of a YAML or JSON config file, so that
+	// its format is still recognised.
+	credential := "config"
+	switch ext := strings.ToLower(filepath.Ext(opts.ConfigFile)); ext {
+	case ".yaml", ".yml", ".json":
+		credential += ext
+	}
+
+	profileArgs := ""
+	if opts.AllProfiles {
+		profileArgs = " --all-profiles"
+	} else if opts.Profile != "" {
+		profileArgs = " --profile " + opts.Profile
+	}
+
+	data := struct {
+		UnitOptions
+		Credential  string
+		ProfileArgs string
+		IntervalSec string
+		WatchdogSec string
+	}{
+		UnitOptions: opts,
+		Credential:  credential,
+		ProfileArgs: profileArgs,
+		IntervalSec: fmt.Sprintf("%ds", int(opts.Interval.Seconds())),
+		WatchdogSec: fmt.Sprintf("%ds", int(opts.Watchdog.Seconds())),
+	}
+
+	var service bytes.Buffer
+	if err := serviceTemplate.Execute(&service, data); err != nil {
+		return nil, err
+	}
+	units := []Unit{{Name: opts.Name + ".service", Content: service.String()}}
+
+	if opts.Mode == ModeTimer {
+		var timer bytes.Buffer
+		if err := timerTemplate.Execute(&timer, data); err != nil {
+			return nil, err
+		}
+		units = append(units, Unit{Name: opts.Name + ".timer", Content: timer.String()})
+	}
+
+	return units, nil
+}
+
+// Install writes the units into dir and returns the paths written.
+func Install(dir string, units []Unit) ([]string, error) {
+	var paths []string
+	for _, unit := range units {
+		path := filepath.Join(dir, unit.Name)
+		if err := os.WriteFile(path, []byte(unit.Content), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,125 @@
+package updater
+
+import (
+	"cloudflare-dyndns/config"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/rs/zerolog"
+)
+
+// Daemon runs the updater on an interval until it is stopped. Failed runs are
+// retried with an increasing delay, up to the configured interval.
+type Daemon struct {
+	logger   zerolog.Logger
+	updater  *Updater
+	cfg      *config.Config
+	reloads  chan *config.Config
+	triggers chan struct{}
+	retry    *backoff.Backoff
+
+	// OnResult, when set, is called after every run.
+	OnResult func(result *Result, err error)
+	// Observer, when set, is told about every run.
+	Observer Observer
+}
+
+// NewDaemon returns a Daemon for the given configuration.
+func NewDaemon(cfg *config.Config, logger zerolog.Logger) *Daemon {
+	return &Daemon{
+		logger:   logger,
+		updater:  New(cfg, logger),
+		cfg:      cfg,
+		reloads:  make(chan *config.Config, 1),
+		triggers: make(chan struct{}, 1),
+		retry:    retryBackoff(cfg.Interval),
+	}
+}
+
+// retryBackoff returns the delays between retries of failed runs, which grow
+// up to the interval.
+func retryBackoff(interval time.Duration) *backoff.Backoff {
+	return &backoff.Backoff{
+		Min:    min(10*time.Second, interval),
+		Max:    interval,
+		Jitter: true,
+	}
+}
+
+// Reload replaces the configuration used from the next run on, and starts that
+// run straight away.
+func (d *Daemon) Reload(cfg *config.Config) {
+	// Drop a reload that has not been picked up yet in favour of this one.
+	select {
+	case <-d.reloads:
+	default:
+	}
+	d.reloads <- cfg
+}
+
+// Trigger starts a run straight away. Triggers received while a run is waiting
+// to start are merged into that run.
+func (d *Daemon) Trigger() {
+	select {
+	case d.triggers <- struct{}{}:
+	default:
+	}
+}
+
+// Run starts the update loop and blocks until the context is cancelled.
+func (d *Daemon) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.logger.Info().Msg("stopping the update loop")
+			return nil
+		case cfg := <-d.reloads:
+			d.logger.Info().Msg("configuration reloaded")
+			d.reload(cfg)
+		case <-d.triggers:
+		case <-timer.C:
+		}
+
+		d.updater.Observer = d.Observer
+		result, err := d.updater.Run(ctx, Options{})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if d.OnResult != nil {
+			d.OnResult(result, err)
+		}
+
+		next := d.nextInterval()
+		if err != nil {
+			next = d.retry.Duration()
+			d.logger.Error().Msg(fmt.Sprintf("update failed, retrying in %s: %v", next.Round(time.Second), err))
+		} else {
+			d.retry.Reset()
+		}
+		timer.Reset(next)
+	}
+}
+
+// reload switches to a new configuration, with the delays between retries
+// worked out again from its interval.
+func (d *Daemon) reload(cfg *config.Config) {
+	d.cfg = cfg
+	d.updater = New(cfg, d.logger)
+	d.retry = retryBackoff(cfg.Interval)
+}
+
+// nextInterval returns the configured interval with a random jitter added, so
+// that several machines started together do not hit the APIs at the same time.
+func (d *Daemon) nextInterval() time.Duration {
+	if d.cfg.Jitter <= 0 {
+		return d.cfg.Interval
+	}
+
+	return d.cfg.Interval + rand.N(d.cfg.Jitter)
+}
@@ -0,0 +1,105 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/config"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestDaemon_Run(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"}}}
+	_, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.Interval = time.Hour
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	results := make(chan *Result, 10)
+	daemon := NewDaemon(cfg, zerolog.Nop())
+	daemon.OnResult = func(result *Result, err error) {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		results <- result
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- daemon.Run(ctx)
+	}()
+
+	// The first run starts straight away.
+	select {
+	case result := <-results:
+		if !result.Changed() {
+			t.Errorf("expected the first run to update the record, got %+v", result.Records)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first run did not start")
+	}
+
+	// A trigger starts another run without waiting for the interval.
+	daemon.Trigger()
+	select {
+	case result := <-results:
+		if result.Changed() {
+			t.Errorf("expected the second run to find nothing to do, got %+v", result.Records)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the triggered run did not start")
+	}
+
+	// A reload also starts another run with the new configuration.
+	reloaded := *cfg
+	reloaded.UpdateRecords = []string{"other.example.com"}
+	daemon.Reload(&reloaded)
+	select {
+	case result := <-results:
+		if len(result.Missing) != 1 || result.Missing[0] != "other.example.com" {
+			t.Errorf("expected the reloaded configuration to be used, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reload did not start a run")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not stop")
+	}
+}
+
+func TestDaemon_NextInterval(t *testing.T) {
+	daemon := NewDaemon(&config.Config{Interval: time.Minute, Jitter: 10 * time.Second}, zerolog.Nop())
+	for i := 0; i < 100; i++ {
+		next := daemon.nextInterval()
+		if next < time.Minute || next >= time.Minute+10*time.Second {
+			t.Fatalf("interval %s outside the expected range", next)
+		}
+	}
+}
+
+func TestDaemon_Reload(t *testing.T) {
+	daemon := NewDaemon(&config.Config{Interval: time.Hour}, zerolog.Nop())
+	if daemon.retry.Min != 10*time.Second || daemon.retry.Max != time.Hour {
+		t.Fatalf("unexpected retry delays %s to %s", daemon.retry.Min, daemon.retry.Max)
+	}
+
+	// A shorter interval also shortens the first retry, which is never longer
+	// than the interval.
+	daemon.reload(&config.Config{Interval: 5 * time.Second})
+	if daemon.retry.Min != 5*time.Second || daemon.retry.Max != 5*time.Second {
+		t.Errorf("expected the retry delays to follow the reloaded interval, got %s to %s", daemon.retry.Min, daemon.retry.Max)
+	}
+	if daemon.cfg.Interval != 5*time.Second {
+		t.Errorf("expected the reloaded configuration to be used")
+	}
+}
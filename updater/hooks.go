@@ -0,0 +1,189 @@
+package updater
+
+import (
+	"bytes"
+	"cloudflare-dyndns/config"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The hooks that run around updates.
+const (
+	HookPreUpdate  = "pre_update"
+	HookPostUpdate = "post_update"
+)
+
+// HookError is returned when a hook command fails.
+type HookError struct {
+	Hook   string
+	Record string
+	Err    error
+	Output string
+}
+
+func (e *HookError) Error() string {
+	message := e.Hook + " hook"
+	if e.Record != "" {
+		message += fmt.Sprintf(" for %q", e.Record)
+	}
+	message += " failed: " + e.Err.Error()
+	if e.Output != "" {
+		message += ": " + e.Output
+	}
+
+	return message
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// hookEnv describes the change a hook is run for.
+type hookEnv struct {
+	hook    string
+	oldIP   string
+	newIP   string
+	records []string
+}
+
+// hookRun runs the hooks of a single run. The global pre_update hook runs once,
+// before the first record is written, and the global post_update hook once at
+// the end, when any record was updated. Record hooks run around each record.
+type hookRun struct {
+	u       *Updater
+	result  *Result
+	oldIP   string
+	pending []string
+	started bool
+	vetoErr error
+}
+
+func (u *Updater) newHookRun(result *Result) *hookRun {
+	return &hookRun{u: u, result: result}
+}
+
+// before runs the pre_update hooks for a record that is about to be written. An
+// error is returned when a failing hook vetoes the update.
+func (h *hookRun) before(ctx context.Context, name, oldIP string) error {
+	if !h.started {
+		h.started = true
+		if command := h.u.cfg.PreUpdateHook; command != "" {
+			env := hookEnv{hook: HookPreUpdate, oldIP: h.oldIP, newIP: h.result.IP, records: h.pending}
+			if err := h.check(h.u.runHook(ctx, command, "", env)); err != nil {
+				h.vetoErr = err
+			}
+		}
+	}
+	if h.vetoErr != nil {
+		return h.vetoErr
+	}
+
+	if hook, ok := h.u.recordHook(name); ok && hook.PreUpdate != "" {
+		env := hookEnv{hook: HookPreUpdate, oldIP: oldIP, newIP: h.result.IP, records: []string{name}}
+		return h.check(h.u.runHook(ctx, hook.PreUpdate, name, env))
+	}
+
+	return nil
+}
+
+// after runs the post_update hook for a record that was written.
+func (h *hookRun) after(ctx context.Context, name, oldIP string) {
+	if hook, ok := h.u.recordHook(name); ok && hook.PostUpdate != "" {
+		env := hookEnv{hook: HookPostUpdate, oldIP: oldIP, newIP: h.result.IP, records: []string{name}}
+		h.record(h.u.runHook(ctx, hook.PostUpdate, name, env))
+	}
+}
+
+// finish runs the global post_update hook when any record was updated.
+func (h *hookRun) finish(ctx context.Context) {
+	command := h.u.cfg.PostUpdateHook
+	if command == "" || !h.result.Changed() {
+		return
+	}
+
+	env := hookEnv{hook: HookPostUpdate, oldIP: h.oldIP, newIP: h.result.IP}
+	for _, record := range h.result.Records {
+		if record.Action == ActionUpdated {
+			env.records = append(env.records, record.Name)
+		}
+	}
+	h.record(h.u.runHook(ctx, command, "", env))
+}
+
+// check returns a failed pre_update hook as an error when hooks veto updates,
+// and otherwise only records it.
+func (h *hookRun) check(err error) error {
+	if err == nil {
+		return nil
+	}
+	if h.u.cfg.HookVeto {
+		return err
+	}
+
+	h.record(err)
+	return nil
+}
+
+func (h *hookRun) record(err error) {
+	if err != nil {
+		h.result.HookErrors = append(h.result.HookErrors, err)
+	}
+}
+
+// recordHook returns the hooks configured for the record with the given name.
+func (u *Updater) recordHook(name string) (config.RecordHook, bool) {
+	for _, hook := range u.cfg.RecordHooks {
+		if hook.Name == name {
+			return hook, true
+		}
+	}
+
+	return config.RecordHook{}, false
+}
+
+// runHook runs a hook command through the shell, with the change described in
+// its environment.
+func (u *Updater) runHook(ctx context.Context, command, record string, env hookEnv) error {
+	timeout := u.cfg.HookTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"CFDDNS_HOOK="+env.hook,
+		"CFDDNS_OLD_IP="+env.oldIP,
+		"CFDDNS_NEW_IP="+env.newIP,
+		"CFDDNS_RECORD="+strings.Join(env.records, ","),
+		"CFDDNS_ZONE="+u.cfg.ZoneID,
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	u.logger.Info().Msg(fmt.Sprintf("running %s hook: %s", env.hook, command))
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		hookErr := &HookError{Hook: env.hook, Record: record, Err: err, Output: strings.TrimSpace(output.String())}
+		u.logger.Error().Msg(hookErr.Error())
+		return hookErr
+	}
+
+	return nil
+}
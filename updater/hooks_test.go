@@ -0,0 +1,169 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/state"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hookLog returns a command that appends the hook environment to a file, and
+// a function that reads the lines written so far.
+func hookLog(t *testing.T) (string, func() []string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests use a POSIX shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "hooks.log")
+	command := `echo "$CFDDNS_HOOK $CFDDNS_RECORD $CFDDNS_OLD_IP $CFDDNS_NEW_IP $CFDDNS_ZONE" >> ` + path
+	return command, func() []string {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+}
+
+func TestUpdater_RunHooks(t *testing.T) {
+	command, lines := hookLog(t)
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"},
+		{ID: "2", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.UpdateRecords = []string{"home.example.com", "vpn.example.com"}
+	cfg.PreUpdateHook = command
+	cfg.PostUpdateHook = command
+	cfg.RecordHooks = []config.RecordHook{{Name: "vpn.example.com", PreUpdate: command, PostUpdate: command}}
+
+	result, err := u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result.HookErrors) != 0 {
+		t.Errorf("unexpected hook errors %v", result.HookErrors)
+	}
+
+	expected := []string{
+		"pre_update home.example.com,vpn.example.com 1.1.1.1 2.2.2.2 zone",
+		"pre_update vpn.example.com 1.1.1.1 2.2.2.2 zone",
+		"post_update vpn.example.com 1.1.1.1 2.2.2.2 zone",
+		"post_update home.example.com,vpn.example.com 1.1.1.1 2.2.2.2 zone",
+	}
+	if got := lines(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected hooks\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	// Nothing runs when no record changes.
+	cfg.ReconcileInterval = 0
+	if _, err := u.Run(t.Context(), Options{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := lines(); len(got) != len(expected) {
+		t.Errorf("expected no more hooks, got %v", got[len(expected):])
+	}
+}
+
+func TestUpdater_RunHooksFollow(t *testing.T) {
+	command, lines := hookLog(t)
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "2.2.2.2"},
+		{ID: "2", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.FollowIP = true
+	cfg.PreUpdateHook = command
+	if err := (&state.State{LastIPv4: "1.1.1.1"}).Save(cfg.StateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only a following record changes, and the global hook is told about it.
+	if _, err := u.Run(t.Context(), Options{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	expected := []string{"pre_update vpn.example.com 1.1.1.1 2.2.2.2 zone"}
+	if got := lines(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected hooks\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestUpdater_RunHookVeto(t *testing.T) {
+	command, lines := hookLog(t)
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"},
+		{ID: "2", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.UpdateRecords = []string{"home.example.com", "vpn.example.com"}
+	cfg.PostUpdateHook = command
+	cfg.RecordHooks = []config.RecordHook{{Name: "vpn.example.com", PreUpdate: "echo not now >&2; exit 1"}}
+
+	// Without veto, a failing hook is reported and the update goes ahead.
+	result, err := u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	var hookErr *HookError
+	if len(result.HookErrors) != 1 || !errors.As(result.HookErrors[0], &hookErr) || hookErr.Record != "vpn.example.com" || hookErr.Output != "not now" {
+		t.Errorf("expected the failed hook to be reported, got %v", result.HookErrors)
+	}
+	if fake.record("2").IP != "2.2.2.2" {
+		t.Errorf("expected vpn.example.com to be updated, got %+v", fake.record("2"))
+	}
+
+	// With veto, the record is left alone and the run fails.
+	cfg.HookVeto = true
+	result, err = u.Run(t.Context(), Options{IP: "3.3.3.3"})
+	if err == nil {
+		t.Fatalf("expected the vetoed record to fail the run")
+	}
+	if fake.record("1").IP != "3.3.3.3" || fake.record("2").IP != "2.2.2.2" {
+		t.Errorf("expected only home.example.com to be updated, got %+v, %+v", fake.record("1"), fake.record("2"))
+	}
+	for _, record := range result.Records {
+		if record.Name == "vpn.example.com" && (record.Action != ActionFailed || !errors.As(record.Error, &hookErr)) {
+			t.Errorf("expected vpn.example.com to be vetoed, got %+v", record)
+		}
+	}
+	if got := lines(); len(got) != 2 || got[1] != "post_update home.example.com 2.2.2.2 3.3.3.3 zone" {
+		t.Errorf("unexpected post_update hooks %v", got)
+	}
+
+	// A vetoing global hook stops every update.
+	cfg.PreUpdateHook = "exit 1"
+	if _, err = u.Run(t.Context(), Options{IP: "4.4.4.4"}); err == nil {
+		t.Fatalf("expected the vetoed run to fail")
+	}
+	if fake.record("1").IP != "3.3.3.3" {
+		t.Errorf("expected home.example.com to be left alone, got %+v", fake.record("1"))
+	}
+}
+
+func TestUpdater_RunHookTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests use a POSIX shell")
+	}
+
+	u, cfg := newTestUpdater(t, &fakeCloudflare{}, "2.2.2.2")
+	cfg.HookTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	err := u.runHook(t.Context(), "sleep 5", "", hookEnv{hook: HookPreUpdate})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected the hook to time out, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("expected the hook to be stopped, took %s", time.Since(start))
+	}
+}
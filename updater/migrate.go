@@ -0,0 +1,103 @@
+package updater
+
+import (
+	"cloudflare-dyndns/prefix"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNoOldPrefix is returned when the prefix to move records away from is not
+// given and cannot be worked out from the state file or the zone.
+var ErrNoOldPrefix = errors.New("no IPv6 address in the state file and no AAAA record from update_records or [[records]] found in the zone")
+
+// MigrateOptions describes a move of the records under one IPv6 prefix onto another.
+type MigrateOptions struct {
+	// From is the old prefix, or an address within it. When empty, the IPv6
+	// address last published is used, or when there is none, that of the first
+	// configured AAAA record in the zone.
+	From string
+	// To is the new prefix, or an address within it. The current public IP
+	// address is used when empty.
+	To string
+	// PrefixLength replaces ipv6.prefix_length when set.
+	PrefixLength int
+}
+
+// Migration is the plan for moving records from one IPv6 prefix to another.
+type Migration struct {
+	From netip.Prefix
+	To   netip.Prefix
+	Plan *Plan
+}
+
+// PlanMigration plans moving every AAAA record under the old prefix onto the
+// new one, keeping the host bits of each address. The plan is written with
+// Apply, so that the hooks run and the state file remembers the moved address,
+// as when an update migrates the prefix.
+func (u *Updater) PlanMigration(opts MigrateOptions) (*Migration, error) {
+	bits := u.cfg.PrefixLength
+	if opts.PrefixLength != 0 {
+		bits = opts.PrefixLength
+	}
+
+	start := time.Now()
+	dnsRecords, dnsErrors, err := u.cloudflare.GetDnsRecords()
+	u.observeRequest("list", start, dnsErrors, err)
+	if err != nil {
+		return nil, &APIError{Op: "failed to get DNS records", Err: err, DnsErrors: dnsErrors}
+	}
+
+	to := opts.To
+	if to == "" {
+		to, err = u.ipify.GetPublicIP()
+		u.observeDetection(ProviderIpify, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve public IP: %w", err)
+		}
+		to = strings.TrimSpace(to)
+	}
+	newPrefix, err := prefix.Of(to, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	// The address last published is the one update moves on from, so it is
+	// preferred over the records, which may already have been moved.
+	lastIp := u.loadState().LastIPv6
+	if lastIp == "" {
+		for _, dnsRecord := range dnsRecords {
+			if dnsRecord.Type == "AAAA" && slices.Contains(u.cfg.RecordNames(), dnsRecord.Name) {
+				lastIp = dnsRecord.IP
+				break
+			}
+		}
+	}
+	from := opts.From
+	if from == "" {
+		from = lastIp
+	}
+	if from == "" {
+		return nil, ErrNoOldPrefix
+	}
+	oldPrefix, err := prefix.Of(from, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Version: PlanVersion, ZoneID: u.cfg.ZoneID, CreatedAt: time.Now().UTC(), Changes: []Change{}}
+	plan.addChanges(prefix.Plan(dnsRecords, oldPrefix, newPrefix, nil), ReasonPrefix)
+
+	// Apply remembers the address of the plan as the last one published, so
+	// that the next update moves on from the new prefix.
+	if addr, err := netip.ParseAddr(lastIp); err == nil && oldPrefix.Contains(addr) {
+		if moved, err := prefix.Rewrite(addr, oldPrefix, newPrefix); err == nil {
+			plan.IP = moved.String()
+		}
+	}
+
+	return &Migration{From: oldPrefix, To: newPrefix, Plan: plan}, nil
+}
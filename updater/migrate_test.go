@@ -0,0 +1,59 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/state"
+	"errors"
+	"testing"
+)
+
+func TestUpdater_PlanMigration(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "AAAA", IP: "2001:db8:2:2::1"},
+		{ID: "2", Name: "nas.example.com", Type: "AAAA", IP: "2001:db8:1:1::20"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2001:db8:2:2::1")
+
+	// Without a state file the prefix of the configured record is used, which
+	// has already been moved.
+	migration, err := u.PlanMigration(MigrateOptions{})
+	if err != nil {
+		t.Fatalf("PlanMigration() error = %v", err)
+	}
+	if migration.From.String() != "2001:db8:2:2::/64" || len(migration.Plan.Changes) != 0 {
+		t.Errorf("expected no changes from the prefix of the configured record, got %+v", migration)
+	}
+
+	// The address last published is preferred.
+	s := &state.State{LastIPv6: "2001:db8:1:1::1"}
+	if err := s.Save(cfg.StateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	migration, err = u.PlanMigration(MigrateOptions{})
+	if err != nil {
+		t.Fatalf("PlanMigration() error = %v", err)
+	}
+	if migration.From.String() != "2001:db8:1:1::/64" || migration.To.String() != "2001:db8:2:2::/64" || len(migration.Plan.Changes) != 1 {
+		t.Fatalf("unexpected migration %+v", migration)
+	}
+
+	result, err := u.Apply(t.Context(), migration.Plan)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(result.Records) != 1 || result.Records[0].Action != ActionUpdated || result.Records[0].Reason != ReasonPrefix {
+		t.Errorf("unexpected result %+v", result.Records)
+	}
+	if record := fake.record("2"); record.IP != "2001:db8:2:2::20" {
+		t.Errorf("record was not moved: %+v", record)
+	}
+	if s, err := state.Load(cfg.StateFilePath); err != nil || s.LastIPv6 != "2001:db8:2:2::1" {
+		t.Errorf("expected the moved address to be remembered, got %+v, %v", s, err)
+	}
+
+	cfg.UpdateRecords = []string{"other.example.com"}
+	cfg.StateFilePath = ""
+	if _, err := u.PlanMigration(MigrateOptions{}); !errors.Is(err, ErrNoOldPrefix) {
+		t.Errorf("expected ErrNoOldPrefix, got %v", err)
+	}
+}
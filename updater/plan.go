@@ -0,0 +1,249 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlanVersion is the version of the plan file format written by WritePlan.
+const PlanVersion = 1
+
+// Plan holds the changes an update would make to a zone, so that they can be
+// reviewed and applied later.
+type Plan struct {
+	Version   int       `json:"version"`
+	ZoneID    string    `json:"zone_id"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	Changes   []Change  `json:"changes"`
+}
+
+// Change is the update of a single record in a plan. Before is the record as
+// it was read from the zone, so that applying the plan can tell when the record
+// has changed since.
+type Change struct {
+	Reason Reason               `json:"reason"`
+	Before cloudflare.DnsRecord `json:"before"`
+	After  cloudflare.DnsRecord `json:"after"`
+}
+
+// FieldChange is a field of a record that a change sets to a new value.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Fields returns the fields of the record that the change sets.
+func (c Change) Fields() []FieldChange {
+	var fields []FieldChange
+	add := func(field, old, new string) {
+		if old != new {
+			fields = append(fields, FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+	add("type", c.Before.Type, c.After.Type)
+	add("content", c.Before.IP, c.After.IP)
+	add("proxied", strconv.FormatBool(c.Before.Proxied), strconv.FormatBool(c.After.Proxied))
+	add("ttl", strconv.Itoa(c.Before.TTL), strconv.Itoa(c.After.TTL))
+	add("comment", c.Before.Comment, c.After.Comment)
+	add("tags", strings.Join(sortedTags(c.Before.Tags), ", "), strings.Join(sortedTags(c.After.Tags), ", "))
+
+	return fields
+}
+
+// add adds the update of a record to the plan.
+func (p *Plan) add(reason Reason, before, after cloudflare.DnsRecord) {
+	p.Changes = append(p.Changes, Change{Reason: reason, Before: before, After: after})
+}
+
+// addChanges adds the address changes planned by follow_ip or migrate_prefix,
+// and returns their results.
+func (p *Plan) addChanges(changes []cloudflare.RecordChange, reason Reason) []RecordResult {
+	var results []RecordResult
+	for _, change := range changes {
+		after := change.Record
+		after.IP = change.NewIP
+		p.add(reason, change.Record, after)
+		results = append(results, RecordResult{
+			ID:     change.Record.ID,
+			Name:   change.Record.Name,
+			Type:   change.Record.Type,
+			OldIP:  change.OldIP,
+			NewIP:  change.NewIP,
+			Action: ActionPlanned,
+			Reason: reason,
+		})
+	}
+
+	return results
+}
+
+// WritePlan writes the plan to a file, for apply.
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// ReadPlan reads a plan written by WritePlan.
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%s is not a plan: %w", path, err)
+	}
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("%s is a plan of version %d, only version %d is supported", path, plan.Version, PlanVersion)
+	}
+
+	return &plan, nil
+}
+
+// DriftError is returned by Apply when records in the plan have changed in the
+// zone since the plan was made.
+type DriftError struct {
+	// Records describes each record that has changed.
+	Records []string
+}
+
+func (e *DriftError) Error() string {
+	return "the zone has changed since the plan was made: " + strings.Join(e.Records, "; ")
+}
+
+// Apply writes the changes of a plan to the zone. Every record is first checked
+// to still be as it was when the plan was made, and nothing is written when any
+// has changed or is gone, in which case a *DriftError is returned. Once writing
+// has started, a failed write does not undo the writes before it.
+func (u *Updater) Apply(ctx context.Context, plan *Plan) (*Result, error) {
+	result := &Result{IP: plan.IP}
+	if addr, err := netip.ParseAddr(plan.IP); err == nil {
+		result.IsIPv4 = addr.Is4()
+	}
+	if plan.ZoneID != u.cfg.ZoneID {
+		return result, fmt.Errorf("the plan is for zone %s, not %s", plan.ZoneID, u.cfg.ZoneID)
+	}
+
+	start := time.Now()
+	dnsRecords, dnsErrors, err := u.cloudflare.GetDnsRecords()
+	u.observeRequest("list", start, dnsErrors, err)
+	if err != nil {
+		return result, &APIError{Op: "failed to get DNS records", Err: err, DnsErrors: dnsErrors}
+	}
+	if err := checkDrift(plan, dnsRecords); err != nil {
+		return result, err
+	}
+
+	hooks := u.newHookRun(result)
+	defer hooks.finish(ctx)
+	for _, change := range plan.Changes {
+		hooks.pending = append(hooks.pending, change.Before.Name)
+		if hooks.oldIP == "" && change.Before.Type == recordType(plan.IP) && change.Before.IP != plan.IP {
+			hooks.oldIP = change.Before.IP
+		}
+	}
+
+	// The configured records are written one at a time, and the records moved
+	// by follow_ip and migrate_prefix in a batch for each, as during a run.
+	batches := map[Reason][]cloudflare.RecordChange{}
+	for _, change := range plan.Changes {
+		if change.Reason != ReasonConfigured {
+			batches[change.Reason] = append(batches[change.Reason], cloudflare.RecordChange{
+				Record: change.Before,
+				OldIP:  change.Before.IP,
+				NewIP:  change.After.IP,
+			})
+			continue
+		}
+
+		recordResult := RecordResult{
+			ID:     change.After.ID,
+			Name:   change.After.Name,
+			Type:   change.After.Type,
+			OldIP:  change.Before.IP,
+			NewIP:  change.After.IP,
+			Reason: change.Reason,
+		}
+		if err := hooks.before(ctx, change.Before.Name, change.Before.IP); err != nil {
+			recordResult.Action = ActionFailed
+			recordResult.Error = err
+			result.Records = append(result.Records, recordResult)
+			continue
+		}
+
+		start := time.Now()
+		dnsErrors, err := u.cloudflare.UpdateDnsRecord(change.After)
+		u.observeRequest("update", start, dnsErrors, err)
+		if err != nil {
+			recordResult.Action = ActionFailed
+			recordResult.Error = &APIError{Op: "failed to update DNS record", Err: err, DnsErrors: dnsErrors}
+			u.logger.Error().Msg(fmt.Sprintf("Failed to update \"%s\": %s", change.After.Name, recordResult.Error))
+		} else {
+			recordResult.Action = ActionUpdated
+			u.logger.Info().Msg(fmt.Sprintf("IP address for \"%s\" updated.", change.After.Name))
+			hooks.after(ctx, change.After.Name, change.Before.IP)
+		}
+		result.Records = append(result.Records, recordResult)
+	}
+	for _, reason := range []Reason{ReasonFollow, ReasonPrefix} {
+		// A failed batch is already counted as failed records below.
+		results, _ := u.applyChanges(ctx, hooks, batches[reason], reason)
+		result.Records = append(result.Records, results...)
+	}
+
+	err = failedRecords(result)
+	u.savePublished(u.loadState(), result, err)
+
+	return result, err
+}
+
+// checkDrift returns a *DriftError when a record in the plan is no longer in
+// the zone as it was when the plan was made.
+func checkDrift(plan *Plan, dnsRecords []cloudflare.DnsRecord) error {
+	var drifted []string
+	for _, change := range plan.Changes {
+		i := slices.IndexFunc(dnsRecords, func(dnsRecord cloudflare.DnsRecord) bool {
+			return dnsRecord.ID == change.Before.ID
+		})
+		if i < 0 {
+			drifted = append(drifted, fmt.Sprintf("%s (%s) no longer exists", change.Before.Name, change.Before.Type))
+			continue
+		}
+
+		current := Change{Before: change.Before, After: dnsRecords[i]}
+		for _, field := range current.Fields() {
+			drifted = append(drifted, fmt.Sprintf("%s (%s) %s was %q and is now %q", change.Before.Name, change.Before.Type, field.Field, field.Old, field.New))
+		}
+		if dnsRecords[i].Name != change.Before.Name {
+			drifted = append(drifted, fmt.Sprintf("%s (%s) was renamed to %s", change.Before.Name, change.Before.Type, dnsRecords[i].Name))
+		}
+	}
+	if len(drifted) > 0 {
+		return &DriftError{Records: drifted}
+	}
+
+	return nil
+}
+
+// sortedTags returns a sorted copy of the tags of a record.
+func sortedTags(tags []string) []string {
+	tags = slices.Clone(tags)
+	slices.Sort(tags)
+
+	return tags
+}
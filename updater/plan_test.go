@@ -0,0 +1,167 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdater_DryRunAndApply(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1", Comment: "old"},
+		{ID: "2", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.FollowIP = true
+
+	result, err := u.Run(t.Context(), Options{Comment: "new", DryRun: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if fake.puts != 0 || fake.patches != 0 {
+		t.Errorf("dry run wrote to the zone: %d puts, %d patches", fake.puts, fake.patches)
+	}
+	if len(result.Records) != 2 || result.Records[0].Action != ActionPlanned || result.Records[1].Action != ActionPlanned {
+		t.Errorf("expected planned record results, got %+v", result.Records)
+	}
+	if result.Plan == nil || result.Plan.ZoneID != "zone" || result.Plan.IP != "2.2.2.2" || len(result.Plan.Changes) != 2 {
+		t.Fatalf("unexpected plan %+v", result.Plan)
+	}
+	expected := []FieldChange{{Field: "content", Old: "1.1.1.1", New: "2.2.2.2"}, {Field: "comment", Old: "old", New: "new"}}
+	if fields := result.Plan.Changes[0].Fields(); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Fields() = %+v, want %+v", fields, expected)
+	}
+	if result.Plan.Changes[1].Reason != ReasonFollow {
+		t.Errorf("expected the second change to follow the IP address, got %+v", result.Plan.Changes[1])
+	}
+	if _, err := os.Stat(cfg.StateFilePath); err == nil {
+		t.Errorf("dry run saved the state")
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := WritePlan(path, result.Plan); err != nil {
+		t.Fatalf("WritePlan() error = %v", err)
+	}
+	plan, err := ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan() error = %v", err)
+	}
+
+	result, err = u.Apply(t.Context(), plan)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(result.Records) != 2 || !result.Changed() {
+		t.Errorf("unexpected result %+v", result.Records)
+	}
+	if record := fake.record("1"); record.IP != "2.2.2.2" || record.Comment != "new" {
+		t.Errorf("record was not updated: %+v", record)
+	}
+	if record := fake.record("2"); record.IP != "2.2.2.2" {
+		t.Errorf("following record was not updated: %+v", record)
+	}
+	if fake.puts != 1 || fake.patches != 1 {
+		t.Errorf("expected the configured record to be put and the following one batched, got %d puts and %d patches", fake.puts, fake.patches)
+	}
+
+	// The plan is now out of date, so applying it again is refused.
+	puts := fake.puts
+	_, err = u.Apply(t.Context(), plan)
+	var driftErr *DriftError
+	if !errors.As(err, &driftErr) || len(driftErr.Records) != 3 {
+		t.Errorf("expected a drift error, got %v", err)
+	}
+	if fake.puts != puts {
+		t.Errorf("plan was applied over a changed zone")
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	record := cloudflare.DnsRecord{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1", Tags: []string{"b", "a"}}
+	plan := &Plan{Changes: []Change{{Reason: ReasonConfigured, Before: record}}}
+
+	tests := []struct {
+		name    string
+		records func(record cloudflare.DnsRecord) []cloudflare.DnsRecord
+		drifted []string
+	}{
+		{
+			name: "unchanged",
+			records: func(record cloudflare.DnsRecord) []cloudflare.DnsRecord {
+				record.Tags = []string{"a", "b"}
+				return []cloudflare.DnsRecord{record}
+			},
+		},
+		{
+			name: "changed",
+			records: func(record cloudflare.DnsRecord) []cloudflare.DnsRecord {
+				record.IP = "3.3.3.3"
+				record.Proxied = true
+				return []cloudflare.DnsRecord{record}
+			},
+			drifted: []string{
+				`home.example.com (A) content was "1.1.1.1" and is now "3.3.3.3"`,
+				`home.example.com (A) proxied was "false" and is now "true"`,
+			},
+		},
+		{
+			name: "renamed",
+			records: func(record cloudflare.DnsRecord) []cloudflare.DnsRecord {
+				record.Name = "old.example.com"
+				return []cloudflare.DnsRecord{record}
+			},
+			drifted: []string{"home.example.com (A) was renamed to old.example.com"},
+		},
+		{
+			name: "deleted",
+			records: func(record cloudflare.DnsRecord) []cloudflare.DnsRecord {
+				return nil
+			},
+			drifted: []string{"home.example.com (A) no longer exists"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDrift(plan, tt.records(record))
+			if tt.drifted == nil {
+				if err != nil {
+					t.Errorf("checkDrift() error = %v", err)
+				}
+				return
+			}
+			var driftErr *DriftError
+			if !errors.As(err, &driftErr) || !reflect.DeepEqual(driftErr.Records, tt.drifted) {
+				t.Errorf("checkDrift() error = %v, want %v", err, tt.drifted)
+			}
+		})
+	}
+}
+
+func TestReadPlan(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid", content: `{"version": 1, "zone_id": "zone", "changes": []}`},
+		{name: "newer", content: `{"version": 2, "zone_id": "zone"}`, wantErr: true},
+		{name: "invalid", content: `not json`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadPlan(path); (err != nil) != tt.wantErr {
+				t.Errorf("ReadPlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
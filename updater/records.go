@@ -0,0 +1,249 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/config"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ProviderURL names a service configured with ip_source as the source of a
+// detected address.
+const ProviderURL = "url"
+
+// CommentData is given to the comment template of a record.
+type CommentData struct {
+	Name  string
+	Type  string
+	IP    string
+	OldIP string
+	// Comment is the comment of the record before the update.
+	Comment string
+	Time    time.Time
+}
+
+// ParseComment parses the comment template of a record.
+func ParseComment(text string) (*template.Template, error) {
+	return template.New("comment").Option("missingkey=error").Parse(text)
+}
+
+// CheckRecords returns a config.KeyError for every problem with the
+// [[records]] blocks, joined into one error.
+func CheckRecords(records []config.Record) error {
+	var errs []error
+	for i, record := range records {
+		key := fmt.Sprintf("records[%d]", i)
+		if record.Name == "" {
+			errs = append(errs, &config.KeyError{Key: key + ".name", Err: errors.New("must be set")})
+		}
+		for _, recordType := range record.Types {
+			if !strings.EqualFold(recordType, "A") && !strings.EqualFold(recordType, "AAAA") {
+				errs = append(errs, &config.KeyError{Key: key + ".types", Err: fmt.Errorf(`%q is not "A" or "AAAA"`, recordType)})
+			}
+		}
+		if err := checkSource(record.IPSource); err != nil {
+			errs = append(errs, &config.KeyError{Key: key + ".ip_source", Err: err})
+		}
+		if record.TTL < 0 || (record.TTL > 1 && record.TTL < 30) || record.TTL > 86400 {
+			errs = append(errs, &config.KeyError{Key: key + ".ttl", Err: errors.New("must be 1 for automatic, or between 30 and 86400 seconds")})
+		}
+		if _, err := ParseComment(record.Comment); err != nil {
+			errs = append(errs, &config.KeyError{Key: key + ".comment", Err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkSource returns an error unless source is empty, "ipify", an HTTP URL or
+// an IP address.
+func checkSource(source string) error {
+	if source == "" || source == ProviderIpify {
+		return nil
+	}
+	if _, err := netip.ParseAddr(source); err == nil {
+		return nil
+	}
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return nil
+	}
+
+	return fmt.Errorf(`%q is not "ipify", an http(s) URL or an IP address`, source)
+}
+
+// target is a record kept up to date during a run, with the address it is given.
+type target struct {
+	config.Record
+	ip         string
+	recordType string
+	// anyType is set for the names in update_records, whose records are given
+	// the address whatever their type.
+	anyType bool
+	comment *template.Template
+}
+
+// matches reports whether the DNS record is kept up to date by the target.
+func (t *target) matches(dnsRecord cloudflare.DnsRecord) bool {
+	return dnsRecord.Name == t.Name && (t.anyType || dnsRecord.Type == t.recordType)
+}
+
+// needsUpdate reports whether the DNS record differs from what the target sets.
+func (t *target) needsUpdate(dnsRecord cloudflare.DnsRecord) bool {
+	if dnsRecord.IP != t.ip {
+		return true
+	}
+	if t.Proxied != nil && dnsRecord.Proxied != *t.Proxied {
+		return true
+	}
+	if t.TTL != 0 && dnsRecord.TTL != t.TTL {
+		return true
+	}
+	if t.Tags != nil {
+		have, want := slices.Clone(dnsRecord.Tags), slices.Clone(t.Tags)
+		slices.Sort(have)
+		slices.Sort(want)
+		return !slices.Equal(have, want)
+	}
+
+	return false
+}
+
+// apply sets the address and settings of the target on the DNS record.
+func (t *target) apply(dnsRecord *cloudflare.DnsRecord, comment string) {
+	dnsRecord.IP = t.ip
+	dnsRecord.Type = t.recordType
+	dnsRecord.Comment = comment
+	if t.Proxied != nil {
+		dnsRecord.Proxied = *t.Proxied
+	}
+	if t.TTL != 0 {
+		dnsRecord.TTL = t.TTL
+	}
+	if t.Tags != nil {
+		dnsRecord.Tags = t.Tags
+	}
+}
+
+// renderComment returns the comment set on the DNS record when it is updated.
+func (t *target) renderComment(dnsRecord cloudflare.DnsRecord, comment string) (string, error) {
+	if comment != "" || t.comment == nil {
+		return comment, nil
+	}
+
+	var text strings.Builder
+	err := t.comment.Execute(&text, CommentData{
+		Name:    t.Name,
+		Type:    t.recordType,
+		IP:      t.ip,
+		OldIP:   dnsRecord.IP,
+		Comment: dnsRecord.Comment,
+		Time:    time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render the comment of %q: %w", t.Name, err)
+	}
+
+	return text.String(), nil
+}
+
+// targets returns the records kept up to date during the run, each with the
+// address it is given. The [[records]] come first so that their settings win
+// over a name that is also in update_records.
+func (u *Updater) targets(opts Options, detected string) ([]target, error) {
+	var records []config.Record
+	var names []string
+	if len(opts.Names) > 0 {
+		for _, name := range opts.Names {
+			found := false
+			for _, record := range u.cfg.Records {
+				if record.Name == name {
+					records = append(records, record)
+					found = true
+				}
+			}
+			if !found {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for _, record := range u.cfg.Records {
+			if record.IsEnabled() {
+				records = append(records, record)
+			}
+		}
+		names = u.cfg.UpdateRecords
+	}
+
+	addresses := map[string]string{}
+	var targets []target
+	for _, record := range records {
+		ip, ok := addresses[record.IPSource]
+		if !ok {
+			var err error
+			if ip, err = u.resolveSource(record.IPSource, detected); err != nil {
+				return nil, err
+			}
+			addresses[record.IPSource] = ip
+		}
+
+		t := target{Record: record, ip: ip, recordType: recordType(ip)}
+		if len(record.Types) > 0 && !slices.ContainsFunc(record.Types, func(s string) bool {
+			return strings.EqualFold(s, t.recordType)
+		}) {
+			u.logger.Debug().Msg(fmt.Sprintf("Skipping \"%s\", as %s is not an address for its types.", record.Name, ip))
+			continue
+		}
+		if record.Comment != "" {
+			comment, err := ParseComment(record.Comment)
+			if err != nil {
+				return nil, fmt.Errorf("invalid comment for %q: %w", record.Name, err)
+			}
+			t.comment = comment
+		}
+		targets = append(targets, t)
+	}
+	for _, name := range names {
+		targets = append(targets, target{Record: config.Record{Name: name}, ip: detected, recordType: recordType(detected), anyType: true})
+	}
+
+	return targets, nil
+}
+
+// resolveSource returns the address given by an ip_source.
+func (u *Updater) resolveSource(source, detected string) (string, error) {
+	if source == "" || source == ProviderIpify {
+		return detected, nil
+	}
+	if addr, err := netip.ParseAddr(source); err == nil {
+		return addr.Unmap().String(), nil
+	}
+
+	ip, err := u.ipify.GetPublicIPFrom(source)
+	var addr netip.Addr
+	if err == nil {
+		if addr, err = netip.ParseAddr(strings.TrimSpace(ip)); err != nil {
+			err = fmt.Errorf("%q is not a valid IP address", ip)
+		}
+	}
+	u.observeDetection(ProviderURL, err)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve public IP from %s: %w", source, err)
+	}
+
+	return addr.Unmap().String(), nil
+}
+
+// recordType returns the type of record holding ip.
+func recordType(ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() {
+		return "AAAA"
+	}
+
+	return "A"
+}
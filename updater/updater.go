@@ -0,0 +1,646 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/follow"
+	"cloudflare-dyndns/ipify"
+	"cloudflare-dyndns/netmatch"
+	"cloudflare-dyndns/prefix"
+	"cloudflare-dyndns/state"
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Action describes what happened to a record during a run.
+type Action string
+
+const (
+	ActionUpdated   Action = "updated"
+	ActionUnchanged Action = "unchanged"
+	ActionFailed    Action = "failed"
+	// ActionPlanned is given to the records a dry run would update.
+	ActionPlanned Action = "planned"
+)
+
+// Reason describes why a record was part of a run.
+type Reason string
+
+const (
+	ReasonConfigured Reason = "configured"
+	ReasonFollow     Reason = "follow"
+	ReasonPrefix     Reason = "prefix"
+)
+
+// Options changes the behaviour of a single run.
+type Options struct {
+	// IP is published instead of the detected public IP address when set.
+	IP string
+	// Names replaces the configured records when set. A name with [[records]]
+	// blocks keeps their settings, even when they are not enabled.
+	Names []string
+	// Comment is set on every updated record. The comment template of each
+	// record, or the default comment, is used when empty.
+	Comment string
+	// Force reads and compares the zone's records even when the state file shows
+	// they already hold the current address.
+	Force bool
+	// DryRun reads the zone and returns the changes that would be made in
+	// Result.Plan, without writing to Cloudflare, running hooks or saving state.
+	DryRun bool
+}
+
+// RecordResult is the outcome of a run for a single record.
+type RecordResult struct {
+	ID     string
+	Name   string
+	Type   string
+	OldIP  string
+	NewIP  string
+	Action Action
+	Reason Reason
+	Error  error
+}
+
+// Result is the outcome of a single run.
+type Result struct {
+	IP      string
+	IsIPv4  bool
+	Skipped bool
+	// SkipReason describes why the run was skipped, such as the network rules
+	// that did not match.
+	SkipReason     string
+	Cached         bool
+	Duration       time.Duration
+	CurrentGateway string
+	Records        []RecordResult
+	Missing        []string
+	// PreviousFailures counts the runs that failed in a row before this one.
+	PreviousFailures int
+	// PreviousSkipped reports whether the run before this one was skipped
+	// because the machine was not on the home network.
+	PreviousSkipped bool
+	// HookErrors holds the hooks that failed without stopping the run.
+	HookErrors []error
+	// Plan holds the changes that a dry run would make.
+	Plan *Plan
+}
+
+// Changed reports whether any record was updated during the run.
+func (r *Result) Changed() bool {
+	return slices.ContainsFunc(r.Records, func(record RecordResult) bool {
+		return record.Action == ActionUpdated
+	})
+}
+
+// APIError is returned when Cloudflare rejects a request.
+type APIError struct {
+	Op        string
+	Err       error
+	DnsErrors []cloudflare.ResponseErrors
+}
+
+func (e *APIError) Error() string {
+	messages := []string{e.Op}
+	if e.Err != nil && e.Err.Error() != "" {
+		messages = append(messages, e.Err.Error())
+	}
+	for _, dnsError := range e.DnsErrors {
+		messages = append(messages, fmt.Sprintf("%s (code: %d)", dnsError.Message, dnsError.Code))
+	}
+
+	return strings.Join(messages, ": ")
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// ProviderIpify names the ipify API as the source of a detected address.
+const ProviderIpify = "ipify"
+
+// Observer is told about the steps of every run, for example to export metrics.
+type Observer interface {
+	// Detected is called after trying to detect the public IP address.
+	Detected(provider string, err error)
+	// CloudflareRequest is called after every request to the Cloudflare API.
+	CloudflareRequest(op string, duration time.Duration, dnsErrors []cloudflare.ResponseErrors, err error)
+	// Finished is called at the end of every run.
+	Finished(result *Result, err error)
+}
+
+// Updater runs the detect-compare-update cycle. The HTTP clients are kept
+// between runs so that long-running callers reuse their connections.
+type Updater struct {
+	cfg        *config.Config
+	logger     zerolog.Logger
+	cloudflare *cloudflare.Client
+	ipify      *ipify.Client
+	probe      netmatch.Probe
+
+	// Observer, when set, is told about every run.
+	Observer Observer
+}
+
+// New returns an Updater for the given configuration.
+func New(cfg *config.Config, logger zerolog.Logger) *Updater {
+	return &Updater{
+		cfg:        cfg,
+		logger:     logger,
+		cloudflare: cloudflare.New(cfg),
+		ipify:      ipify.New(cfg),
+		probe:      netmatch.SystemProbe(),
+	}
+}
+
+// DefaultComment returns the comment set on records updated without an explicit comment.
+func DefaultComment() string {
+	return "Updated " + time.Now().UTC().Format("2006-01-02T15:04:05")
+}
+
+// Run detects the current public IP address and updates every configured record
+// that does not match it. An error is returned when the run could not complete or
+// when any record failed to update; the result describes what was done either way.
+func (u *Updater) Run(ctx context.Context, opts Options) (*Result, error) {
+	start := time.Now()
+	result, err := u.run(ctx, opts)
+	result.Duration = time.Since(start)
+	if !result.Skipped && !opts.DryRun {
+		result.PreviousFailures = u.countFailures(err)
+	}
+	if err == nil && !opts.DryRun {
+		result.PreviousSkipped = u.trackGateway(result.Skipped)
+	}
+	if u.Observer != nil {
+		u.Observer.Finished(result, err)
+	}
+
+	return result, err
+}
+
+func (u *Updater) run(ctx context.Context, opts Options) (*Result, error) {
+	result := &Result{}
+
+	// Only update from the home network, if configured.
+	rules, err := netmatch.Parse(u.cfg)
+	if err != nil {
+		return result, err
+	}
+	if !rules.Empty() {
+		status, err := rules.Check(u.probe)
+		result.CurrentGateway = status.Gateway
+		if err != nil {
+			return result, err
+		}
+		if !status.Matched {
+			u.logger.Info().Msg(fmt.Sprintf("not on the home network, as %s", status.Reason))
+			result.Skipped = true
+			result.SkipReason = status.Reason
+			return result, nil
+		}
+	}
+
+	// Get the current IP address to use.
+	ip := opts.IP
+	if ip == "" {
+		var err error
+		ip, err = u.ipify.GetPublicIP()
+		if err == nil {
+			if _, parseErr := netip.ParseAddr(strings.TrimSpace(ip)); parseErr != nil {
+				err = fmt.Errorf("%q is not a valid IP address", ip)
+			}
+		}
+		u.observeDetection(ProviderIpify, err)
+		if err != nil {
+			return result, fmt.Errorf("failed to retrieve public IP: %w", err)
+		}
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return result, fmt.Errorf("%q is not a valid IP address", ip)
+	}
+	addr = addr.Unmap()
+	result.IP = addr.String()
+	result.IsIPv4 = addr.Is4()
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	targets, err := u.targets(opts, result.IP)
+	if err != nil {
+		return result, err
+	}
+	var names []string
+	for _, t := range targets {
+		if !slices.Contains(names, t.Name) {
+			names = append(names, t.Name)
+		}
+	}
+	newType := recordType(result.IP)
+
+	// Skip Cloudflare entirely when the last run already published every address.
+	lastState := u.loadState()
+	if !opts.Force && !opts.DryRun && u.upToDate(lastState, targets) {
+		for _, t := range targets {
+			record, _ := lastState.Record(t.Name, t.recordType)
+			result.Records = append(result.Records, RecordResult{
+				ID:     record.ID,
+				Name:   record.Name,
+				Type:   record.Type,
+				OldIP:  record.IP,
+				NewIP:  record.IP,
+				Action: ActionUnchanged,
+				Reason: ReasonConfigured,
+			})
+		}
+		result.Cached = true
+		u.logger.Info().Msg(fmt.Sprintf("IP address %s is unchanged since the last update.", result.IP))
+		return result, nil
+	}
+
+	start := time.Now()
+	dnsRecords, dnsErrors, err := u.cloudflare.GetDnsRecords()
+	u.observeRequest("list", start, dnsErrors, err)
+	if err != nil {
+		return result, &APIError{Op: "failed to get DNS records", Err: err, DnsErrors: dnsErrors}
+	}
+
+	if opts.DryRun {
+		result.Plan = &Plan{Version: PlanVersion, ZoneID: u.cfg.ZoneID, IP: result.IP, CreatedAt: time.Now().UTC(), Changes: []Change{}}
+	}
+
+	// Pair each DNS record with the first target keeping it up to date.
+	matched := make([]int, len(dnsRecords))
+	for i, dnsRecord := range dnsRecords {
+		matched[i] = slices.IndexFunc(targets, func(t target) bool {
+			return t.matches(dnsRecord)
+		})
+	}
+
+	// Run the hooks around the records written from here on.
+	var previousIp string
+	hooks := u.newHookRun(result)
+	defer hooks.finish(ctx)
+	for i, dnsRecord := range dnsRecords {
+		if matched[i] >= 0 && targets[matched[i]].needsUpdate(dnsRecord) {
+			hooks.pending = append(hooks.pending, dnsRecord.Name)
+			if previousIp == "" && dnsRecord.Type == newType && dnsRecord.IP != result.IP {
+				previousIp = dnsRecord.IP
+			}
+		}
+	}
+	hooks.oldIP = previousIp
+	if hooks.oldIP == "" {
+		hooks.oldIP = lastState.LastIP(result.IsIPv4)
+	}
+
+	// Plan moving the records that still use the old address before any hook
+	// runs, so that the global pre_update hook is told about them as well.
+	var followChanges, prefixChanges []cloudflare.RecordChange
+	oldIp := lastState.LastIP(result.IsIPv4)
+	if oldIp == "" {
+		oldIp = previousIp
+	}
+	if oldIp != "" && oldIp != result.IP {
+		skip := slices.Clone(names)
+		if u.cfg.FollowIP {
+			filter := follow.Filter{Tags: u.cfg.FollowTags, CommentMarker: u.cfg.FollowComment}
+			followChanges = follow.Plan(dnsRecords, oldIp, result.IP, skip, filter)
+			for _, change := range followChanges {
+				skip = append(skip, change.Record.Name)
+			}
+		}
+
+		if u.cfg.MigratePrefix && !result.IsIPv4 {
+			prefixChanges, err = u.planPrefix(dnsRecords, oldIp, result.IP, skip)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	for _, change := range slices.Concat(followChanges, prefixChanges) {
+		hooks.pending = append(hooks.pending, change.Record.Name)
+	}
+
+	apply := func(changes []cloudflare.RecordChange, reason Reason) ([]RecordResult, error) {
+		if result.Plan != nil {
+			return result.Plan.addChanges(changes, reason), nil
+		}
+		return u.applyChanges(ctx, hooks, changes, reason)
+	}
+
+	found := make([]bool, len(targets))
+	for i, dnsRecord := range dnsRecords {
+		if matched[i] < 0 {
+			continue
+		}
+		t := targets[matched[i]]
+		found[matched[i]] = true
+
+		recordResult := RecordResult{
+			ID:     dnsRecord.ID,
+			Name:   dnsRecord.Name,
+			Type:   t.recordType,
+			OldIP:  dnsRecord.IP,
+			NewIP:  t.ip,
+			Reason: ReasonConfigured,
+		}
+
+		if !t.needsUpdate(dnsRecord) {
+			recordResult.Action = ActionUnchanged
+			u.logger.Info().Msg(fmt.Sprintf("IP address for \"%s\" is already up to date.", dnsRecord.Name))
+			result.Records = append(result.Records, recordResult)
+			continue
+		}
+
+		comment, err := t.renderComment(dnsRecord, opts.Comment)
+		if err == nil && !opts.DryRun {
+			err = hooks.before(ctx, dnsRecord.Name, dnsRecord.IP)
+		}
+		if err != nil {
+			recordResult.Action = ActionFailed
+			recordResult.Error = err
+			result.Records = append(result.Records, recordResult)
+			continue
+		}
+		if comment == "" {
+			comment = DefaultComment()
+		}
+		before := dnsRecord
+		t.apply(&dnsRecord, comment)
+
+		if opts.DryRun {
+			recordResult.Action = ActionPlanned
+			result.Plan.add(ReasonConfigured, before, dnsRecord)
+			result.Records = append(result.Records, recordResult)
+			continue
+		}
+
+		start := time.Now()
+		dnsErrors, err := u.cloudflare.UpdateDnsRecord(dnsRecord)
+		u.observeRequest("update", start, dnsErrors, err)
+		if err != nil {
+			recordResult.Action = ActionFailed
+			recordResult.Error = &APIError{Op: "failed to update DNS record", Err: err, DnsErrors: dnsErrors}
+			u.logger.Error().Msg(fmt.Sprintf("Failed to update \"%s\": %s", dnsRecord.Name, recordResult.Error))
+		} else {
+			recordResult.Action = ActionUpdated
+			u.logger.Info().Msg(fmt.Sprintf("IP address for \"%s\" updated.", dnsRecord.Name))
+			hooks.after(ctx, dnsRecord.Name, recordResult.OldIP)
+		}
+		result.Records = append(result.Records, recordResult)
+	}
+
+	for i, t := range targets {
+		if !found[i] && !slices.Contains(result.Missing, t.Name) {
+			result.Missing = append(result.Missing, t.Name)
+		}
+	}
+	if len(targets) > 0 && !slices.Contains(found, true) {
+		u.logger.Warn().Msg(fmt.Sprintf("Could not find DNS record with name \"%s\".", strings.Join(names, "\", \"")))
+	}
+
+	// Move the records that are not configured by name but still use the old address.
+	results, _ := apply(followChanges, ReasonFollow)
+	result.Records = append(result.Records, results...)
+	// A failed batch is counted as failed records below.
+	results, _ = apply(prefixChanges, ReasonPrefix)
+	result.Records = append(result.Records, results...)
+
+	err = failedRecords(result)
+	if opts.DryRun {
+		return result, err
+	}
+	u.savePublished(lastState, result, err)
+
+	return result, err
+}
+
+// failedRecords returns an error counting the records that failed to update, if any.
+func failedRecords(result *Result) error {
+	failed := 0
+	for _, record := range result.Records {
+		if record.Action == ActionFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to update %d DNS record(s)", failed)
+	}
+
+	return nil
+}
+
+// savePublished remembers what was published in the state file, so that the
+// next run can skip Cloudflare. After a failure the records that were written
+// are still remembered, but the next run reads the zone again, and the last
+// address is kept so that the records left holding it are still found.
+func (u *Updater) savePublished(s *state.State, result *Result, err error) {
+	for _, record := range result.Records {
+		if record.Reason == ReasonConfigured && record.Action != ActionFailed {
+			s.SetRecord(state.Record{ID: record.ID, Name: record.Name, Type: record.Type, IP: record.NewIP})
+		}
+	}
+	if err != nil {
+		s.ReconciledAt = time.Time{}
+	} else {
+		if result.IP != "" {
+			s.SetLastIP(result.IP, result.IsIPv4)
+		}
+		s.ReconciledAt = time.Now().UTC()
+	}
+	u.saveState(s)
+}
+
+// upToDate reports whether the state shows every target already holding its
+// address, and the zone was last read within the reconcile interval.
+func (u *Updater) upToDate(s *state.State, targets []target) bool {
+	if u.cfg.StateFilePath == "" || time.Since(s.ReconciledAt) >= u.cfg.ReconcileInterval {
+		return false
+	}
+
+	for _, t := range targets {
+		record, ok := s.Record(t.Name, t.recordType)
+		if !ok || record.IP != t.ip {
+			return false
+		}
+	}
+
+	return true
+}
+
+// planPrefix plans moving the records under the prefix of oldIp onto the prefix
+// of newIp. An oldIp that is not an IPv6 address, such as a damaged state file,
+// is logged and skips the migration, as the run replaces it once it succeeds.
+func (u *Updater) planPrefix(dnsRecords []cloudflare.DnsRecord, oldIp, newIp string, skip []string) ([]cloudflare.RecordChange, error) {
+	oldPrefix, err := prefix.Of(oldIp, u.cfg.PrefixLength)
+	if err != nil {
+		u.logger.Warn().Msg(fmt.Sprintf("unable to migrate the IPv6 prefix from the last address: %v", err))
+		return nil, nil
+	}
+	newPrefix, err := prefix.Of(newIp, u.cfg.PrefixLength)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := prefix.Plan(dnsRecords, oldPrefix, newPrefix, skip)
+	if len(changes) > 0 {
+		u.logger.Info().Msg(fmt.Sprintf("IPv6 prefix changed from %s to %s, moving %d more record(s).", oldPrefix, newPrefix, len(changes)))
+	}
+
+	return changes, nil
+}
+
+// applyChanges writes the planned changes to Cloudflare in batches. Changes
+// vetoed by a pre_update hook are left out and reported as failed. Writing is
+// not atomic: when a batch fails, the changes of the batches before it are kept
+// and reported as updated, and only the rest as failed.
+func (u *Updater) applyChanges(ctx context.Context, hooks *hookRun, changes []cloudflare.RecordChange, reason Reason) ([]RecordResult, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	var results []RecordResult
+	var allowed []cloudflare.RecordChange
+	for _, change := range changes {
+		if err := hooks.before(ctx, change.Record.Name, change.OldIP); err != nil {
+			results = append(results, RecordResult{
+				ID:     change.Record.ID,
+				Name:   change.Record.Name,
+				Type:   change.Record.Type,
+				OldIP:  change.OldIP,
+				NewIP:  change.NewIP,
+				Action: ActionFailed,
+				Reason: reason,
+				Error:  err,
+			})
+			continue
+		}
+		allowed = append(allowed, change)
+	}
+	changes = allowed
+	if len(changes) == 0 {
+		return results, nil
+	}
+
+	patches := make([]cloudflare.DnsRecordPatch, 0, len(changes))
+	for _, change := range changes {
+		patches = append(patches, cloudflare.DnsRecordPatch{ID: change.Record.ID, IP: change.NewIP})
+	}
+
+	start := time.Now()
+	updated, dnsErrors, err := u.cloudflare.PatchDnsRecords(patches)
+	u.observeRequest("batch", start, dnsErrors, err)
+	if err != nil {
+		err = &APIError{Op: "failed to update DNS records", Err: err, DnsErrors: dnsErrors}
+		u.logger.Error().Msg(err.Error())
+	}
+
+	// The batches before a failed one are still applied, and their records
+	// are returned along with the error.
+	applied := map[string]bool{}
+	for _, dnsRecord := range updated {
+		applied[dnsRecord.ID] = true
+	}
+
+	for _, change := range changes {
+		recordResult := RecordResult{
+			ID:     change.Record.ID,
+			Name:   change.Record.Name,
+			Type:   change.Record.Type,
+			OldIP:  change.OldIP,
+			NewIP:  change.NewIP,
+			Action: ActionUpdated,
+			Reason: reason,
+		}
+		if err != nil && !applied[change.Record.ID] {
+			recordResult.Action = ActionFailed
+			recordResult.Error = err
+		} else {
+			u.logger.Info().Msg(fmt.Sprintf("IP address for \"%s\" updated to %s.", change.Record.Name, change.NewIP))
+			hooks.after(ctx, change.Record.Name, change.OldIP)
+		}
+		results = append(results, recordResult)
+	}
+
+	return results, err
+}
+
+func (u *Updater) observeDetection(provider string, err error) {
+	if u.Observer != nil {
+		u.Observer.Detected(provider, err)
+	}
+}
+
+func (u *Updater) observeRequest(op string, start time.Time, dnsErrors []cloudflare.ResponseErrors, err error) {
+	if u.Observer != nil {
+		u.Observer.CloudflareRequest(op, time.Since(start), dnsErrors, err)
+	}
+}
+
+// countFailures keeps the number of runs that failed in a row in the state file,
+// so that it carries over between runs from cron. It returns the number of runs
+// that failed in a row before this one.
+func (u *Updater) countFailures(err error) int {
+	s := u.loadState()
+	previous := s.Failures
+	if err == nil && previous == 0 {
+		return 0
+	}
+
+	if err != nil {
+		s.Failures++
+	} else {
+		s.Failures = 0
+	}
+	u.saveState(s)
+
+	return previous
+}
+
+// trackGateway keeps whether runs are being skipped because the machine is away
+// from the home network in the state file. It returns whether the run before this one was skipped.
+func (u *Updater) trackGateway(skipped bool) bool {
+	s := u.loadState()
+	previous := s.AwayFromHome
+	if previous != skipped {
+		s.AwayFromHome = skipped
+		u.saveState(s)
+	}
+
+	return previous
+}
+
+// loadState reads the state file for the current config. Problems reading it
+// are logged and treated as an empty state.
+func (u *Updater) loadState() *state.State {
+	if u.cfg.StateFilePath == "" {
+		return &state.State{}
+	}
+
+	s, err := state.Load(u.cfg.StateFilePath)
+	if err != nil {
+		u.logger.Warn().Msg(fmt.Sprintf("unable to read state file %s: %v", u.cfg.StateFilePath, err))
+		return &state.State{}
+	}
+
+	return s
+}
+
+// saveState writes the state file for the current config, logging any problem.
+func (u *Updater) saveState(s *state.State) {
+	if u.cfg.StateFilePath == "" {
+		return
+	}
+
+	if err := s.Save(u.cfg.StateFilePath); err != nil {
+		u.logger.Warn().Msg(fmt.Sprintf("unable to write state file %s: %v", u.cfg.StateFilePath, err))
+	}
+}
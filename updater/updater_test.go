@@ -0,0 +1,442 @@
+package updater
+
+import (
+	"cloudflare-dyndns/cloudflare"
+	"cloudflare-dyndns/config"
+	"cloudflare-dyndns/netmatch"
+	"cloudflare-dyndns/state"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// fakeCloudflare is an in-memory stand-in for the parts of the Cloudflare API used by the updater.
+type fakeCloudflare struct {
+	mu      sync.Mutex
+	records []cloudflare.DnsRecord
+	lists   int
+	puts    int
+	patches int
+	fail    bool
+	// failBatch fails the batch request with this number, counting from 1.
+	failBatch int
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fail {
+		_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 10000, "message": "Authentication error"}]}`))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/dns_records"):
+		f.lists++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": f.records})
+	case r.Method == http.MethodPut:
+		f.puts++
+		var record cloudflare.DnsRecord
+		_ = json.NewDecoder(r.Body).Decode(&record)
+		for i := range f.records {
+			if f.records[i].ID == record.ID {
+				f.records[i] = record
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": record})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/batch"):
+		f.patches++
+		if f.patches == f.failBatch {
+			_, _ = w.Write([]byte(`{"success": false, "errors": [{"code": 1004, "message": "DNS Validation Error"}]}`))
+			return
+		}
+		var batch struct {
+			Patches []cloudflare.DnsRecordPatch `json:"patches"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		var updated []cloudflare.DnsRecord
+		for _, patch := range batch.Patches {
+			for i := range f.records {
+				if f.records[i].ID == patch.ID {
+					f.records[i].IP = patch.IP
+					updated = append(updated, f.records[i])
+				}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": map[string]interface{}{"patches": updated}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeCloudflare) record(id string) cloudflare.DnsRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, record := range f.records {
+		if record.ID == id {
+			return record
+		}
+	}
+	return cloudflare.DnsRecord{}
+}
+
+func newTestUpdater(t *testing.T, fake *fakeCloudflare, ip string) (*Updater, *config.Config) {
+	t.Helper()
+
+	cloudflareServer := httptest.NewServer(fake)
+	t.Cleanup(cloudflareServer.Close)
+	ipifyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ip))
+	}))
+	t.Cleanup(ipifyServer.Close)
+
+	cfg := &config.Config{
+		APIToken:          "token",
+		BaseURL:           cloudflareServer.URL,
+		ZoneID:            "zone",
+		UpdateRecords:     []string{"home.example.com"},
+		IpifyURL:          ipifyServer.URL,
+		PrefixLength:      64,
+		StateFilePath:     filepath.Join(t.TempDir(), "state.json"),
+		ReconcileInterval: 0,
+	}
+
+	return New(cfg, zerolog.Nop()), cfg
+}
+
+func TestUpdater_Run(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"},
+		{ID: "2", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1"},
+	}}
+	u, _ := newTestUpdater(t, fake, "2.2.2.2")
+
+	result, err := u.Run(t.Context(), Options{Comment: "test"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.IP != "2.2.2.2" || !result.IsIPv4 || !result.Changed() {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if len(result.Records) != 1 || result.Records[0].Action != ActionUpdated || result.Records[0].OldIP != "1.1.1.1" {
+		t.Errorf("unexpected record results %+v", result.Records)
+	}
+	if record := fake.record("1"); record.IP != "2.2.2.2" || record.Comment != "test" {
+		t.Errorf("record was not updated: %+v", record)
+	}
+	if record := fake.record("2"); record.IP != "1.1.1.1" {
+		t.Errorf("record outside update_records was changed: %+v", record)
+	}
+
+	// A second run finds nothing to do.
+	result, err = u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Changed() || result.Records[0].Action != ActionUnchanged {
+		t.Errorf("expected no changes, got %+v", result.Records)
+	}
+}
+
+func TestUpdater_RunRecords(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1", Proxied: true, TTL: 1},
+		{ID: "2", Name: "home.example.com", Type: "AAAA", IP: "2001:db8::1", Comment: "keep"},
+		{ID: "3", Name: "vpn.example.com", Type: "A", IP: "1.1.1.1"},
+		{ID: "4", Name: "static.example.com", Type: "A", IP: "9.9.9.9"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	ipv6Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("2001:db8::2"))
+	}))
+	t.Cleanup(ipv6Server.Close)
+
+	proxied, enabled := false, false
+	cfg.UpdateRecords = nil
+	cfg.Records = []config.Record{
+		{Name: "home.example.com", Types: []string{"A"}, Proxied: &proxied, TTL: 300, Tags: []string{"dyndns"}, Comment: "{{.OldIP}} -> {{.IP}}"},
+		{Name: "home.example.com", Types: []string{"AAAA"}, IPSource: ipv6Server.URL, Comment: "{{.Comment}}"},
+		{Name: "vpn.example.com", Enabled: &enabled},
+		{Name: "static.example.com", IPSource: "5.5.5.5"},
+	}
+
+	result, err := u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result.Records) != 3 || len(result.Missing) != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if record := fake.record("1"); record.IP != "2.2.2.2" || record.Proxied || record.TTL != 300 || len(record.Tags) != 1 || record.Comment != "1.1.1.1 -> 2.2.2.2" {
+		t.Errorf("A record was not updated with its settings: %+v", record)
+	}
+	if record := fake.record("2"); record.IP != "2001:db8::2" || record.Type != "AAAA" || record.Comment != "keep" {
+		t.Errorf("AAAA record was not updated from its source: %+v", record)
+	}
+	if record := fake.record("3"); record.IP != "1.1.1.1" {
+		t.Errorf("disabled record was changed: %+v", record)
+	}
+	if record := fake.record("4"); record.IP != "5.5.5.5" {
+		t.Errorf("record was not given its fixed address: %+v", record)
+	}
+
+	// A second run finds every record up to date, including its settings.
+	result, err = u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Changed() {
+		t.Errorf("expected no changes, got %+v", result.Records)
+	}
+}
+
+func TestUpdater_RunMissingAndInvalid(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"}}}
+	u, _ := newTestUpdater(t, fake, "not-an-ip")
+
+	if _, err := u.Run(t.Context(), Options{}); err == nil {
+		t.Errorf("expected an error for an invalid IP address")
+	}
+
+	result, err := u.Run(t.Context(), Options{IP: "1.1.1.1", Names: []string{"home.example.com", "gone.example.com"}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result.Missing) != 1 || result.Missing[0] != "gone.example.com" {
+		t.Errorf("expected gone.example.com to be missing, got %v", result.Missing)
+	}
+
+	fake.fail = true
+	_, err = u.Run(t.Context(), Options{IP: "3.3.3.3"})
+	if apiErr, ok := err.(*APIError); !ok || len(apiErr.DnsErrors) != 1 {
+		t.Errorf("expected an API error, got %v", err)
+	}
+}
+
+func TestUpdater_RunFollowAndPrefix(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "AAAA", IP: "2001:db8:1:1::1"},
+		{ID: "2", Name: "vpn.example.com", Type: "AAAA", IP: "2001:db8:1:1::1"},
+		{ID: "3", Name: "nas.example.com", Type: "AAAA", IP: "2001:db8:1:1::20"},
+		{ID: "4", Name: "far.example.com", Type: "AAAA", IP: "2001:db8:5:5::20"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2001:db8:2:2::1")
+	cfg.FollowIP = true
+	cfg.MigratePrefix = true
+
+	result, err := u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result.Records) != 3 {
+		t.Fatalf("expected 3 record results, got %+v", result.Records)
+	}
+
+	expected := map[string]string{"1": "2001:db8:2:2::1", "2": "2001:db8:2:2::1", "3": "2001:db8:2:2::20", "4": "2001:db8:5:5::20"}
+	for id, ip := range expected {
+		if record := fake.record(id); record.IP != ip {
+			t.Errorf("expected record %s to have %s, got %s", id, ip, record.IP)
+		}
+	}
+	if result.Records[1].Reason != ReasonFollow || result.Records[2].Reason != ReasonPrefix {
+		t.Errorf("unexpected reasons %+v", result.Records)
+	}
+}
+
+func TestUpdater_RunInvalidLastIP(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{{ID: "1", Name: "home.example.com", Type: "AAAA", IP: "2001:db8:1:1::1"}}}
+	u, cfg := newTestUpdater(t, fake, "2001:db8:2:2::1")
+	cfg.MigratePrefix = true
+	var logs strings.Builder
+	u.logger = zerolog.New(&logs)
+	if err := (&state.State{LastIPv6: "not an address"}).Save(cfg.StateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := u.Run(t.Context(), Options{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.Contains(logs.String(), "unable to migrate the IPv6 prefix") {
+		t.Errorf("expected a warning about the last address, got %s", logs.String())
+	}
+	if s, err := state.Load(cfg.StateFilePath); err != nil || s.LastIPv6 != "2001:db8:2:2::1" {
+		t.Errorf("expected the last address to be replaced, got %+v, %v", s, err)
+	}
+}
+
+func TestUpdater_RunPartialBatch(t *testing.T) {
+	records := []cloudflare.DnsRecord{{ID: "home", Name: "home.example.com", Type: "A", IP: "1.1.1.1"}}
+	for i := range 201 {
+		records = append(records, cloudflare.DnsRecord{ID: fmt.Sprint(i), Name: fmt.Sprintf("host%d.example.com", i), Type: "A", IP: "1.1.1.1"})
+	}
+	fake := &fakeCloudflare{records: records, failBatch: 2}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.FollowIP = true
+
+	result, err := u.Run(t.Context(), Options{})
+	if err == nil || err.Error() != "failed to update 1 DNS record(s)" {
+		t.Fatalf("expected one failed record, got %v", err)
+	}
+	actions := map[Action]int{}
+	for _, record := range result.Records {
+		actions[record.Action]++
+	}
+	if actions[ActionUpdated] != 201 || actions[ActionFailed] != 1 {
+		t.Errorf("expected the first batch to be reported as updated, got %v", actions)
+	}
+	if failed := result.Records[len(result.Records)-1]; failed.Action != ActionFailed || failed.Name != "host200.example.com" {
+		t.Errorf("expected the record of the failed batch to fail, got %+v", failed)
+	}
+
+	// The records written are remembered, but the next run reads the zone again.
+	s, err := state.Load(cfg.StateFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, ok := s.Record("home.example.com", "A"); !ok || record.IP != "2.2.2.2" || s.LastIPv4 != "" || !s.ReconciledAt.IsZero() {
+		t.Errorf("unexpected state %+v", s)
+	}
+}
+
+func TestUpdater_RunCached(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"}}}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.ReconcileInterval = time.Hour
+
+	if _, err := u.Run(t.Context(), Options{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// The unchanged address is served from the state file.
+	result, err := u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.Cached || len(result.Records) != 1 || result.Records[0].ID != "1" || result.Records[0].Action != ActionUnchanged {
+		t.Errorf("expected a cached result, got %+v", result)
+	}
+	if fake.lists != 1 {
+		t.Errorf("expected the zone to be listed once, got %d", fake.lists)
+	}
+
+	// Forcing a run reads the zone again.
+	if result, err = u.Run(t.Context(), Options{Force: true}); err != nil || result.Cached {
+		t.Errorf("expected a forced run to skip the cache, got %+v, %v", result, err)
+	}
+	if fake.lists != 2 {
+		t.Errorf("expected the zone to be listed twice, got %d", fake.lists)
+	}
+
+	// A changed address reads the zone again.
+	if result, err = u.Run(t.Context(), Options{IP: "3.3.3.3"}); err != nil || result.Cached || !result.Changed() {
+		t.Errorf("expected a changed address to update the record, got %+v, %v", result, err)
+	}
+
+	// The zone is read again once the reconcile interval has passed.
+	cfg.ReconcileInterval = 0
+	if result, err = u.Run(t.Context(), Options{IP: "3.3.3.3"}); err != nil || result.Cached {
+		t.Errorf("expected the zone to be reconciled, got %+v, %v", result, err)
+	}
+	if fake.lists != 4 {
+		t.Errorf("expected the zone to be listed four times, got %d", fake.lists)
+	}
+}
+
+func TestUpdater_RunCountsFailures(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"}}, fail: true}
+	u, _ := newTestUpdater(t, fake, "2.2.2.2")
+
+	for i := 0; i < 3; i++ {
+		result, err := u.Run(t.Context(), Options{})
+		if err == nil {
+			t.Fatalf("expected run %d to fail", i)
+		}
+		if result.PreviousFailures != i {
+			t.Errorf("expected %d previous failures, got %d", i, result.PreviousFailures)
+		}
+	}
+
+	fake.mu.Lock()
+	fake.fail = false
+	fake.mu.Unlock()
+
+	result, err := u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.PreviousFailures != 3 {
+		t.Errorf("expected the recovered run to see 3 previous failures, got %d", result.PreviousFailures)
+	}
+	if result, _ = u.Run(t.Context(), Options{}); result.PreviousFailures != 0 {
+		t.Errorf("expected the failures to be reset, got %d", result.PreviousFailures)
+	}
+}
+
+func TestUpdater_RunNetworkRules(t *testing.T) {
+	fake := &fakeCloudflare{records: []cloudflare.DnsRecord{
+		{ID: "1", Name: "home.example.com", Type: "A", IP: "1.1.1.1"},
+	}}
+	u, cfg := newTestUpdater(t, fake, "2.2.2.2")
+	cfg.HomeGateway = "192.168.1.1"
+	cfg.Network.ForbidInterfaces = []string{"wg0"}
+	vpnUp := true
+	u.probe = netmatch.Probe{
+		Gateway: func() (netip.Addr, error) {
+			return netip.MustParseAddr("192.168.1.1"), nil
+		},
+		InterfaceUp: func(name string) (bool, error) {
+			return vpnUp, nil
+		},
+	}
+
+	result, err := u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.Skipped || result.SkipReason != "interface wg0 is up" || result.CurrentGateway != "192.168.1.1" {
+		t.Errorf("expected the run to be skipped, got %+v", result)
+	}
+	if fake.lists != 0 {
+		t.Errorf("expected no requests to Cloudflare, got %d", fake.lists)
+	}
+
+	vpnUp = false
+	result, err = u.Run(t.Context(), Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Skipped || !result.Changed() || !result.PreviousSkipped {
+		t.Errorf("expected the record to be updated, got %+v", result)
+	}
+}
+
+func TestUpdater_TrackGateway(t *testing.T) {
+	u, _ := newTestUpdater(t, &fakeCloudflare{}, "2.2.2.2")
+
+	steps := []struct {
+		skipped  bool
+		previous bool
+	}{
+		{skipped: false, previous: false},
+		{skipped: true, previous: false},
+		{skipped: true, previous: true},
+		{skipped: false, previous: true},
+		{skipped: false, previous: false},
+	}
+	for i, step := range steps {
+		if previous := u.trackGateway(step.skipped); previous != step.previous {
+			t.Errorf("step %d: expected previous %v, got %v", i, step.previous, previous)
+		}
+	}
+}